@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -49,6 +50,53 @@ func serviceAccountPath() string {
 	return ""
 }
 
+// verifyRequestToken verifies the Firebase ID token in the request's
+// Authorization header
+func verifyRequestToken(r *http.Request) (*auth.Token, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, fmt.Errorf("missing authorization header")
+	}
+
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return nil, fmt.Errorf("invalid authorization header format")
+	}
+
+	token, err := firebaseAuth.VerifyIDToken(r.Context(), parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid or expired token: %w", err)
+	}
+	return token, nil
+}
+
+// ensureUser creates the token's user in SQLite on their first login, and
+// reports whether it did
+func ensureUser(ctx context.Context, token *auth.Token) (bool, error) {
+	// Check if user exists in SQLite, create if not (first login)
+	user, err := GetUserByUID(ctx, token.UID)
+	if err != nil {
+		return false, fmt.Errorf("failed to check user existence: %w", err)
+	}
+	if user != nil {
+		return false, nil
+	}
+
+	// User doesn't exist - create them (first login)
+	email := ""
+	if emailClaim, ok := token.Claims["email"]; ok {
+		if e, ok := emailClaim.(string); ok {
+			email = e
+		}
+	}
+
+	log.Printf("First login for user %s (%s), creating user record", token.UID, email)
+	if _, err := CreateUser(ctx, token.UID, email, "viewer"); err != nil {
+		return false, fmt.Errorf("failed to create user: %w", err)
+	}
+	return true, nil
+}
+
 // AuthMiddleware validates Firebase ID token from Authorization header
 func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Point-in-time backups are full copies of the database stored under
+// backups/ as <timestamp>-<reason>.db. They are independent of the replica,
+// so they survive compaction and can be restored with cmd/manage-backups.
+const (
+	backupPrefix = "backups/"
+
+	// backupTimeFormat sorts lexically in time order
+	backupTimeFormat = "20060102T150405Z"
+
+	backupReasonScheduled    = "scheduled"
+	backupReasonPreMigration = "pre-migration"
+
+	// defaultBackupInterval is how often a scheduled backup is taken (override
+	// with DB_BACKUP_INTERVAL, 0 disables)
+	defaultBackupInterval = time.Hour
+
+	// Retention: one backup per hour for a day, then one per day for a month,
+	// then one per month forever
+	backupHourlyRetention = 24 * time.Hour
+	backupDailyRetention  = 30 * 24 * time.Hour
+)
+
+// backupKey returns the object key for a backup taken at t
+func backupKey(t time.Time, reason string) string {
+	return backupPrefix + t.UTC().Format(backupTimeFormat) + "-" + reason + ".db"
+}
+
+// parseBackupKey extracts the time and reason from a backup object key
+func parseBackupKey(key string) (time.Time, string, bool) {
+	name := strings.TrimSuffix(path.Base(key), ".db")
+	stamp, reason, ok := strings.Cut(name, "-")
+	if !ok {
+		return time.Time{}, "", false
+	}
+	t, err := time.Parse(backupTimeFormat, stamp)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	return t, reason, true
+}
+
+// backupInterval returns how often scheduled backups are taken
+func backupInterval() time.Duration {
+	if v := os.Getenv("DB_BACKUP_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d
+		}
+		log.Printf("Warning: invalid DB_BACKUP_INTERVAL %q, using %s", v, defaultBackupInterval)
+	}
+	return defaultBackupInterval
+}
+
+// takeBackup copies conn's database, verifies the copy and uploads it as a
+// backup. lock, if non-nil, is held only while the copy is made.
+func takeBackup(ctx context.Context, conn *sql.DB, lock *sync.RWMutex, reason string) (string, error) {
+	// Scheduled and pre-migration backups can run at the same time
+	backupPath := fmt.Sprintf("%s.backup-%d", localDBPath, time.Now().UnixNano())
+	defer os.Remove(backupPath)
+
+	if lock != nil {
+		lock.RLock()
+	}
+	err := vacuumInto(ctx, conn, backupPath)
+	if lock != nil {
+		lock.RUnlock()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := checkIntegrity(ctx, backupPath); err != nil {
+		return "", fmt.Errorf("refusing to upload backup: %w", err)
+	}
+
+	f, err := os.Open(backupPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	key := backupKey(time.Now(), reason)
+	_, err = blobStore.Put(ctx, key, f, PutOptions{
+		ContentType: "application/x-sqlite3",
+		IfNotExists: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload backup: %w", err)
+	}
+
+	log.Printf("Uploaded database backup %s", key)
+	return key, nil
+}
+
+// backupBeforeMigration backs up conn's database before a migration changes it.
+// The migration is aborted if the backup fails.
+func backupBeforeMigration(ctx context.Context, conn *sql.DB, migration string) error {
+	if blobStore == nil {
+		return nil
+	}
+	log.Printf("Backing up database before migration: %s", migration)
+	if _, err := takeBackup(ctx, conn, nil, backupReasonPreMigration); err != nil {
+		return fmt.Errorf("failed to back up database before migration: %w", err)
+	}
+	return nil
+}
+
+// startBackupScheduler takes a backup whenever the newest scheduled backup in
+// the bucket is older than interval. Checking the bucket rather than a local
+// timer means short-lived and concurrent instances share one schedule.
+func startBackupScheduler(ctx context.Context, interval time.Duration) {
+	if interval == 0 {
+		log.Println("Scheduled database backups disabled")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := backupIfDue(ctx, interval); err != nil && ctx.Err() == nil {
+				log.Printf("Scheduled database backup failed: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// backupIfDue takes a scheduled backup if none was taken within interval and
+// then applies the retention policy
+func backupIfDue(ctx context.Context, interval time.Duration) error {
+	backups, err := blobStore.List(ctx, backupPrefix)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, backup := range backups {
+		t, reason, ok := parseBackupKey(backup.Key)
+		if ok && reason == backupReasonScheduled && now.Sub(t) < interval {
+			return nil
+		}
+	}
+
+	if _, err := takeBackup(ctx, db, &dbMutex, backupReasonScheduled); err != nil {
+		return err
+	}
+
+	return pruneBackups(ctx, now)
+}
+
+// pruneBackups deletes backups that fall outside the retention policy. The
+// newest backup in each hour is kept for a day, the newest in each day for a
+// month and the newest in each month forever. Backups taken for another reason
+// than the schedule, such as before a migration or a restore, are all kept for
+// a month.
+func pruneBackups(ctx context.Context, now time.Time) error {
+	backups, err := blobStore.List(ctx, backupPrefix)
+	if err != nil {
+		return err
+	}
+
+	// Newest first, so the first backup seen in each period is the one kept
+	kept := make(map[string]bool)
+	for i := len(backups) - 1; i >= 0; i-- {
+		key := backups[i].Key
+		t, reason, ok := parseBackupKey(key)
+		if !ok {
+			continue
+		}
+
+		age := now.Sub(t)
+		var period string
+		switch {
+		case age < backupHourlyRetention:
+			period = t.UTC().Format("2006-01-02T15")
+		case age < backupDailyRetention:
+			period = t.UTC().Format("2006-01-02")
+		default:
+			period = t.UTC().Format("2006-01")
+		}
+
+		if !kept[period] {
+			kept[period] = true
+			continue
+		}
+		if reason != backupReasonScheduled && age < backupDailyRetention {
+			continue
+		}
+
+		if err := blobStore.Delete(ctx, key); err != nil && !errors.Is(err, ErrBlobNotFound) {
+			return err
+		}
+		log.Printf("Deleted database backup %s (retention)", key)
+	}
+
+	return nil
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+var (
+	// ErrBlobNotFound is returned when an object does not exist
+	ErrBlobNotFound = errors.New("blob not found")
+	// ErrBlobPrecondition is returned when a conditional write is rejected
+	// because the object changed since it was last read
+	ErrBlobPrecondition = errors.New("blob precondition failed")
+)
+
+// blobStore holds the database, recipe images and icons
+var blobStore BlobStore
+
+// BlobStore is the object storage used for the database file and uploaded images
+type BlobStore interface {
+	// Put writes the contents of r to key, honouring any preconditions in opts
+	Put(ctx context.Context, key string, r io.Reader, opts PutOptions) (*BlobAttrs, error)
+	// Get opens key for reading; the caller must close the returned reader
+	Get(ctx context.Context, key string) (io.ReadCloser, *BlobAttrs, error)
+	// Delete removes key
+	Delete(ctx context.Context, key string) error
+	// List returns all objects whose key starts with prefix, ordered by key
+	List(ctx context.Context, prefix string) ([]BlobAttrs, error)
+	// Stat returns the attributes of key without reading it
+	Stat(ctx context.Context, key string) (*BlobAttrs, error)
+	// PublicURL returns the URL browsers use to fetch key
+	PublicURL(key string) string
+}
+
+// BlobAttrs describes a stored object
+type BlobAttrs struct {
+	Key         string
+	Size        int64
+	Generation  int64 // Changes every time the object is written
+	ContentType string
+	Updated     time.Time
+}
+
+// PutOptions controls how an object is written
+type PutOptions struct {
+	ContentType string
+	// IfGenerationMatch only writes if the object is currently at this generation
+	IfGenerationMatch int64
+	// IfNotExists only writes if the object does not exist yet
+	IfNotExists bool
+}
+
+// newBlobStoreFromEnv selects the blob store backend from the BLOB_STORE
+// environment variable: "gcs" (default) or "local"
+func newBlobStoreFromEnv(ctx context.Context, port string) (BlobStore, error) {
+	switch backend := os.Getenv("BLOB_STORE"); backend {
+	case "", "gcs":
+		bucket := os.Getenv("DB_BUCKET_NAME")
+		if bucket == "" {
+			return nil, fmt.Errorf("DB_BUCKET_NAME environment variable is required")
+		}
+		return NewGCSBlobStore(ctx, bucket)
+
+	case "local":
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = "./blobs"
+		}
+		baseURL := os.Getenv("BLOB_PUBLIC_URL")
+		if baseURL == "" {
+			baseURL = "http://localhost:" + port + "/blobs"
+		}
+		return NewLocalBlobStore(dir, baseURL)
+
+	default:
+		return nil, fmt.Errorf("unknown BLOB_STORE %q (expected gcs or local)", backend)
+	}
+}
+
+// blobKeyFromURL converts a public URL returned by PublicURL back into its key
+func blobKeyFromURL(url string) (string, error) {
+	prefix := blobStore.PublicURL("")
+	if !strings.HasPrefix(url, prefix) || len(url) == len(prefix) {
+		return "", fmt.Errorf("invalid image URL format")
+	}
+	return strings.TrimPrefix(url, prefix), nil
+}
+
+// GCS implementation
+
+// gcsBlobStore stores objects in a Google Cloud Storage bucket
+type gcsBlobStore struct {
+	client *storage.Client
+	bucket string
+}
+
+// NewGCSBlobStore creates a blob store backed by the given bucket
+func NewGCSBlobStore(ctx context.Context, bucket string) (BlobStore, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %w", err)
+	}
+	return &gcsBlobStore{client: client, bucket: bucket}, nil
+}
+
+func (s *gcsBlobStore) Put(ctx context.Context, key string, r io.Reader, opts PutOptions) (*BlobAttrs, error) {
+	obj := s.client.Bucket(s.bucket).Object(key)
+	if opts.IfNotExists {
+		obj = obj.If(storage.Conditions{DoesNotExist: true})
+	} else if opts.IfGenerationMatch != 0 {
+		obj = obj.If(storage.Conditions{GenerationMatch: opts.IfGenerationMatch})
+	}
+
+	wc := obj.NewWriter(ctx)
+	wc.ContentType = opts.ContentType
+
+	if _, err := io.Copy(wc, r); err != nil {
+		wc.Close()
+		return nil, gcsError(err)
+	}
+
+	// Close writer (uploads the object)
+	if err := wc.Close(); err != nil {
+		return nil, gcsError(err)
+	}
+
+	return gcsAttrs(wc.Attrs()), nil
+}
+
+func (s *gcsBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, *BlobAttrs, error) {
+	rc, err := s.client.Bucket(s.bucket).Object(key).NewReader(ctx)
+	if err != nil {
+		return nil, nil, gcsError(err)
+	}
+
+	attrs := &BlobAttrs{
+		Key:         key,
+		Size:        rc.Attrs.Size,
+		Generation:  rc.Attrs.Generation,
+		ContentType: rc.Attrs.ContentType,
+		Updated:     rc.Attrs.LastModified,
+	}
+	return rc, attrs, nil
+}
+
+func (s *gcsBlobStore) Delete(ctx context.Context, key string) error {
+	return gcsError(s.client.Bucket(s.bucket).Object(key).Delete(ctx))
+}
+
+func (s *gcsBlobStore) List(ctx context.Context, prefix string) ([]BlobAttrs, error) {
+	it := s.client.Bucket(s.bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+
+	var blobs []BlobAttrs
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, gcsError(err)
+		}
+		blobs = append(blobs, *gcsAttrs(attrs))
+	}
+
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Key < blobs[j].Key })
+	return blobs, nil
+}
+
+func (s *gcsBlobStore) Stat(ctx context.Context, key string) (*BlobAttrs, error) {
+	attrs, err := s.client.Bucket(s.bucket).Object(key).Attrs(ctx)
+	if err != nil {
+		return nil, gcsError(err)
+	}
+	return gcsAttrs(attrs), nil
+}
+
+// PublicURL returns https://storage.googleapis.com/{bucket}/{object}
+func (s *gcsBlobStore) PublicURL(key string) string {
+	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", s.bucket, key)
+}
+
+func gcsAttrs(attrs *storage.ObjectAttrs) *BlobAttrs {
+	return &BlobAttrs{
+		Key:         attrs.Name,
+		Size:        attrs.Size,
+		Generation:  attrs.Generation,
+		ContentType: attrs.ContentType,
+		Updated:     attrs.Updated,
+	}
+}
+
+// gcsError maps Cloud Storage errors onto the BlobStore sentinel errors
+func gcsError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("%w: %v", ErrBlobNotFound, err)
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+		return fmt.Errorf("%w: %v", ErrBlobPrecondition, err)
+	}
+	return err
+}
+
+// Local filesystem implementation
+
+// localBlobStore stores objects as files under a directory, for running
+// without Google Cloud. Generations are derived from file modification times.
+type localBlobStore struct {
+	dir     string
+	baseURL string
+	mu      sync.Mutex // Makes conditional writes atomic within this process
+}
+
+// NewLocalBlobStore creates a blob store rooted at dir whose objects are
+// served from baseURL (see ServeHTTP)
+func NewLocalBlobStore(dir, baseURL string) (BlobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	return &localBlobStore{dir: dir, baseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+func (s *localBlobStore) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(clean)), nil
+}
+
+func (s *localBlobStore) Put(ctx context.Context, key string, r io.Reader, opts PutOptions) (*BlobAttrs, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if opts.IfNotExists || opts.IfGenerationMatch != 0 {
+		current, err := s.stat(key, p)
+		switch {
+		case errors.Is(err, ErrBlobNotFound):
+			if opts.IfGenerationMatch != 0 {
+				return nil, fmt.Errorf("%w: %s does not exist", ErrBlobPrecondition, key)
+			}
+		case err != nil:
+			return nil, err
+		case opts.IfNotExists:
+			return nil, fmt.Errorf("%w: %s already exists", ErrBlobPrecondition, key)
+		case current.Generation != opts.IfGenerationMatch:
+			return nil, fmt.Errorf("%w: %s is at generation %d", ErrBlobPrecondition, key, current.Generation)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return nil, err
+	}
+
+	// Write to a temporary file and rename so readers never see a partial object
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return nil, err
+	}
+
+	return s.stat(key, p)
+}
+
+func (s *localBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, *BlobAttrs, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrBlobNotFound, key)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, localAttrs(key, info), nil
+}
+
+func (s *localBlobStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(p); errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrBlobNotFound, key)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *localBlobStore) List(ctx context.Context, prefix string) ([]BlobAttrs, error) {
+	var blobs []BlobAttrs
+	err := filepath.WalkDir(s.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, *localAttrs(key, info))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Key < blobs[j].Key })
+	return blobs, nil
+}
+
+func (s *localBlobStore) Stat(ctx context.Context, key string) (*BlobAttrs, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.stat(key, p)
+}
+
+func (s *localBlobStore) stat(key, p string) (*BlobAttrs, error) {
+	info, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return localAttrs(key, info), nil
+}
+
+func (s *localBlobStore) PublicURL(key string) string {
+	return s.baseURL + "/" + key
+}
+
+// ServeHTTP serves recipe images and icons so PublicURL works without a
+// separate web server. Other objects, such as the database, are not exposed.
+func (s *localBlobStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	if !strings.HasPrefix(key, imagePathPrefix+"/") && !strings.HasPrefix(key, iconPathPrefix+"/") {
+		http.NotFound(w, r)
+		return
+	}
+	http.FileServer(http.Dir(s.dir)).ServeHTTP(w, r)
+}
+
+func localAttrs(key string, info fs.FileInfo) *BlobAttrs {
+	return &BlobAttrs{
+		Key:         key,
+		Size:        info.Size(),
+		Generation:  info.ModTime().UnixNano(),
+		ContentType: mime.TypeByExtension(filepath.Ext(key)),
+		Updated:     info.ModTime(),
+	}
+}
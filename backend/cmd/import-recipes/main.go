@@ -25,6 +25,12 @@ type YAMLRecipe struct {
 	Instructions []string `yaml:"instructions"`
 	Notes       []string `yaml:"notes"`
 	Next        []string `yaml:"next"`
+	// Drinks only
+	Glass       string `yaml:"glass"`
+	Garnish     string `yaml:"garnish"`
+	Preparation string `yaml:"preparation"` // shaken/stirred/built
+	Ice         string `yaml:"ice"`
+	BaseSpirit  string `yaml:"base_spirit"`
 }
 
 type Source struct {
@@ -46,6 +52,11 @@ type Recipe struct {
 	Method          string
 	Notes           string
 	Sources         string
+	Glass           string
+	Garnish         string
+	Preparation     string
+	Ice             string
+	BaseSpirit      string
 	Tags            []string
 	CreatedByName   string
 	CreatedAt       time.Time
@@ -160,6 +171,15 @@ func loadRecipe(filePath string, recipeType string) (Recipe, error) {
 		UpdatedAt:     time.Now(),
 	}
 
+	// Drink fields are stored lowercase so they can be filtered on
+	if recipeType == "drink" {
+		recipe.Glass = strings.ToLower(strings.TrimSpace(yamlRecipe.Glass))
+		recipe.Garnish = strings.TrimSpace(yamlRecipe.Garnish)
+		recipe.Preparation = strings.ToLower(strings.TrimSpace(yamlRecipe.Preparation))
+		recipe.Ice = strings.ToLower(strings.TrimSpace(yamlRecipe.Ice))
+		recipe.BaseSpirit = strings.ToLower(strings.TrimSpace(yamlRecipe.BaseSpirit))
+	}
+
 	return recipe, nil
 }
 
@@ -342,9 +362,14 @@ func outputSQL(ctx context.Context, recipes []Recipe) {
 		notes := escapeSQLString(recipe.Notes)
 		sources := escapeSQLString(recipe.Sources)
 		createdByName := escapeSQLString(recipe.CreatedByName)
+		glass := escapeSQLString(recipe.Glass)
+		garnish := escapeSQLString(recipe.Garnish)
+		preparation := escapeSQLString(recipe.Preparation)
+		ice := escapeSQLString(recipe.Ice)
+		baseSpirit := escapeSQLString(recipe.BaseSpirit)
 
 		sqlFile.WriteString(fmt.Sprintf(
-			"INSERT INTO recipes (id, title, description, recipe_type, cuisine, ingredients, method, notes, sources, created_by_name, created_at, updated_at) VALUES ('%s', '%s', '%s', '%s', '%s', '%s', '%s', '%s', '%s', '%s', '%s', '%s');\n",
+			"INSERT INTO recipes (id, title, description, recipe_type, cuisine, ingredients, method, notes, sources, glass, garnish, preparation, ice, base_spirit, created_by_name, created_at, updated_at) VALUES ('%s', '%s', '%s', '%s', '%s', '%s', '%s', '%s', '%s', '%s', '%s', '%s', '%s', '%s', '%s', '%s', '%s');\n",
 			recipe.ID,
 			title,
 			description,
@@ -354,6 +379,11 @@ func outputSQL(ctx context.Context, recipes []Recipe) {
 			method,
 			notes,
 			sources,
+			glass,
+			garnish,
+			preparation,
+			ice,
+			baseSpirit,
 			createdByName,
 			recipe.CreatedAt.Format("2006-01-02 15:04:05"),
 			recipe.UpdatedAt.Format("2006-01-02 15:04:05"),
@@ -0,0 +1,583 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/storage"
+	_ "github.com/mattn/go-sqlite3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+// These must match the layout written by the server (see backups.go and
+// replication.go)
+const (
+	backupPrefix     = "backups/"
+	backupTimeFormat = "20060102T150405Z"
+	snapshotPrefix   = "replica/snapshots/"
+	segmentPrefix    = "replica/segments/"
+	legacyDBKey      = "recipes.db"
+
+	maxRestoreAttempts = 5
+)
+
+var (
+	errNotFound = errors.New("object not found")
+	errExists   = errors.New("object already exists")
+)
+
+// object describes a stored object
+type object struct {
+	Key     string
+	Size    int64
+	Updated time.Time
+}
+
+// store is the subset of the server's blob storage needed to manage backups
+type store interface {
+	List(ctx context.Context, prefix string) ([]object, error)
+	Download(ctx context.Context, key, dest string) error
+	// Upload fails with errExists if ifNotExists is set and the key is taken
+	Upload(ctx context.Context, key string, r io.Reader, ifNotExists bool) error
+	Delete(ctx context.Context, key string) error
+}
+
+func main() {
+	listCmd := flag.NewFlagSet("list", flag.ExitOnError)
+	backupCmd := flag.NewFlagSet("backup", flag.ExitOnError)
+	restoreCmd := flag.NewFlagSet("restore", flag.ExitOnError)
+
+	storeFlags := func(fs *flag.FlagSet) (*string, *string) {
+		bucket := fs.String("bucket", os.Getenv("DB_BUCKET_NAME"), "Cloud Storage bucket (defaults to $DB_BUCKET_NAME)")
+		dir := fs.String("dir", "", "Local blob directory, for servers run with BLOB_STORE=local")
+		return bucket, dir
+	}
+	listBucket, listDir := storeFlags(listCmd)
+	backupBucket, backupDir := storeFlags(backupCmd)
+	reason := backupCmd.String("reason", "manual", "Reason recorded in the backup name")
+	restoreBucket, restoreDir := storeFlags(restoreCmd)
+	name := restoreCmd.String("backup", "", "Backup to restore, as shown by list")
+
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+
+	switch os.Args[1] {
+	case "list":
+		listCmd.Parse(os.Args[2:])
+		st := openStore(ctx, *listBucket, *listDir)
+		if err := listBackups(ctx, st); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+
+	case "backup":
+		backupCmd.Parse(os.Args[2:])
+		st := openStore(ctx, *backupBucket, *backupDir)
+		if _, err := backupLiveDB(ctx, st, *reason); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+
+	case "restore":
+		restoreCmd.Parse(os.Args[2:])
+		if *name == "" {
+			fmt.Println("Error: --backup is required")
+			restoreCmd.PrintDefaults()
+			os.Exit(1)
+		}
+		st := openStore(ctx, *restoreBucket, *restoreDir)
+		if err := restoreBackup(ctx, st, *name); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+
+	default:
+		printUsage()
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	fmt.Println("Backup Management CLI")
+	fmt.Println("\nUsage:")
+	fmt.Println("  manage-backups list [--bucket <bucket> | --dir <blob dir>]")
+	fmt.Println("  manage-backups backup [--reason <reason>] [--bucket <bucket> | --dir <blob dir>]")
+	fmt.Println("  manage-backups restore --backup <name> [--bucket <bucket> | --dir <blob dir>]")
+	fmt.Println("\nExamples:")
+	fmt.Println("  manage-backups list --bucket my-project-recipebook-db")
+	fmt.Println("  manage-backups backup --reason before-import")
+	fmt.Println("  manage-backups restore --backup 20250124T100000Z-scheduled.db")
+}
+
+func openStore(ctx context.Context, bucket, dir string) store {
+	if dir != "" {
+		return &localStore{dir: dir}
+	}
+	if bucket == "" {
+		log.Fatal("Error: --bucket (or DB_BUCKET_NAME) or --dir is required")
+	}
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		log.Fatalf("Error: failed to create storage client: %v", err)
+	}
+	return &gcsStore{client: client, bucket: bucket}
+}
+
+func listBackups(ctx context.Context, st store) error {
+	backups, err := st.List(ctx, backupPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	if len(backups) == 0 {
+		fmt.Println("No backups found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTAKEN\tREASON\tSIZE")
+	fmt.Fprintln(w, "----\t-----\t------\t----")
+
+	for _, b := range backups {
+		name := path.Base(b.Key)
+		taken, reason := "?", "?"
+		stamp, r, ok := strings.Cut(strings.TrimSuffix(name, ".db"), "-")
+		if t, err := time.Parse(backupTimeFormat, stamp); ok && err == nil {
+			taken, reason = t.Local().Format("2006-01-02 15:04:05"), r
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d KB\n", name, taken, reason, (b.Size+1023)/1024)
+	}
+	w.Flush()
+
+	fmt.Printf("\nTotal backups: %d\n", len(backups))
+	return nil
+}
+
+// backupLiveDB materialises the current database from the replica and
+// uploads it as a backup
+func backupLiveDB(ctx context.Context, st store, reason string) (string, error) {
+	workDir, err := os.MkdirTemp("", "manage-backups")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(workDir)
+
+	dbPath := filepath.Join(workDir, "live.db")
+	position, err := materialise(ctx, st, dbPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to rebuild current database: %w", err)
+	}
+	if err := checkIntegrity(dbPath); err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(dbPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	key := backupPrefix + time.Now().UTC().Format(backupTimeFormat) + "-" + reason + ".db"
+	if err := st.Upload(ctx, key, f, true); err != nil {
+		return "", fmt.Errorf("failed to upload backup: %w", err)
+	}
+
+	fmt.Printf("✓ Backed up database at position %d to %s\n", position, path.Base(key))
+	return key, nil
+}
+
+// restoreBackup makes the named backup the live database. The current
+// database is backed up first. The backup is then published as a snapshot one
+// position past the end of the replica, and that position's segment is
+// claimed so instances with unsynced writes conflict and rebase onto it.
+func restoreBackup(ctx context.Context, st store, name string) error {
+	workDir, err := os.MkdirTemp("", "manage-backups")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	restorePath := filepath.Join(workDir, "restore.db")
+	if err := st.Download(ctx, backupPrefix+name, restorePath); err != nil {
+		return fmt.Errorf("failed to download backup %s: %w", name, err)
+	}
+	if err := checkIntegrity(restorePath); err != nil {
+		return fmt.Errorf("backup %s is damaged: %w", name, err)
+	}
+
+	// Safety backup of what is live right now
+	if _, err := backupLiveDB(ctx, st, "pre-restore"); err != nil {
+		if !errors.Is(err, errNotFound) {
+			return fmt.Errorf("refusing to restore without a safety backup: %w", err)
+		}
+		fmt.Println("No live database found, skipping safety backup")
+	}
+
+	for attempt := 1; attempt <= maxRestoreAttempts; attempt++ {
+		latest, err := latestPosition(ctx, st)
+		if err != nil {
+			return err
+		}
+		position := latest + 1
+
+		snapshotKey := fmt.Sprintf("%s%020d.db", snapshotPrefix, position)
+		if err := uploadFile(ctx, st, snapshotKey, restorePath); errors.Is(err, errExists) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to upload snapshot: %w", err)
+		}
+
+		claim, err := json.Marshal(map[string]interface{}{
+			"position":  position,
+			"createdAt": time.Now().UTC(),
+			"changes":   []interface{}{},
+		})
+		if err != nil {
+			return err
+		}
+		segmentKey := fmt.Sprintf("%s%020d.json", segmentPrefix, position)
+		if err := st.Upload(ctx, segmentKey, bytes.NewReader(claim), true); errors.Is(err, errExists) {
+			// An instance wrote this position first; withdraw and try the next one
+			if err := st.Delete(ctx, snapshotKey); err != nil {
+				return fmt.Errorf("failed to withdraw snapshot %d: %w", position, err)
+			}
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to claim segment %d: %w", position, err)
+		}
+
+		fmt.Printf("✓ Restored %s as database position %d\n", name, position)
+		fmt.Println("  Running instances pick it up within DB_RELOAD_INTERVAL (or on their next write)")
+		return nil
+	}
+
+	return fmt.Errorf("gave up after %d attempts: instances kept writing", maxRestoreAttempts)
+}
+
+// latestPosition returns the newest position in the replica
+func latestPosition(ctx context.Context, st store) (int64, error) {
+	var latest int64
+	for _, prefix := range []string{snapshotPrefix, segmentPrefix} {
+		positions, err := listPositions(ctx, st, prefix)
+		if err != nil {
+			return 0, err
+		}
+		if len(positions) > 0 && positions[len(positions)-1] > latest {
+			latest = positions[len(positions)-1]
+		}
+	}
+	return latest, nil
+}
+
+func listPositions(ctx context.Context, st store, prefix string) ([]int64, error) {
+	objects, err := st.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var positions []int64
+	for _, o := range objects {
+		name := path.Base(o.Key)
+		position, err := strconv.ParseInt(strings.TrimSuffix(name, path.Ext(name)), 10, 64)
+		if err != nil {
+			continue
+		}
+		positions = append(positions, position)
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i] < positions[j] })
+	return positions, nil
+}
+
+// materialise rebuilds the live database at dest from the latest snapshot and
+// the segments after it, the same way the server does on startup
+func materialise(ctx context.Context, st store, dest string) (int64, error) {
+	snapshots, err := listPositions(ctx, st, snapshotPrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	var snapshot int64
+	key := legacyDBKey
+	if len(snapshots) > 0 {
+		snapshot = snapshots[len(snapshots)-1]
+		key = fmt.Sprintf("%s%020d.db", snapshotPrefix, snapshot)
+	}
+	if err := st.Download(ctx, key, dest); err != nil {
+		return 0, err
+	}
+
+	db, err := sql.Open("sqlite3", dest)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	segments, err := listPositions(ctx, st, segmentPrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	position := snapshot
+	for _, segment := range segments {
+		if segment <= position {
+			continue
+		}
+		if segment != position+1 {
+			return 0, fmt.Errorf("replica segment %d is missing", position+1)
+		}
+		if err := applySegment(ctx, st, db, segment); err != nil {
+			return 0, fmt.Errorf("failed to apply segment %d: %w", segment, err)
+		}
+		position = segment
+	}
+
+	return position, nil
+}
+
+// segment mirrors the server's replicaSegment JSON
+type segment struct {
+	Changes []struct {
+		Description string `json:"description"`
+		Statements  []struct {
+			SQL  string        `json:"sql"`
+			Args []interface{} `json:"args"`
+		} `json:"statements"`
+	} `json:"changes"`
+}
+
+func applySegment(ctx context.Context, st store, db *sql.DB, position int64) error {
+	segmentPath := filepath.Join(os.TempDir(), fmt.Sprintf("manage-backups-segment-%d.json", position))
+	defer os.Remove(segmentPath)
+	if err := st.Download(ctx, fmt.Sprintf("%s%020d.json", segmentPrefix, position), segmentPath); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(segmentPath)
+	if err != nil {
+		return err
+	}
+
+	var seg segment
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&seg); err != nil {
+		return fmt.Errorf("failed to decode segment: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, change := range seg.Changes {
+		for _, stmt := range change.Statements {
+			args := make([]interface{}, len(stmt.Args))
+			for i, arg := range stmt.Args {
+				args[i] = arg
+				if n, ok := arg.(json.Number); ok {
+					if v, err := n.Int64(); err == nil {
+						args[i] = v
+					} else if v, err := n.Float64(); err == nil {
+						args[i] = v
+					}
+				}
+			}
+			if _, err := tx.ExecContext(ctx, stmt.SQL, args...); err != nil {
+				return fmt.Errorf("%s: %w", change.Description, err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func checkIntegrity(dbPath string) error {
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("failed to check database integrity: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check failed: %s", result)
+	}
+	return nil
+}
+
+func uploadFile(ctx context.Context, st store, key, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return st.Upload(ctx, key, f, true)
+}
+
+// gcsStore manages backups in a Cloud Storage bucket
+type gcsStore struct {
+	client *storage.Client
+	bucket string
+}
+
+func (s *gcsStore) List(ctx context.Context, prefix string) ([]object, error) {
+	it := s.client.Bucket(s.bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+
+	var objects []object
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, object{Key: attrs.Name, Size: attrs.Size, Updated: attrs.Updated})
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (s *gcsStore) Download(ctx context.Context, key, dest string) error {
+	rc, err := s.client.Bucket(s.bucket).Object(key).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("%w: %s", errNotFound, key)
+	}
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return writeFile(dest, rc)
+}
+
+func (s *gcsStore) Upload(ctx context.Context, key string, r io.Reader, ifNotExists bool) error {
+	obj := s.client.Bucket(s.bucket).Object(key)
+	if ifNotExists {
+		obj = obj.If(storage.Conditions{DoesNotExist: true})
+	}
+
+	wc := obj.NewWriter(ctx)
+	if _, err := io.Copy(wc, r); err != nil {
+		wc.Close()
+		return err
+	}
+	err := wc.Close()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+		return fmt.Errorf("%w: %s", errExists, key)
+	}
+	return err
+}
+
+func (s *gcsStore) Delete(ctx context.Context, key string) error {
+	return s.client.Bucket(s.bucket).Object(key).Delete(ctx)
+}
+
+// localStore manages backups in a local blob directory (BLOB_STORE=local)
+type localStore struct {
+	dir string
+}
+
+func (s *localStore) List(ctx context.Context, prefix string) ([]object, error) {
+	var objects []object
+	err := filepath.WalkDir(filepath.Join(s.dir, filepath.FromSlash(prefix)), func(p string, d os.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return filepath.SkipDir
+		}
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, object{Key: filepath.ToSlash(rel), Size: info.Size(), Updated: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (s *localStore) Download(ctx context.Context, key, dest string) error {
+	f, err := os.Open(filepath.Join(s.dir, filepath.FromSlash(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", errNotFound, key)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writeFile(dest, f)
+}
+
+func (s *localStore) Upload(ctx context.Context, key string, r io.Reader, ifNotExists bool) error {
+	dest := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(filepath.Dir(dest), ".upload-"+filepath.Base(dest))
+	if err := writeFile(tmp, r); err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if !ifNotExists {
+		return os.Rename(tmp, dest)
+	}
+	// Linking fails if dest exists, even if the server creates it concurrently
+	if err := os.Link(tmp, dest); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%w: %s", errExists, key)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *localStore) Delete(ctx context.Context, key string) error {
+	return os.Remove(filepath.Join(s.dir, filepath.FromSlash(key)))
+}
+
+func writeFile(dest string, r io.Reader) error {
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"cloud.google.com/go/storage"
+	"github.com/croach/recipebook2/backend/migrations"
+	_ "github.com/mattn/go-sqlite3"
+	"google.golang.org/api/iterator"
+)
+
+// These must match the layout written by the server (see replication.go)
+const (
+	snapshotPrefix = "replica/snapshots/"
+	legacyDBKey    = "recipes.db"
+)
+
+func main() {
+	statusCmd := flag.NewFlagSet("status", flag.ExitOnError)
+	dryRunCmd := flag.NewFlagSet("dry-run", flag.ExitOnError)
+
+	sourceFlags := func(fs *flag.FlagSet) (*string, *string, *string) {
+		dbPath := fs.String("db", "", "SQLite database file to inspect")
+		dir := fs.String("dir", "", "Local blob directory (BLOB_STORE=local) to take the latest snapshot from")
+		bucket := fs.String("bucket", os.Getenv("DB_BUCKET_NAME"), "Cloud Storage bucket to take the latest snapshot from (defaults to $DB_BUCKET_NAME)")
+		return dbPath, dir, bucket
+	}
+	statusDB, statusDir, statusBucket := sourceFlags(statusCmd)
+	dryRunDB, dryRunDir, dryRunBucket := sourceFlags(dryRunCmd)
+
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+
+	switch os.Args[1] {
+	case "status":
+		statusCmd.Parse(os.Args[2:])
+		if err := withCopy(ctx, *statusDB, *statusDir, *statusBucket, showStatus); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+
+	case "dry-run":
+		dryRunCmd.Parse(os.Args[2:])
+		if err := withCopy(ctx, *dryRunDB, *dryRunDir, *dryRunBucket, dryRun); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+
+	default:
+		printUsage()
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	fmt.Println("Schema Migration CLI")
+	fmt.Println("\nUsage:")
+	fmt.Println("  migrate status  [--db <file> | --dir <blob dir> | --bucket <bucket>]")
+	fmt.Println("  migrate dry-run [--db <file> | --dir <blob dir> | --bucket <bucket>]")
+	fmt.Println("\nBoth commands work on a temporary copy; the source database is never modified.")
+	fmt.Println("\nExamples:")
+	fmt.Println("  migrate status --db /tmp/recipes.db")
+	fmt.Println("  migrate dry-run --bucket my-project-recipebook-db")
+	fmt.Println("  migrate dry-run --dir ./blobs")
+}
+
+// withCopy makes a temporary copy of the chosen database and calls fn with it
+func withCopy(ctx context.Context, dbPath, dir, bucket string, fn func(ctx context.Context, db *sql.DB, source string) error) error {
+	workDir, err := os.MkdirTemp("", "migrate")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	copyPath := filepath.Join(workDir, "recipes.db")
+	var source string
+
+	switch {
+	case dbPath != "":
+		// VACUUM INTO also picks up commits still in the -wal file
+		src, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro")
+		if err != nil {
+			return err
+		}
+		_, err = src.ExecContext(ctx, "VACUUM INTO ?", copyPath)
+		src.Close()
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %w", dbPath, err)
+		}
+		source = dbPath
+
+	case dir != "":
+		key, err := latestSnapshotInDir(dir)
+		if err != nil {
+			return err
+		}
+		if err := copyFile(filepath.Join(dir, filepath.FromSlash(key)), copyPath); err != nil {
+			return err
+		}
+		source = filepath.Join(dir, filepath.FromSlash(key))
+
+	case bucket != "":
+		key, err := downloadLatestSnapshot(ctx, bucket, copyPath)
+		if err != nil {
+			return err
+		}
+		source = fmt.Sprintf("gs://%s/%s", bucket, key)
+
+	default:
+		return errors.New("one of --db, --dir or --bucket (or DB_BUCKET_NAME) is required")
+	}
+
+	db, err := sql.Open("sqlite3", copyPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return fn(ctx, db, source)
+}
+
+func showStatus(ctx context.Context, db *sql.DB, source string) error {
+	statuses, err := migrations.List(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Database: %s\n\n", source)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "MIGRATION\tSTATUS\tAPPLIED AT")
+	fmt.Fprintln(w, "---------\t------\t----------")
+
+	pending := 0
+	for _, s := range statuses {
+		status, appliedAt := "pending", ""
+		if s.Applied {
+			status, appliedAt = "applied", "(before schema_migrations)"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			}
+		} else {
+			pending++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Migration, status, appliedAt)
+	}
+	w.Flush()
+
+	fmt.Printf("\nPending migrations: %d\n", pending)
+	return nil
+}
+
+func dryRun(ctx context.Context, db *sql.DB, source string) error {
+	pending, err := migrations.Pending(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Database: %s\n\n", source)
+	if len(pending) == 0 {
+		fmt.Println("No pending migrations")
+		return nil
+	}
+
+	fmt.Println("Applying to a temporary copy:")
+	applied, err := migrations.Apply(ctx, db, nil)
+	for _, m := range applied {
+		fmt.Printf("  ✓ %s\n", m)
+	}
+	if err != nil {
+		return err
+	}
+
+	var result string
+	if err := db.QueryRowContext(ctx, "PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("failed to check database integrity: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check failed after migrating: %s", result)
+	}
+
+	fmt.Printf("\n✓ All %d pending migrations apply cleanly. The source database was not changed.\n", len(applied))
+	return nil
+}
+
+// latestSnapshotInDir returns the key of the newest snapshot in a local blob
+// directory, or of a database uploaded before replication existed
+func latestSnapshotInDir(dir string) (string, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, filepath.FromSlash(snapshotPrefix)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".db") {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		return snapshotPrefix + names[len(names)-1], nil
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, legacyDBKey)); err != nil {
+		return "", fmt.Errorf("no database snapshot found in %s", dir)
+	}
+	return legacyDBKey, nil
+}
+
+// downloadLatestSnapshot downloads the newest snapshot in bucket to dest and
+// returns its key
+func downloadLatestSnapshot(ctx context.Context, bucket, dest string) (string, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to create storage client: %w", err)
+	}
+	defer client.Close()
+
+	key := legacyDBKey
+	it := client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: snapshotPrefix})
+	var latest string
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if strings.HasSuffix(attrs.Name, ".db") && path.Base(attrs.Name) > path.Base(latest) {
+			latest = attrs.Name
+		}
+	}
+	if latest != "" {
+		key = latest
+	}
+
+	rc, err := client.Bucket(bucket).Object(key).NewReader(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	defer rc.Close()
+
+	return key, writeFile(dest, rc)
+}
+
+func copyFile(src, dest string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writeFile(dest, f)
+}
+
+func writeFile(dest string, r io.Reader) error {
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Collection is a curated, ordered set of recipes such as "Go-to weeknight
+// dinners". Like recipes, collections are shared by the household.
+type Collection struct {
+	ID              string    `json:"id"` // UUID
+	Name            string    `json:"name"`
+	Description     string    `json:"description"`
+	RecipeCount     int       `json:"recipeCount"`
+	Recipes         []Recipe  `json:"recipes,omitempty"` // In collection order; only when fetching one collection
+	CreatedByUserID *string   `json:"createdByUserId"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+var (
+	errCollectionNotFound       = errors.New("collection not found")
+	errCollectionRecipeNotFound = errors.New("recipe is not in the collection")
+	errCollectionOrder          = errors.New("recipeIds must list recipes in the collection, each once")
+)
+
+// collectionColumns selects a collection, counting the recipes in it that
+// aren't in the trash
+const collectionColumns = `
+	SELECT c.id, c.name, c.description, c.created_by_user_id, c.created_at, c.updated_at,
+		(SELECT COUNT(*) FROM collection_recipes cr
+		 JOIN recipes r ON r.id = cr.recipe_id AND r.deleted_at IS NULL
+		 WHERE cr.collection_id = c.id)
+	FROM collections c
+`
+
+func scanCollection(row interface{ Scan(...interface{}) error }) (*Collection, error) {
+	var c Collection
+	if err := row.Scan(&c.ID, &c.Name, &c.Description, &c.CreatedByUserID, &c.CreatedAt, &c.UpdatedAt, &c.RecipeCount); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetCollections returns all collections by name, without their recipes
+func GetCollections(ctx context.Context) ([]Collection, error) {
+	rlockDB(ctx)
+	defer dbMutex.RUnlock()
+
+	rows, err := db.QueryContext(ctx, collectionColumns+` ORDER BY c.name COLLATE NOCASE`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := []Collection{}
+	for rows.Next() {
+		c, err := scanCollection(rows)
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, *c)
+	}
+	return collections, rows.Err()
+}
+
+// GetCollection returns a collection with its recipes in order, or nil if it
+// doesn't exist. Recipes in the trash are left out.
+func GetCollection(ctx context.Context, collectionID string) (*Collection, error) {
+	c, recipeIDs, err := getCollectionRecipeIDs(ctx, collectionID)
+	if c == nil || err != nil {
+		return nil, err
+	}
+
+	c.Recipes = []Recipe{}
+	for _, recipeID := range recipeIDs {
+		recipe, err := GetRecipeByID(ctx, recipeID)
+		if err != nil {
+			return nil, err
+		}
+		// Trashed since the IDs were read
+		if recipe != nil {
+			c.Recipes = append(c.Recipes, *recipe)
+		}
+	}
+	c.RecipeCount = len(c.Recipes)
+	return c, nil
+}
+
+func getCollectionRecipeIDs(ctx context.Context, collectionID string) (*Collection, []string, error) {
+	rlockDB(ctx)
+	defer dbMutex.RUnlock()
+
+	c, err := scanCollection(db.QueryRowContext(ctx, collectionColumns+` WHERE c.id = ?`, collectionID))
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query := `
+		SELECT cr.recipe_id FROM collection_recipes cr
+		JOIN recipes r ON r.id = cr.recipe_id AND r.deleted_at IS NULL
+		WHERE cr.collection_id = ?
+		ORDER BY cr.position
+	`
+	rows, err := db.QueryContext(ctx, query, collectionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var recipeIDs []string
+	for rows.Next() {
+		var recipeID string
+		if err := rows.Scan(&recipeID); err != nil {
+			return nil, nil, err
+		}
+		recipeIDs = append(recipeIDs, recipeID)
+	}
+	return c, recipeIDs, rows.Err()
+}
+
+// CreateCollection saves a new, empty collection. It is updated with its ID.
+func CreateCollection(ctx context.Context, c *Collection) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	c.ID = uuid.New().String()
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = c.CreatedAt
+
+	saved := *c
+	return applyChange(ctx, "create collection "+saved.ID, func(ctx context.Context) error {
+		query := `INSERT INTO collections (id, name, description, created_by_user_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`
+		_, err := execWrite(ctx, query, saved.ID, saved.Name, saved.Description, saved.CreatedByUserID, saved.CreatedAt, saved.UpdatedAt)
+		return err
+	})
+}
+
+// changeCollection runs change on a collection inside applyChange and marks
+// the collection as updated
+func changeCollection(ctx context.Context, description, collectionID string, change func(ctx context.Context) error) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	now := time.Now()
+	return applyChange(ctx, description, func(ctx context.Context) error {
+		// Touch the collection first so nothing is written if it doesn't exist
+		result, err := execWrite(ctx, `UPDATE collections SET updated_at = ? WHERE id = ?`, now, collectionID)
+		if err != nil {
+			return err
+		}
+		if err := requireRowAffected(result, errCollectionNotFound); err != nil {
+			return err
+		}
+		return change(ctx)
+	})
+}
+
+// UpdateCollection renames a collection or changes its description
+func UpdateCollection(ctx context.Context, c *Collection) error {
+	saved := *c
+	return changeCollection(ctx, "update collection "+saved.ID, saved.ID, func(ctx context.Context) error {
+		_, err := execWrite(ctx, `UPDATE collections SET name = ?, description = ? WHERE id = ?`, saved.Name, saved.Description, saved.ID)
+		return err
+	})
+}
+
+// DeleteCollection deletes a collection. Its recipes are not affected.
+func DeleteCollection(ctx context.Context, collectionID string) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	return applyChange(ctx, "delete collection "+collectionID, func(ctx context.Context) error {
+		if _, err := execWrite(ctx, `DELETE FROM collection_recipes WHERE collection_id = ?`, collectionID); err != nil {
+			return err
+		}
+		result, err := execWrite(ctx, `DELETE FROM collections WHERE id = ?`, collectionID)
+		if err != nil {
+			return err
+		}
+		return requireRowAffected(result, errCollectionNotFound)
+	})
+}
+
+// AddRecipeToCollection adds a recipe to the end of a collection. Adding a
+// recipe that is already in it does nothing.
+func AddRecipeToCollection(ctx context.Context, collectionID, recipeID string) error {
+	addedAt := time.Now()
+	return changeCollection(ctx, "add recipe "+recipeID+" to collection "+collectionID, collectionID, func(ctx context.Context) error {
+		query := `
+			INSERT OR IGNORE INTO collection_recipes (collection_id, recipe_id, position, added_at)
+			SELECT ?, ?, COALESCE(MAX(position), -1) + 1, ?
+			FROM collection_recipes WHERE collection_id = ?
+		`
+		_, err := execWrite(ctx, query, collectionID, recipeID, addedAt, collectionID)
+		return err
+	})
+}
+
+// RemoveRecipeFromCollection takes a recipe out of a collection
+func RemoveRecipeFromCollection(ctx context.Context, collectionID, recipeID string) error {
+	return changeCollection(ctx, "remove recipe "+recipeID+" from collection "+collectionID, collectionID, func(ctx context.Context) error {
+		result, err := execWrite(ctx, `DELETE FROM collection_recipes WHERE collection_id = ? AND recipe_id = ?`, collectionID, recipeID)
+		if err != nil {
+			return err
+		}
+		return requireRowAffected(result, errCollectionRecipeNotFound)
+	})
+}
+
+// ReorderCollection puts a collection's recipes in the order of recipeIDs.
+// Recipes that aren't listed, such as ones in the trash, keep their order
+// after the listed ones.
+func ReorderCollection(ctx context.Context, collectionID string, recipeIDs []string) error {
+	return changeCollection(ctx, "reorder collection "+collectionID, collectionID, func(ctx context.Context) error {
+		rows, err := dbConn(ctx).QueryContext(ctx, `SELECT recipe_id FROM collection_recipes WHERE collection_id = ? ORDER BY position`, collectionID)
+		if err != nil {
+			return err
+		}
+		var members []string
+		for rows.Next() {
+			var recipeID string
+			if err := rows.Scan(&recipeID); err != nil {
+				rows.Close()
+				return err
+			}
+			members = append(members, recipeID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		// Check everything before reordering anything
+		listed := make(map[string]bool, len(recipeIDs))
+		for _, recipeID := range recipeIDs {
+			if listed[recipeID] {
+				return errCollectionOrder
+			}
+			listed[recipeID] = true
+		}
+		order := append([]string{}, recipeIDs...)
+		for _, recipeID := range members {
+			if listed[recipeID] {
+				delete(listed, recipeID)
+			} else {
+				order = append(order, recipeID)
+			}
+		}
+		if len(listed) > 0 {
+			return errCollectionOrder
+		}
+
+		for position, recipeID := range order {
+			query := `UPDATE collection_recipes SET position = ? WHERE collection_id = ? AND recipe_id = ?`
+			if _, err := execWrite(ctx, query, position, collectionID, recipeID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// collectionsHandler serves collections. Anyone can read them; changes need
+// a signed-in user.
+//
+//	GET    /collections                              all collections, without recipes
+//	POST   /collections                              create a collection
+//	GET    /collections/{id}                         a collection and its recipes
+//	PUT    /collections/{id}                         rename or redescribe a collection
+//	DELETE /collections/{id}                         delete a collection (not its recipes)
+//	POST   /collections/{id}/recipes                 add a recipe to the end
+//	PUT    /collections/{id}/recipes                 reorder the recipes
+//	DELETE /collections/{id}/recipes/{recipeId}      remove a recipe
+func collectionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/collections"), "/")
+	collectionID, subPath, _ := strings.Cut(path, "/")
+	resource, recipeID, _ := strings.Cut(subPath, "/")
+	if subPath != "" && resource != "recipes" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	// Public read - no auth required
+	if r.Method == http.MethodGet && subPath == "" {
+		if collectionID == "" {
+			collections, err := GetCollections(r.Context())
+			if err != nil {
+				log.Printf("Error getting collections: %v", err)
+				http.Error(w, "Failed to get collections", http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(collections)
+			return
+		}
+		writeCollection(w, r, collectionID, http.StatusOK)
+		return
+	}
+
+	userID, err := authenticateRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case collectionID == "" && r.Method == http.MethodPost:
+		var c Collection
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if c.Name = strings.TrimSpace(c.Name); c.Name == "" {
+			http.Error(w, "Name is required", http.StatusBadRequest)
+			return
+		}
+		c.CreatedByUserID = &userID
+		log.Printf("Creating collection %q - authenticated user: %s", c.Name, userID)
+
+		if err := CreateCollection(r.Context(), &c); err != nil {
+			log.Printf("Error creating collection: %v", err)
+			http.Error(w, "Failed to create collection", http.StatusInternalServerError)
+			return
+		}
+		writeCollection(w, r, c.ID, http.StatusCreated)
+		return
+
+	case collectionID == "":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+
+	case subPath == "" && r.Method == http.MethodPut:
+		var c Collection
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if c.Name = strings.TrimSpace(c.Name); c.Name == "" {
+			http.Error(w, "Name is required", http.StatusBadRequest)
+			return
+		}
+		c.ID = collectionID
+		log.Printf("Updating collection %s - authenticated user: %s", collectionID, userID)
+		err = UpdateCollection(r.Context(), &c)
+
+	case subPath == "" && r.Method == http.MethodDelete:
+		log.Printf("Deleting collection %s - authenticated user: %s", collectionID, userID)
+		err = DeleteCollection(r.Context(), collectionID)
+		if err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+	case recipeID == "" && r.Method == http.MethodPost:
+		var body struct {
+			RecipeID string `json:"recipeId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RecipeID == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		recipe, lookupErr := GetRecipeByID(r.Context(), body.RecipeID)
+		if lookupErr != nil {
+			log.Printf("Error getting recipe: %v", lookupErr)
+			http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+			return
+		}
+		if recipe == nil {
+			http.Error(w, "recipe not found: "+body.RecipeID, http.StatusBadRequest)
+			return
+		}
+		log.Printf("Adding recipe %s to collection %s - authenticated user: %s", body.RecipeID, collectionID, userID)
+		err = AddRecipeToCollection(r.Context(), collectionID, body.RecipeID)
+
+	case recipeID == "" && r.Method == http.MethodPut:
+		var body struct {
+			RecipeIDs []string `json:"recipeIds"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		log.Printf("Reordering collection %s - authenticated user: %s", collectionID, userID)
+		err = ReorderCollection(r.Context(), collectionID, body.RecipeIDs)
+
+	case recipeID != "" && r.Method == http.MethodDelete:
+		log.Printf("Removing recipe %s from collection %s - authenticated user: %s", recipeID, collectionID, userID)
+		err = RemoveRecipeFromCollection(r.Context(), collectionID, recipeID)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case errors.Is(err, errCollectionNotFound):
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errCollectionRecipeNotFound):
+		http.Error(w, "Recipe is not in the collection", http.StatusNotFound)
+		return
+	case errors.Is(err, errCollectionOrder):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("Error updating collection: %v", err)
+		http.Error(w, "Failed to update collection", http.StatusInternalServerError)
+		return
+	}
+	writeCollection(w, r, collectionID, http.StatusOK)
+}
+
+func writeCollection(w http.ResponseWriter, r *http.Request, collectionID string, status int) {
+	c, err := GetCollection(r.Context(), collectionID)
+	if err != nil {
+		log.Printf("Error getting collection %s: %v", collectionID, err)
+		http.Error(w, "Failed to get collection", http.StatusInternalServerError)
+		return
+	}
+	if c == nil {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(c)
+}
@@ -3,15 +3,15 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"strings"
 	"sync"
 	"time"
 
-	"cloud.google.com/go/storage"
+	"github.com/croach/recipebook2/backend/migrations"
 	"github.com/google/uuid"
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -22,9 +22,15 @@ const (
 )
 
 var (
-	db         *sql.DB
-	dbMutex    sync.RWMutex
-	bucketName string
+	db      *sql.DB
+	dbMutex sync.RWMutex
+)
+
+var (
+	// errRecipeNotFound is returned when writing a recipe that doesn't exist
+	// or is in the trash
+	errRecipeNotFound  = errors.New("recipe not found")
+	errMakeLogNotFound = errors.New("make log not found")
 )
 
 // Icon represents a recipe icon
@@ -37,24 +43,43 @@ type Icon struct {
 
 // Recipe represents a recipe with markdown fields
 type Recipe struct {
-	ID              string        `json:"id"` // UUID
-	Title           string        `json:"title"`
-	Description     string        `json:"description"`     // Brief description
-	RecipeType      string        `json:"type"`            // "food", "cocktail", etc.
-	Cuisine         string        `json:"cuisine"`         // "italian", "japanese", "mexican", etc.
-	Ingredients     string        `json:"ingredients"`     // markdown
-	Method          string        `json:"method"`          // markdown
-	Notes           string        `json:"notes"`           // markdown
-	Sources         string        `json:"sources"`         // markdown
-	IconID          *int64        `json:"iconId"`          // Nullable icon ID
-	Icon            *Icon         `json:"icon"`            // Icon details (loaded separately)
-	Tags            []string      `json:"tags"`            // Array of tag names
-	Images          []RecipeImage `json:"images"`          // Array of image URLs
-	MakeCount       int           `json:"makeCount"`       // Number of times this recipe was made
-	CreatedByUserID *string       `json:"createdByUserId"` // Firebase UID of creator (nullable)
-	CreatedByName   *string       `json:"createdByName"`   // Display name of creator (nullable)
-	CreatedAt       time.Time     `json:"createdAt"`
-	UpdatedAt       time.Time     `json:"updatedAt"`
+	ID               string            `json:"id"` // UUID
+	Title            string            `json:"title"`
+	Description      string            `json:"description"`      // Brief description
+	RecipeType       string            `json:"type"`             // "food", "cocktail", etc.
+	Cuisine          string            `json:"cuisine"`          // "italian", "japanese", "mexican", etc.
+	Servings         *int              `json:"servings"`         // Number of servings the ingredients make (nullable)
+	Yield            string            `json:"yield"`            // What the recipe makes, e.g. "1 loaf" or "24 cookies"
+	PrepTimeMinutes  *int              `json:"prepTimeMinutes"`  // Nullable
+	CookTimeMinutes  *int              `json:"cookTimeMinutes"`  // Nullable
+	TotalTimeMinutes *int              `json:"totalTimeMinutes"` // Nullable, defaults to prep + cook
+	Glass            string            `json:"glass"`            // Drinks: glass it is served in, e.g. "coupe"
+	Garnish          string            `json:"garnish"`          // Drinks: e.g. "orange peel"
+	Preparation      string            `json:"preparation"`      // Drinks: "shaken", "stirred", "built", etc.
+	Ice              string            `json:"ice"`              // Drinks: ice it is served over, e.g. "cubed", "none"
+	BaseSpirit       string            `json:"baseSpirit"`       // Drinks: main spirit, e.g. "gin"
+	Ingredients      string            `json:"ingredients"`      // markdown
+	IngredientList   []Ingredient      `json:"ingredientList"`   // Ingredients parsed from the markdown
+	Method           string            `json:"method"`           // markdown
+	Notes            string            `json:"notes"`            // markdown
+	Sources          string            `json:"sources"`          // markdown
+	IconID           *int64            `json:"iconId"`           // Nullable icon ID
+	Icon             *Icon             `json:"icon"`             // Icon details (loaded separately)
+	Tags             []string          `json:"tags"`             // Array of tag names
+	Images           []RecipeImage     `json:"images"`           // Array of image URLs
+	MakeCount        int               `json:"makeCount"`        // Number of times this recipe was made
+	AverageRating    *float64          `json:"averageRating"`    // Average make log rating, 1-5 (nullable)
+	RatingCount      int               `json:"ratingCount"`      // Number of rated make logs
+	UserRatings      []UserRating      `json:"userRatings"`      // Each user's average rating
+	Links            []RecipeLink      `json:"links"`            // Recipes linked from the ingredients and method as [[Title]]
+	UsedIn           []RecipeReference `json:"usedIn"`           // Recipes that link to this one
+	Lineage          []RecipeReference `json:"lineage"`          // Recipes this is a variant of, from the original to its parent
+	Variants         []RecipeReference `json:"variants"`         // Recipes forked from this one
+	IsFavorite       bool              `json:"isFavorite"`       // Whether the signed-in user has starred it
+	CreatedByUserID  *string           `json:"createdByUserId"`  // Firebase UID of creator (nullable)
+	CreatedByName    *string           `json:"createdByName"`    // Display name of creator (nullable)
+	CreatedAt        time.Time         `json:"createdAt"`
+	UpdatedAt        time.Time         `json:"updatedAt"`
 }
 
 // RecipeImage represents an image associated with a recipe
@@ -72,23 +97,60 @@ type MakeLog struct {
 	RecipeID        string    `json:"recipeId"`
 	MadeAt          string    `json:"madeAt"` // Date in YYYY-MM-DD format
 	Notes           string    `json:"notes"`
+	Rating          *int      `json:"rating"` // 1-5 from the user who made it (nullable)
 	CreatedByUserID *string   `json:"createdByUserId"`
 	CreatedAt       time.Time `json:"createdAt"`
 }
 
-// InitDatabase initializes SQLite database and downloads from Cloud Storage if available
-func InitDatabase(ctx context.Context, bucket string) error {
-	bucketName = bucket
+// InitDatabase initializes SQLite database and downloads from blob storage if available
+func InitDatabase(ctx context.Context, store BlobStore) error {
+	blobStore = store
+
+	// Restore the latest replica from blob storage. Only start from an empty
+	// database if the bucket really holds none; any other failure aborts
+	// startup rather than risk publishing changes on top of the wrong state.
+	position, snapshot, err := restoreReplica(ctx, localDBPath)
+	if err != nil && !errors.Is(err, ErrBlobNotFound) {
+		return fmt.Errorf("failed to restore database: %w", err)
+	}
+	if err != nil {
+		// openDatabase creates the file and builds the schema with migrations
+		log.Printf("No existing database found in blob storage, creating new one")
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			if err := os.Remove(localDBPath + suffix); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove stale database: %w", err)
+			}
+		}
+	}
+	dbGeneration.Store(position)
+	snapshotPosition.Store(snapshot)
+
+	if err := openDatabase(ctx); err != nil {
+		return err
+	}
 
-	// Try to download existing DB from Cloud Storage
-	if err := downloadDBFromGCS(ctx); err != nil {
-		log.Printf("No existing database found in Cloud Storage, creating new one: %v", err)
-		if err := createNewDB(); err != nil {
-			return fmt.Errorf("failed to create new database: %w", err)
+	// A new database, or one migrated from a whole-file upload, has no snapshot
+	// yet for segments to build on
+	if position == 0 && snapshot == 0 {
+		if err := takeSnapshot(ctx); err != nil {
+			return fmt.Errorf("failed to upload initial snapshot: %w", err)
 		}
 	}
 
-	// Open SQLite connection
+	if err := backfillRecipeIngredients(ctx); err != nil {
+		return fmt.Errorf("failed to parse recipe ingredients: %w", err)
+	}
+
+	if err := backfillRecipeNutrition(ctx); err != nil {
+		return fmt.Errorf("failed to estimate recipe nutrition: %w", err)
+	}
+
+	log.Println("Database initialized successfully")
+	return nil
+}
+
+// openDatabase opens the SQLite connection to the local file and runs migrations
+func openDatabase(ctx context.Context) error {
 	var err error
 	db, err = sql.Open("sqlite3", localDBPath+"?_journal_mode=WAL")
 	if err != nil {
@@ -100,293 +162,140 @@ func InitDatabase(ctx context.Context, bucket string) error {
 		return fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	// Run migrations for existing databases
-	if err := runMigrations(ctx); err != nil {
+	// Build or update the schema
+	if err := runMigrations(ctx, db); err != nil {
 		return fmt.Errorf("failed to run migrations: %w", err)
 	}
 
-	log.Println("Database initialized successfully")
 	return nil
 }
 
-// createNewDB creates a new SQLite database with schema
-func createNewDB() error {
-	database, err := sql.Open("sqlite3", localDBPath)
+// runMigrations brings conn's schema up to date, backing up the database
+// before changing a schema that already holds data
+func runMigrations(ctx context.Context, conn *sql.DB) error {
+	_, err := migrations.Apply(ctx, conn, func(ctx context.Context, pending []migrations.Migration) error {
+		names := make([]string, len(pending))
+		for i, m := range pending {
+			names[i] = m.String()
+		}
+		return backupBeforeMigration(ctx, conn, strings.Join(names, ", "))
+	})
+	return err
+}
+
+// requireRowAffected returns notFound if a write changed nothing
+func requireRowAffected(result sql.Result, notFound error) error {
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
-	defer database.Close()
-
-	schema := `
-	-- Users table (stores user profiles from Firebase Auth)
-	CREATE TABLE IF NOT EXISTS users (
-		firebase_uid TEXT PRIMARY KEY,
-		email TEXT NOT NULL,
-		display_name TEXT,
-		role TEXT DEFAULT 'viewer' CHECK(role IN ('viewer', 'editor', 'admin')),
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		last_login_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
-
-	-- Icons table (shared collection of recipe icons)
-	CREATE TABLE IF NOT EXISTS icons (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		filename TEXT NOT NULL,
-		icon_url TEXT NOT NULL,
-		uploaded_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS recipes (
-		id TEXT PRIMARY KEY,
-		title TEXT NOT NULL,
-		description TEXT,
-		recipe_type TEXT,
-		cuisine TEXT,
-		ingredients TEXT,
-		method TEXT,
-		notes TEXT,
-		sources TEXT,
-		icon_id INTEGER,
-		created_by_user_id TEXT,
-		created_by_name TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (icon_id) REFERENCES icons(id)
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_recipes_type ON recipes(recipe_type);
-	CREATE INDEX IF NOT EXISTS idx_recipes_cuisine ON recipes(cuisine);
-	CREATE INDEX IF NOT EXISTS idx_recipes_icon ON recipes(icon_id);
-
-	-- Tags table
-	CREATE TABLE IF NOT EXISTS tags (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		name TEXT UNIQUE NOT NULL
-	);
-
-	-- Many-to-many relationship between recipes and tags
-	CREATE TABLE IF NOT EXISTS recipe_tags (
-		recipe_id TEXT NOT NULL,
-		tag_id INTEGER NOT NULL,
-		PRIMARY KEY (recipe_id, tag_id),
-		FOREIGN KEY (recipe_id) REFERENCES recipes(id) ON DELETE CASCADE,
-		FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_recipe_tags_recipe ON recipe_tags(recipe_id);
-	CREATE INDEX IF NOT EXISTS idx_recipe_tags_tag ON recipe_tags(tag_id);
-
-	-- Recipe images table
-	CREATE TABLE IF NOT EXISTS recipe_images (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		recipe_id TEXT NOT NULL,
-		image_url TEXT NOT NULL,
-		display_order INTEGER DEFAULT 0,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (recipe_id) REFERENCES recipes(id) ON DELETE CASCADE
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_recipe_images_recipe ON recipe_images(recipe_id);
-
-	-- Make logs table (tracks when recipes were made)
-	CREATE TABLE IF NOT EXISTS make_logs (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		recipe_id TEXT NOT NULL,
-		made_at DATE NOT NULL,
-		notes TEXT,
-		created_by_user_id TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (recipe_id) REFERENCES recipes(id) ON DELETE CASCADE,
-		FOREIGN KEY (created_by_user_id) REFERENCES users(firebase_uid)
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_make_logs_recipe ON make_logs(recipe_id);
-	CREATE INDEX IF NOT EXISTS idx_make_logs_made_at ON make_logs(made_at);
-
-	-- Full-text search table (now includes description, cuisine, and sources)
-	-- Note: We include recipe_id as an unindexed column to enable joining back to recipes table
-	CREATE VIRTUAL TABLE IF NOT EXISTS recipes_fts USING fts5(
-		recipe_id UNINDEXED,
-		title, description, cuisine, ingredients, method, notes, sources
-	);
-
-	-- Triggers to keep FTS in sync
-	CREATE TRIGGER IF NOT EXISTS recipes_fts_insert AFTER INSERT ON recipes BEGIN
-		INSERT INTO recipes_fts(recipe_id, title, description, cuisine, ingredients, method, notes, sources)
-		VALUES (new.id, new.title, new.description, new.cuisine, new.ingredients, new.method, new.notes, new.sources);
-	END;
-
-	CREATE TRIGGER IF NOT EXISTS recipes_fts_update AFTER UPDATE ON recipes BEGIN
-		UPDATE recipes_fts
-		SET title=new.title, description=new.description, cuisine=new.cuisine,
-			ingredients=new.ingredients, method=new.method, notes=new.notes, sources=new.sources
-		WHERE recipe_id=new.id;
-	END;
-
-	CREATE TRIGGER IF NOT EXISTS recipes_fts_delete AFTER DELETE ON recipes BEGIN
-		DELETE FROM recipes_fts WHERE recipe_id=old.id;
-	END;
-	`
-
-	if _, err := database.Exec(schema); err != nil {
-		return fmt.Errorf("failed to create schema: %w", err)
+	if rowsAffected == 0 {
+		return notFound
 	}
-
-	log.Println("Created new database with schema")
 	return nil
 }
 
-// runMigrations applies database migrations for schema changes
-func runMigrations(ctx context.Context) error {
-	// Migration 1: Add creator fields to recipes table
-	var columnExists int
-	err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('recipes') WHERE name='created_by_user_id'").Scan(&columnExists)
-	if err != nil {
-		return fmt.Errorf("failed to check for column existence: %w", err)
+// inArgs returns the placeholders and arguments for an IN (...) list of ids
+func inArgs(ids []string) (string, []interface{}) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
 	}
+	return strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", "), args
+}
 
-	if columnExists == 0 {
-		log.Println("Running migration: Adding creator fields to recipes table")
-		migrations := []string{
-			"ALTER TABLE recipes ADD COLUMN created_by_user_id TEXT",
-			"ALTER TABLE recipes ADD COLUMN created_by_name TEXT",
-		}
+// loadRecipeDetails fills in what recipes are returned with besides their own
+// columns: icon, tags, structured ingredients, images, make count, ratings,
+// links, lineage and variants. Each is loaded for every recipe in one query,
+// so a list takes the same number of queries however long it is.
+func loadRecipeDetails(ctx context.Context, recipes []Recipe) error {
+	if len(recipes) == 0 {
+		return nil
+	}
 
-		for _, migration := range migrations {
-			if _, err := db.ExecContext(ctx, migration); err != nil {
-				return fmt.Errorf("failed to run migration '%s': %w", migration, err)
-			}
+	ids := make([]string, len(recipes))
+	var iconIDs []int64
+	for i, r := range recipes {
+		ids[i] = r.ID
+		if r.IconID != nil {
+			iconIDs = append(iconIDs, *r.IconID)
 		}
-		log.Println("Migration completed: Creator fields added")
 	}
 
-	// Migration 2: Add users table
-	var tableExists int
-	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='users'").Scan(&tableExists)
+	icons, err := getIconsByID(ctx, iconIDs)
 	if err != nil {
-		return fmt.Errorf("failed to check for users table existence: %w", err)
-	}
-
-	if tableExists == 0 {
-		log.Println("Running migration: Creating users table")
-		userTableSQL := `
-			CREATE TABLE users (
-				firebase_uid TEXT PRIMARY KEY,
-				email TEXT NOT NULL,
-				display_name TEXT,
-				role TEXT DEFAULT 'viewer' CHECK(role IN ('viewer', 'editor', 'admin')),
-				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-				last_login_at DATETIME DEFAULT CURRENT_TIMESTAMP
-			);
-			CREATE INDEX idx_users_email ON users(email);
-		`
-		if _, err := db.ExecContext(ctx, userTableSQL); err != nil {
-			return fmt.Errorf("failed to create users table: %w", err)
-		}
-		log.Println("Migration completed: Users table created")
+		return err
 	}
-
-	// Migration 3: Add make_logs table
-	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='make_logs'").Scan(&tableExists)
+	tags, err := getTagsByRecipe(ctx, ids)
 	if err != nil {
-		return fmt.Errorf("failed to check for make_logs table existence: %w", err)
-	}
-
-	if tableExists == 0 {
-		log.Println("Running migration: Creating make_logs table")
-		makeLogsTableSQL := `
-			CREATE TABLE make_logs (
-				id INTEGER PRIMARY KEY AUTOINCREMENT,
-				recipe_id TEXT NOT NULL,
-				made_at DATE NOT NULL,
-				notes TEXT,
-				created_by_user_id TEXT,
-				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-				FOREIGN KEY (recipe_id) REFERENCES recipes(id) ON DELETE CASCADE,
-				FOREIGN KEY (created_by_user_id) REFERENCES users(firebase_uid)
-			);
-			CREATE INDEX idx_make_logs_recipe ON make_logs(recipe_id);
-			CREATE INDEX idx_make_logs_made_at ON make_logs(made_at);
-		`
-		if _, err := db.ExecContext(ctx, makeLogsTableSQL); err != nil {
-			return fmt.Errorf("failed to create make_logs table: %w", err)
-		}
-		log.Println("Migration completed: make_logs table created")
+		return err
 	}
-
-	return nil
-}
-
-// downloadDBFromGCS downloads the SQLite database from Cloud Storage
-func downloadDBFromGCS(ctx context.Context) error {
-	client, err := storage.NewClient(ctx)
+	ingredients, err := getIngredientsByRecipe(ctx, ids)
 	if err != nil {
 		return err
 	}
-	defer client.Close()
-
-	rc, err := client.Bucket(bucketName).Object(dbFileName).NewReader(ctx)
+	images, err := getImagesByRecipe(ctx, ids)
 	if err != nil {
 		return err
 	}
-	defer rc.Close()
-
-	f, err := os.Create(localDBPath)
+	makeCounts, err := getMakeCountsByRecipe(ctx, ids)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
-	if _, err = io.Copy(f, rc); err != nil {
+	ratings, err := getRatingsByRecipe(ctx, ids)
+	if err != nil {
 		return err
 	}
-
-	log.Println("Downloaded database from Cloud Storage")
-	return nil
-}
-
-// uploadDBToGCS uploads the SQLite database to Cloud Storage
-func uploadDBToGCS(ctx context.Context) error {
-	dbMutex.RLock()
-	defer dbMutex.RUnlock()
-
-	client, err := storage.NewClient(ctx)
+	links, err := getLinksByRecipe(ctx, ids)
 	if err != nil {
 		return err
 	}
-	defer client.Close()
-
-	f, err := os.Open(localDBPath)
+	usedIn, err := getBacklinksByRecipe(ctx, ids)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
-	wc := client.Bucket(bucketName).Object(dbFileName).NewWriter(ctx)
-	wc.ContentType = "application/x-sqlite3"
-
-	if _, err = io.Copy(wc, f); err != nil {
+	lineage, err := getLineageByRecipe(ctx, ids)
+	if err != nil {
 		return err
 	}
-
-	if err := wc.Close(); err != nil {
+	variants, err := getVariantsByRecipe(ctx, ids)
+	if err != nil {
 		return err
 	}
 
-	log.Println("Uploaded database to Cloud Storage")
+	for i := range recipes {
+		r := &recipes[i]
+		if r.IconID != nil {
+			r.Icon = icons[*r.IconID]
+		}
+		r.Tags = tags[r.ID]
+		r.IngredientList = ingredients[r.ID]
+		if r.IngredientList == nil {
+			r.IngredientList = []Ingredient{}
+		}
+		r.Images = images[r.ID]
+		r.MakeCount = makeCounts[r.ID]
+		r.UserRatings, r.AverageRating, r.RatingCount = ratings[r.ID].summary()
+		r.Links = links[r.ID]
+		if r.Links == nil {
+			r.Links = []RecipeLink{}
+		}
+		r.UsedIn = orNoReferences(usedIn[r.ID])
+		r.Lineage = orNoReferences(lineage[r.ID])
+		r.Variants = orNoReferences(variants[r.ID])
+	}
 	return nil
 }
 
 // GetRecipes returns all recipes
 func GetRecipes(ctx context.Context) ([]Recipe, error) {
-	dbMutex.RLock()
+	rlockDB(ctx)
 	defer dbMutex.RUnlock()
 
 	query := `
-		SELECT id, title, description, recipe_type, cuisine, ingredients, method, notes, sources, icon_id, created_by_user_id, created_by_name, created_at, updated_at
+		SELECT id, title, description, recipe_type, cuisine, servings, yield, prep_time_minutes, cook_time_minutes, total_time_minutes, glass, garnish, preparation, ice, base_spirit, ingredients, method, notes, sources, icon_id, created_by_user_id, created_by_name, created_at, updated_at
 		FROM recipes
+		WHERE deleted_at IS NULL
 		ORDER BY updated_at DESC
 	`
 
@@ -399,60 +308,37 @@ func GetRecipes(ctx context.Context) ([]Recipe, error) {
 	var recipes []Recipe
 	for rows.Next() {
 		var r Recipe
-		err := rows.Scan(&r.ID, &r.Title, &r.Description, &r.RecipeType, &r.Cuisine, &r.Ingredients, &r.Method, &r.Notes, &r.Sources, &r.IconID, &r.CreatedByUserID, &r.CreatedByName, &r.CreatedAt, &r.UpdatedAt)
+		err := rows.Scan(&r.ID, &r.Title, &r.Description, &r.RecipeType, &r.Cuisine, &r.Servings, &r.Yield, &r.PrepTimeMinutes, &r.CookTimeMinutes, &r.TotalTimeMinutes, &r.Glass, &r.Garnish, &r.Preparation, &r.Ice, &r.BaseSpirit, &r.Ingredients, &r.Method, &r.Notes, &r.Sources, &r.IconID, &r.CreatedByUserID, &r.CreatedByName, &r.CreatedAt, &r.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
 
-		// Load icon if iconID is set
-		if r.IconID != nil {
-			icon, err := getIconByID(ctx, *r.IconID)
-			if err == nil {
-				r.Icon = icon
-			}
-		}
-
-		// Load tags for this recipe
-		tags, err := getRecipeTags(ctx, r.ID)
-		if err != nil {
-			return nil, err
-		}
-		r.Tags = tags
-
-		// Load images for this recipe
-		images, err := getRecipeImages(ctx, r.ID)
-		if err != nil {
-			return nil, err
-		}
-		r.Images = images
-
-		// Load make count for this recipe
-		makeCount, err := GetMakeCountByRecipe(ctx, r.ID)
-		if err != nil {
-			return nil, err
-		}
-		r.MakeCount = makeCount
-
 		recipes = append(recipes, r)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-	return recipes, rows.Err()
+	if err := loadRecipeDetails(ctx, recipes); err != nil {
+		return nil, err
+	}
+	return recipes, nil
 }
 
 // GetRecipeByID returns a single recipe by ID
 func GetRecipeByID(ctx context.Context, recipeID string) (*Recipe, error) {
-	dbMutex.RLock()
+	rlockDB(ctx)
 	defer dbMutex.RUnlock()
 
 	query := `
-		SELECT id, title, description, recipe_type, cuisine, ingredients, method, notes, sources, icon_id, created_by_user_id, created_by_name, created_at, updated_at
+		SELECT id, title, description, recipe_type, cuisine, servings, yield, prep_time_minutes, cook_time_minutes, total_time_minutes, glass, garnish, preparation, ice, base_spirit, ingredients, method, notes, sources, icon_id, created_by_user_id, created_by_name, created_at, updated_at
 		FROM recipes
-		WHERE id = ?
+		WHERE id = ? AND deleted_at IS NULL
 	`
 
 	var r Recipe
 	err := db.QueryRowContext(ctx, query, recipeID).Scan(
-		&r.ID, &r.Title, &r.Description, &r.RecipeType, &r.Cuisine, &r.Ingredients, &r.Method, &r.Notes, &r.Sources, &r.IconID, &r.CreatedByUserID, &r.CreatedByName, &r.CreatedAt, &r.UpdatedAt,
+		&r.ID, &r.Title, &r.Description, &r.RecipeType, &r.Cuisine, &r.Servings, &r.Yield, &r.PrepTimeMinutes, &r.CookTimeMinutes, &r.TotalTimeMinutes, &r.Glass, &r.Garnish, &r.Preparation, &r.Ice, &r.BaseSpirit, &r.Ingredients, &r.Method, &r.Notes, &r.Sources, &r.IconID, &r.CreatedByUserID, &r.CreatedByName, &r.CreatedAt, &r.UpdatedAt,
 	)
 
 	if err == sql.ErrNoRows {
@@ -462,36 +348,11 @@ func GetRecipeByID(ctx context.Context, recipeID string) (*Recipe, error) {
 		return nil, err
 	}
 
-	// Load icon if iconID is set
-	if r.IconID != nil {
-		icon, err := getIconByID(ctx, *r.IconID)
-		if err == nil {
-			r.Icon = icon
-		}
-	}
-
-	// Load tags for this recipe
-	tags, err := getRecipeTags(ctx, r.ID)
-	if err != nil {
+	recipes := []Recipe{r}
+	if err := loadRecipeDetails(ctx, recipes); err != nil {
 		return nil, err
 	}
-	r.Tags = tags
-
-	// Load images for this recipe
-	images, err := getRecipeImages(ctx, r.ID)
-	if err != nil {
-		return nil, err
-	}
-	r.Images = images
-
-	// Load make count for this recipe
-	makeCount, err := GetMakeCountByRecipe(ctx, r.ID)
-	if err != nil {
-		return nil, err
-	}
-	r.MakeCount = makeCount
-
-	return &r, nil
+	return &recipes[0], nil
 }
 
 // prepareFTS5Query prepares a search query for FTS5 prefix matching
@@ -525,14 +386,14 @@ func prepareFTS5Query(query string) string {
 
 // SearchRecipes performs full-text search across recipes
 func SearchRecipes(ctx context.Context, query string) ([]Recipe, error) {
-	dbMutex.RLock()
+	rlockDB(ctx)
 	defer dbMutex.RUnlock()
 
 	sqlQuery := `
-		SELECT r.id, r.title, r.description, r.recipe_type, r.cuisine, r.ingredients, r.method, r.notes, r.sources, r.icon_id, r.created_by_user_id, r.created_by_name, r.created_at, r.updated_at
+		SELECT r.id, r.title, r.description, r.recipe_type, r.cuisine, r.servings, r.yield, r.prep_time_minutes, r.cook_time_minutes, r.total_time_minutes, r.glass, r.garnish, r.preparation, r.ice, r.base_spirit, r.ingredients, r.method, r.notes, r.sources, r.icon_id, r.created_by_user_id, r.created_by_name, r.created_at, r.updated_at
 		FROM recipes r
 		JOIN recipes_fts ON r.id = recipes_fts.recipe_id
-		WHERE recipes_fts MATCH ?
+		WHERE recipes_fts MATCH ? AND r.deleted_at IS NULL
 		ORDER BY rank
 	`
 
@@ -547,44 +408,21 @@ func SearchRecipes(ctx context.Context, query string) ([]Recipe, error) {
 	var recipes []Recipe
 	for rows.Next() {
 		var r Recipe
-		err := rows.Scan(&r.ID, &r.Title, &r.Description, &r.RecipeType, &r.Cuisine, &r.Ingredients, &r.Method, &r.Notes, &r.Sources, &r.IconID, &r.CreatedByUserID, &r.CreatedByName, &r.CreatedAt, &r.UpdatedAt)
-		if err != nil {
-			return nil, err
-		}
-
-		// Load icon if iconID is set
-		if r.IconID != nil {
-			icon, err := getIconByID(ctx, *r.IconID)
-			if err == nil {
-				r.Icon = icon
-			}
-		}
-
-		// Load tags for this recipe
-		tags, err := getRecipeTags(ctx, r.ID)
-		if err != nil {
-			return nil, err
-		}
-		r.Tags = tags
-
-		// Load images for this recipe
-		images, err := getRecipeImages(ctx, r.ID)
-		if err != nil {
-			return nil, err
-		}
-		r.Images = images
-
-		// Load make count for this recipe
-		makeCount, err := GetMakeCountByRecipe(ctx, r.ID)
+		err := rows.Scan(&r.ID, &r.Title, &r.Description, &r.RecipeType, &r.Cuisine, &r.Servings, &r.Yield, &r.PrepTimeMinutes, &r.CookTimeMinutes, &r.TotalTimeMinutes, &r.Glass, &r.Garnish, &r.Preparation, &r.Ice, &r.BaseSpirit, &r.Ingredients, &r.Method, &r.Notes, &r.Sources, &r.IconID, &r.CreatedByUserID, &r.CreatedByName, &r.CreatedAt, &r.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
-		r.MakeCount = makeCount
 
 		recipes = append(recipes, r)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-	return recipes, rows.Err()
+	if err := loadRecipeDetails(ctx, recipes); err != nil {
+		return nil, err
+	}
+	return recipes, nil
 }
 
 // FilterRecipes performs filtering and sorting based on search text, tags, cuisine, recipe type, and sort order
@@ -592,9 +430,12 @@ func SearchRecipes(ctx context.Context, query string) ([]Recipe, error) {
 // If tags are provided, filters recipes that have ALL specified tags
 // If cuisine is provided, filters by exact cuisine match
 // If recipeType is provided, filters by recipe type (food, drink, etc.)
+// If drink has any fields set, filters drinks by how they are served and made
 // If sortBy is provided, sorts results accordingly
-func FilterRecipes(ctx context.Context, searchQuery string, tags []string, cuisine string, recipeType string, sortBy string) ([]Recipe, error) {
-	dbMutex.RLock()
+// If favoritesOnly is true, only recipes userID has starred are returned
+// Sorting by viewed_desc returns the recipes userID has viewed, most recent first
+func FilterRecipes(ctx context.Context, searchQuery string, tags []string, cuisine string, recipeType string, maxTotalTime int, drink DrinkFilter, sortBy string, userID string, favoritesOnly bool) ([]Recipe, error) {
+	rlockDB(ctx)
 	defer dbMutex.RUnlock()
 
 	var queryBuilder strings.Builder
@@ -604,18 +445,18 @@ func FilterRecipes(ctx context.Context, searchQuery string, tags []string, cuisi
 	if searchQuery != "" {
 		// Use FTS5 for text search with prefix matching
 		queryBuilder.WriteString(`
-			SELECT DISTINCT r.id, r.title, r.description, r.recipe_type, r.cuisine, r.ingredients, r.method, r.notes, r.sources, r.icon_id, r.created_by_user_id, r.created_by_name, r.created_at, r.updated_at
+			SELECT DISTINCT r.id, r.title, r.description, r.recipe_type, r.cuisine, r.servings, r.yield, r.prep_time_minutes, r.cook_time_minutes, r.total_time_minutes, r.glass, r.garnish, r.preparation, r.ice, r.base_spirit, r.ingredients, r.method, r.notes, r.sources, r.icon_id, r.created_by_user_id, r.created_by_name, r.created_at, r.updated_at
 			FROM recipes r
 			JOIN recipes_fts ON r.id = recipes_fts.recipe_id
-			WHERE recipes_fts MATCH ?
+			WHERE recipes_fts MATCH ? AND r.deleted_at IS NULL
 		`)
 		args = append(args, prepareFTS5Query(searchQuery))
 	} else {
 		// No text search, just filter
 		queryBuilder.WriteString(`
-			SELECT DISTINCT r.id, r.title, r.description, r.recipe_type, r.cuisine, r.ingredients, r.method, r.notes, r.sources, r.icon_id, r.created_by_user_id, r.created_by_name, r.created_at, r.updated_at
+			SELECT DISTINCT r.id, r.title, r.description, r.recipe_type, r.cuisine, r.servings, r.yield, r.prep_time_minutes, r.cook_time_minutes, r.total_time_minutes, r.glass, r.garnish, r.preparation, r.ice, r.base_spirit, r.ingredients, r.method, r.notes, r.sources, r.icon_id, r.created_by_user_id, r.created_by_name, r.created_at, r.updated_at
 			FROM recipes r
-			WHERE 1=1
+			WHERE r.deleted_at IS NULL
 		`)
 	}
 
@@ -631,6 +472,42 @@ func FilterRecipes(ctx context.Context, searchQuery string, tags []string, cuisi
 		args = append(args, cuisine)
 	}
 
+	// Add total time filter - recipes without a total time are left out
+	if maxTotalTime > 0 {
+		queryBuilder.WriteString(` AND r.total_time_minutes <= ?`)
+		args = append(args, maxTotalTime)
+	}
+
+	// Add drink filters - stored lowercase, garnish matches part of the text
+	drinkFields := []struct {
+		column, value string
+	}{
+		{"r.glass", drink.Glass},
+		{"r.preparation", drink.Preparation},
+		{"r.ice", drink.Ice},
+		{"r.base_spirit", drink.BaseSpirit},
+	}
+	for _, f := range drinkFields {
+		if f.value != "" {
+			queryBuilder.WriteString(` AND ` + f.column + ` = ?`)
+			args = append(args, strings.ToLower(strings.TrimSpace(f.value)))
+		}
+	}
+	if drink.Garnish != "" {
+		queryBuilder.WriteString(` AND r.garnish LIKE ?`)
+		args = append(args, "%"+strings.TrimSpace(drink.Garnish)+"%")
+	}
+
+	// Add the user's favorites and recently viewed filters
+	if favoritesOnly {
+		queryBuilder.WriteString(` AND r.id IN (SELECT recipe_id FROM recipe_favorites WHERE user_id = ?)`)
+		args = append(args, userID)
+	}
+	if sortBy == "viewed_desc" {
+		queryBuilder.WriteString(` AND r.id IN (SELECT recipe_id FROM recipe_views WHERE user_id = ?)`)
+		args = append(args, userID)
+	}
+
 	// Add tag filters - recipe must have ALL specified tags
 	if len(tags) > 0 {
 		queryBuilder.WriteString(`
@@ -674,6 +551,15 @@ func FilterRecipes(ctx context.Context, searchQuery string, tags []string, cuisi
 			queryBuilder.WriteString(` ORDER BY (SELECT COUNT(*) FROM make_logs WHERE recipe_id = r.id) DESC`)
 		case "made_asc":
 			queryBuilder.WriteString(` ORDER BY (SELECT COUNT(*) FROM make_logs WHERE recipe_id = r.id) ASC`)
+		case "rating_desc":
+			queryBuilder.WriteString(` ORDER BY (SELECT AVG(rating) FROM make_logs WHERE recipe_id = r.id) IS NULL, (SELECT AVG(rating) FROM make_logs WHERE recipe_id = r.id) DESC, (SELECT COUNT(rating) FROM make_logs WHERE recipe_id = r.id) DESC`)
+		case "time_asc":
+			queryBuilder.WriteString(` ORDER BY r.total_time_minutes IS NULL, r.total_time_minutes ASC`)
+		case "time_desc":
+			queryBuilder.WriteString(` ORDER BY r.total_time_minutes IS NULL, r.total_time_minutes DESC`)
+		case "viewed_desc":
+			queryBuilder.WriteString(` ORDER BY (SELECT viewed_at FROM recipe_views WHERE user_id = ? AND recipe_id = r.id) DESC`)
+			args = append(args, userID)
 		case "updated_desc":
 			fallthrough
 		default:
@@ -690,47 +576,24 @@ func FilterRecipes(ctx context.Context, searchQuery string, tags []string, cuisi
 	var recipes []Recipe
 	for rows.Next() {
 		var r Recipe
-		err := rows.Scan(&r.ID, &r.Title, &r.Description, &r.RecipeType, &r.Cuisine, &r.Ingredients, &r.Method, &r.Notes, &r.Sources, &r.IconID, &r.CreatedByUserID, &r.CreatedByName, &r.CreatedAt, &r.UpdatedAt)
+		err := rows.Scan(&r.ID, &r.Title, &r.Description, &r.RecipeType, &r.Cuisine, &r.Servings, &r.Yield, &r.PrepTimeMinutes, &r.CookTimeMinutes, &r.TotalTimeMinutes, &r.Glass, &r.Garnish, &r.Preparation, &r.Ice, &r.BaseSpirit, &r.Ingredients, &r.Method, &r.Notes, &r.Sources, &r.IconID, &r.CreatedByUserID, &r.CreatedByName, &r.CreatedAt, &r.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
 
-		// Load icon if iconID is set
-		if r.IconID != nil {
-			icon, err := getIconByID(ctx, *r.IconID)
-			if err == nil {
-				r.Icon = icon
-			}
-		}
-
-		// Load tags for this recipe
-		recipeTags, err := getRecipeTags(ctx, r.ID)
-		if err != nil {
-			return nil, err
-		}
-		r.Tags = recipeTags
-
-		// Load images for this recipe
-		images, err := getRecipeImages(ctx, r.ID)
-		if err != nil {
-			return nil, err
-		}
-		r.Images = images
-
-		// Load make count for this recipe
-		makeCount, err := GetMakeCountByRecipe(ctx, r.ID)
-		if err != nil {
-			return nil, err
-		}
-		r.MakeCount = makeCount
-
 		recipes = append(recipes, r)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-	return recipes, rows.Err()
+	if err := loadRecipeDetails(ctx, recipes); err != nil {
+		return nil, err
+	}
+	return recipes, nil
 }
 
-// CreateRecipe inserts a new recipe and syncs to Cloud Storage
+// CreateRecipe inserts a new recipe and syncs to blob storage
 func CreateRecipe(ctx context.Context, recipe *Recipe) error {
 	dbMutex.Lock()
 	defer dbMutex.Unlock()
@@ -742,134 +605,201 @@ func CreateRecipe(ctx context.Context, recipe *Recipe) error {
 
 	recipe.CreatedAt = time.Now()
 	recipe.UpdatedAt = time.Now()
+	defaultTotalTime(recipe)
+	normalizeDrinkFields(recipe)
 
+	r := *recipe
+	return applyChange(ctx, "create recipe "+r.ID, func(ctx context.Context) error {
+		return insertRecipe(ctx, r, nil)
+	})
+}
+
+// insertRecipe saves a new recipe with its tags, ingredients and links, and
+// records it as its first revision. parentID is the recipe it was forked from
+// (nil for originals). The caller must be inside applyChange.
+func insertRecipe(ctx context.Context, r Recipe, parentID *string) error {
 	query := `
-		INSERT INTO recipes (id, title, description, recipe_type, cuisine, ingredients, method, notes, sources, icon_id, created_by_user_id, created_by_name, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO recipes (id, title, description, recipe_type, cuisine, servings, yield, prep_time_minutes, cook_time_minutes, total_time_minutes, glass, garnish, preparation, ice, base_spirit, ingredients, method, notes, sources, icon_id, parent_recipe_id, created_by_user_id, created_by_name, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err := db.ExecContext(ctx, query,
-		recipe.ID, recipe.Title, recipe.Description, recipe.RecipeType, recipe.Cuisine,
-		recipe.Ingredients, recipe.Method, recipe.Notes, recipe.Sources, recipe.IconID,
-		recipe.CreatedByUserID, recipe.CreatedByName, recipe.CreatedAt, recipe.UpdatedAt,
+	_, err := execWrite(ctx, query,
+		r.ID, r.Title, r.Description, r.RecipeType, r.Cuisine, r.Servings,
+		r.Yield, r.PrepTimeMinutes, r.CookTimeMinutes, r.TotalTimeMinutes,
+		r.Glass, r.Garnish, r.Preparation, r.Ice, r.BaseSpirit,
+		r.Ingredients, r.Method, r.Notes, r.Sources, r.IconID, parentID,
+		r.CreatedByUserID, r.CreatedByName, r.CreatedAt, r.UpdatedAt,
 	)
 	if err != nil {
 		return err
 	}
 
 	// Handle tags
-	if len(recipe.Tags) > 0 {
-		if err := setRecipeTags(ctx, recipe.ID, recipe.Tags); err != nil {
+	if len(r.Tags) > 0 {
+		if err := setRecipeTags(ctx, r.ID, r.Tags); err != nil {
 			return err
 		}
 	}
 
-	// Upload to Cloud Storage (async to not block response)
-	go func() {
-		if err := uploadDBToGCS(context.Background()); err != nil {
-			log.Printf("Failed to upload database to Cloud Storage: %v", err)
-		}
-	}()
+	if err := setRecipeIngredients(ctx, r.ID, r.Ingredients); err != nil {
+		return err
+	}
 
-	return nil
+	if err := setRecipeLinks(ctx, r); err != nil {
+		return err
+	}
+
+	if err := setRecipeNutrition(ctx, r.ID, parseIngredients(r.Ingredients), r.CreatedAt); err != nil {
+		return err
+	}
+
+	return recordRevision(ctx, r.ID, r.CreatedByUserID, r.CreatedByName, r.CreatedAt, nil)
 }
 
-// UpdateRecipe updates an existing recipe and syncs to Cloud Storage
-func UpdateRecipe(ctx context.Context, recipe *Recipe) error {
+// UpdateRecipe updates an existing recipe, records the saved content as a new
+// revision by userID and syncs to blob storage
+func UpdateRecipe(ctx context.Context, recipe *Recipe, userID string, userName *string) error {
 	dbMutex.Lock()
 	defer dbMutex.Unlock()
 
 	recipe.UpdatedAt = time.Now()
+	defaultTotalTime(recipe)
+	normalizeDrinkFields(recipe)
 
-	query := `
-		UPDATE recipes
-		SET title = ?, description = ?, recipe_type = ?, cuisine = ?,
-		    ingredients = ?, method = ?, notes = ?, sources = ?, icon_id = ?, updated_at = ?
-		WHERE id = ?
-	`
+	return updateRecipe(ctx, *recipe, &userID, userName, nil)
+}
 
-	result, err := db.ExecContext(ctx, query,
-		recipe.Title, recipe.Description, recipe.RecipeType, recipe.Cuisine,
-		recipe.Ingredients, recipe.Method, recipe.Notes, recipe.Sources, recipe.IconID, recipe.UpdatedAt,
-		recipe.ID,
-	)
-	if err != nil {
-		return err
+// defaultTotalTime sets a recipe's total time to its prep time plus cook time
+// if it wasn't given. A total can be longer, e.g. to include resting.
+func defaultTotalTime(recipe *Recipe) {
+	if recipe.TotalTimeMinutes != nil || (recipe.PrepTimeMinutes == nil && recipe.CookTimeMinutes == nil) {
+		return
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
-	if rowsAffected == 0 {
-		return fmt.Errorf("recipe not found")
+	total := 0
+	for _, minutes := range []*int{recipe.PrepTimeMinutes, recipe.CookTimeMinutes} {
+		if minutes != nil {
+			total += *minutes
+		}
 	}
+	recipe.TotalTimeMinutes = &total
+}
 
-	// Update tags (remove old ones and add new ones)
-	if err := setRecipeTags(ctx, recipe.ID, recipe.Tags); err != nil {
-		return err
-	}
+// updateRecipe saves r's content and records it as a new revision. The caller
+// must hold dbMutex.
+func updateRecipe(ctx context.Context, r Recipe, userID, userName *string, restoredFrom *int) error {
+	return applyChange(ctx, "update recipe "+r.ID, func(ctx context.Context) error {
+		// Nutrition is only re-estimated when the ingredients change
+		var oldIngredients string
+		err := dbConn(ctx).QueryRowContext(ctx, `SELECT COALESCE(ingredients, '') FROM recipes WHERE id = ?`, r.ID).Scan(&oldIngredients)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
 
-	// Upload to Cloud Storage (async)
-	go func() {
-		if err := uploadDBToGCS(context.Background()); err != nil {
-			log.Printf("Failed to upload database to Cloud Storage: %v", err)
+		// Keep the content from before revisions existed
+		if err := recordBaselineRevision(ctx, r.ID); err != nil {
+			return err
 		}
-	}()
 
-	return nil
-}
+		query := `
+			UPDATE recipes
+			SET title = ?, description = ?, recipe_type = ?, cuisine = ?, servings = ?,
+			    yield = ?, prep_time_minutes = ?, cook_time_minutes = ?, total_time_minutes = ?,
+			    glass = ?, garnish = ?, preparation = ?, ice = ?, base_spirit = ?,
+			    ingredients = ?, method = ?, notes = ?, sources = ?, icon_id = ?, updated_at = ?
+			WHERE id = ? AND deleted_at IS NULL
+		`
 
-// DeleteRecipe deletes a recipe and syncs to Cloud Storage
-func DeleteRecipe(ctx context.Context, recipeID string) error {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+		result, err := execWrite(ctx, query,
+			r.Title, r.Description, r.RecipeType, r.Cuisine, r.Servings,
+			r.Yield, r.PrepTimeMinutes, r.CookTimeMinutes, r.TotalTimeMinutes,
+			r.Glass, r.Garnish, r.Preparation, r.Ice, r.BaseSpirit,
+			r.Ingredients, r.Method, r.Notes, r.Sources, r.IconID, r.UpdatedAt,
+			r.ID,
+		)
+		if err != nil {
+			return err
+		}
 
-	query := `DELETE FROM recipes WHERE id = ?`
-	result, err := db.ExecContext(ctx, query, recipeID)
-	if err != nil {
-		return err
-	}
+		if err := requireRowAffected(result, errRecipeNotFound); err != nil {
+			return err
+		}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
-	if rowsAffected == 0 {
-		return fmt.Errorf("recipe not found")
-	}
+		// Update tags (remove old ones and add new ones)
+		if err := setRecipeTags(ctx, r.ID, r.Tags); err != nil {
+			return err
+		}
+
+		if err := setRecipeIngredients(ctx, r.ID, r.Ingredients); err != nil {
+			return err
+		}
 
-	// Tags will be automatically deleted via ON DELETE CASCADE
+		if err := setRecipeLinks(ctx, r); err != nil {
+			return err
+		}
 
-	// Upload to Cloud Storage (async)
-	go func() {
-		if err := uploadDBToGCS(context.Background()); err != nil {
-			log.Printf("Failed to upload database to Cloud Storage: %v", err)
+		if r.Ingredients != oldIngredients {
+			if err := setRecipeNutrition(ctx, r.ID, parseIngredients(r.Ingredients), r.UpdatedAt); err != nil {
+				return err
+			}
 		}
-	}()
 
-	return nil
+		return recordRevision(ctx, r.ID, userID, userName, r.UpdatedAt, restoredFrom)
+	})
+}
+
+// DeleteRecipe moves a recipe to the trash and syncs to blob storage. The
+// recipe and everything attached to it are kept until the purge job removes
+// them (see trash.go).
+func DeleteRecipe(ctx context.Context, recipeID string, userID string) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	deletedAt := time.Now().UTC()
+	return applyChange(ctx, "delete recipe "+recipeID, func(ctx context.Context) error {
+		query := `UPDATE recipes SET deleted_at = ?, deleted_by_user_id = ? WHERE id = ? AND deleted_at IS NULL`
+		result, err := execWrite(ctx, query, deletedAt, userID, recipeID)
+		if err != nil {
+			return err
+		}
+		return requireRowAffected(result, errRecipeNotFound)
+	})
 }
 
 // Helper functions for icon management
 
-// getIconByID returns an icon by ID
-func getIconByID(ctx context.Context, iconID int64) (*Icon, error) {
-	query := `SELECT id, filename, icon_url, uploaded_at FROM icons WHERE id = ?`
-	var icon Icon
-	err := db.QueryRowContext(ctx, query, iconID).Scan(&icon.ID, &icon.Filename, &icon.IconURL, &icon.UploadedAt)
-	if err == sql.ErrNoRows {
-		return nil, nil
+// getIconsByID returns icons by ID
+func getIconsByID(ctx context.Context, iconIDs []int64) (map[int64]*Icon, error) {
+	icons := make(map[int64]*Icon)
+	if len(iconIDs) == 0 {
+		return icons, nil
+	}
+
+	args := make([]interface{}, len(iconIDs))
+	for i, id := range iconIDs {
+		args[i] = id
 	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(iconIDs)), ", ")
+	query := `SELECT id, filename, icon_url, uploaded_at FROM icons WHERE id IN (` + placeholders + `)`
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
-	return &icon, nil
+	defer rows.Close()
+
+	for rows.Next() {
+		var icon Icon
+		if err := rows.Scan(&icon.ID, &icon.Filename, &icon.IconURL, &icon.UploadedAt); err != nil {
+			return nil, err
+		}
+		icons[icon.ID] = &icon
+	}
+	return icons, rows.Err()
 }
 
 // GetAllIcons returns all icons
 func GetAllIcons(ctx context.Context) ([]Icon, error) {
-	dbMutex.RLock()
+	rlockDB(ctx)
 	defer dbMutex.RUnlock()
 
 	query := `SELECT id, filename, icon_url, uploaded_at FROM icons ORDER BY uploaded_at DESC`
@@ -893,48 +823,70 @@ func GetAllIcons(ctx context.Context) ([]Icon, error) {
 
 // createIcon creates a new icon record
 func createIcon(ctx context.Context, filename, iconURL string) (int64, error) {
-	query := `INSERT INTO icons (filename, icon_url) VALUES (?, ?)`
-	result, err := db.ExecContext(ctx, query, filename, iconURL)
+	iconID := newRowID()
+	uploadedAt := time.Now()
+	err := applyChange(ctx, "create icon "+filename, func(ctx context.Context) error {
+		query := `INSERT INTO icons (id, filename, icon_url, uploaded_at) VALUES (?, ?, ?, ?)`
+		_, err := execWrite(ctx, query, iconID, filename, iconURL, uploadedAt)
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
-	return result.LastInsertId()
+	return iconID, nil
 }
 
-// GetAllTags returns all unique tags in the database, optionally filtered by recipe type
-func GetAllTags(ctx context.Context, recipeType string) ([]string, error) {
-	dbMutex.RLock()
+// GetAllTags returns the tags with how many recipes use each, by name or by
+// count. Filtered by recipe type, only tags that type uses are returned, and
+// a prefix narrows them for autocomplete.
+func GetAllTags(ctx context.Context, recipeType, prefix, sortBy string) ([]TagUsage, error) {
+	rlockDB(ctx)
 	defer dbMutex.RUnlock()
 
-	var query string
+	var queryBuilder strings.Builder
 	var args []interface{}
 
+	// Count the recipes using each tag, leaving out the trash
+	queryBuilder.WriteString(`
+		SELECT t.id, t.name, COUNT(r.id)
+		FROM tags t
+		LEFT JOIN recipe_tags rt ON rt.tag_id = t.id
+		LEFT JOIN recipes r ON r.id = rt.recipe_id AND r.deleted_at IS NULL`)
 	if recipeType != "" {
-		// Filter tags by recipe type
-		query = `
-			SELECT DISTINCT t.name
-			FROM tags t
-			JOIN recipe_tags rt ON t.id = rt.tag_id
-			JOIN recipes r ON rt.recipe_id = r.id
-			WHERE r.recipe_type = ?
-			ORDER BY t.name
-		`
+		queryBuilder.WriteString(` AND r.recipe_type = ?`)
 		args = append(args, recipeType)
+	}
+
+	// Add prefix filter for autocomplete, treating % and _ literally
+	if prefix != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix)
+		queryBuilder.WriteString(` WHERE t.name LIKE ? ESCAPE '\'`)
+		args = append(args, escaped+"%")
+	}
+
+	queryBuilder.WriteString(` GROUP BY t.id`)
+
+	// Filtered by type, only tags used by that type are included
+	if recipeType != "" {
+		queryBuilder.WriteString(` HAVING COUNT(r.id) > 0`)
+	}
+
+	if sortBy == "count" {
+		queryBuilder.WriteString(` ORDER BY COUNT(r.id) DESC, t.name`)
 	} else {
-		// Get all tags
-		query = `SELECT name FROM tags ORDER BY name`
+		queryBuilder.WriteString(` ORDER BY t.name`)
 	}
 
-	rows, err := db.QueryContext(ctx, query, args...)
+	rows, err := db.QueryContext(ctx, queryBuilder.String(), args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var tags []string
+	tags := []TagUsage{}
 	for rows.Next() {
-		var tag string
-		if err := rows.Scan(&tag); err != nil {
+		var tag TagUsage
+		if err := rows.Scan(&tag.ID, &tag.Name, &tag.Count); err != nil {
 			return nil, err
 		}
 		tags = append(tags, tag)
@@ -945,7 +897,7 @@ func GetAllTags(ctx context.Context, recipeType string) ([]string, error) {
 
 // GetAllCuisines returns all unique cuisines in the database, optionally filtered by recipe type
 func GetAllCuisines(ctx context.Context, recipeType string) ([]string, error) {
-	dbMutex.RLock()
+	rlockDB(ctx)
 	defer dbMutex.RUnlock()
 
 	var query string
@@ -956,13 +908,13 @@ func GetAllCuisines(ctx context.Context, recipeType string) ([]string, error) {
 		query = `
 			SELECT DISTINCT cuisine
 			FROM recipes
-			WHERE cuisine IS NOT NULL AND cuisine != '' AND recipe_type = ?
+			WHERE cuisine IS NOT NULL AND cuisine != '' AND recipe_type = ? AND deleted_at IS NULL
 			ORDER BY cuisine
 		`
 		args = append(args, recipeType)
 	} else {
 		// Get all cuisines
-		query = `SELECT DISTINCT cuisine FROM recipes WHERE cuisine IS NOT NULL AND cuisine != '' ORDER BY cuisine`
+		query = `SELECT DISTINCT cuisine FROM recipes WHERE cuisine IS NOT NULL AND cuisine != '' AND deleted_at IS NULL ORDER BY cuisine`
 	}
 
 	rows, err := db.QueryContext(ctx, query, args...)
@@ -985,29 +937,30 @@ func GetAllCuisines(ctx context.Context, recipeType string) ([]string, error) {
 
 // Helper functions for tag management
 
-// getRecipeTags returns all tags for a given recipe
-func getRecipeTags(ctx context.Context, recipeID string) ([]string, error) {
+// getTagsByRecipe returns the tags of each recipe, by recipe ID
+func getTagsByRecipe(ctx context.Context, recipeIDs []string) (map[string][]string, error) {
+	placeholders, args := inArgs(recipeIDs)
 	query := `
-		SELECT t.name
+		SELECT rt.recipe_id, t.name
 		FROM tags t
 		JOIN recipe_tags rt ON t.id = rt.tag_id
-		WHERE rt.recipe_id = ?
+		WHERE rt.recipe_id IN (` + placeholders + `)
 		ORDER BY t.name
 	`
 
-	rows, err := db.QueryContext(ctx, query, recipeID)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var tags []string
+	tags := make(map[string][]string)
 	for rows.Next() {
-		var tag string
-		if err := rows.Scan(&tag); err != nil {
+		var recipeID, tag string
+		if err := rows.Scan(&recipeID, &tag); err != nil {
 			return nil, err
 		}
-		tags = append(tags, tag)
+		tags[recipeID] = append(tags[recipeID], tag)
 	}
 
 	return tags, rows.Err()
@@ -1017,7 +970,7 @@ func getRecipeTags(ctx context.Context, recipeID string) ([]string, error) {
 func setRecipeTags(ctx context.Context, recipeID string, tagNames []string) error {
 	// Remove existing tag associations
 	deleteQuery := `DELETE FROM recipe_tags WHERE recipe_id = ?`
-	if _, err := db.ExecContext(ctx, deleteQuery, recipeID); err != nil {
+	if _, err := execWrite(ctx, deleteQuery, recipeID); err != nil {
 		return err
 	}
 
@@ -1036,7 +989,7 @@ func setRecipeTags(ctx context.Context, recipeID string, tagNames []string) erro
 
 		// Link tag to recipe
 		insertQuery := `INSERT OR IGNORE INTO recipe_tags (recipe_id, tag_id) VALUES (?, ?)`
-		if _, err := db.ExecContext(ctx, insertQuery, recipeID, tagID); err != nil {
+		if _, err := execWrite(ctx, insertQuery, recipeID, tagID); err != nil {
 			return err
 		}
 	}
@@ -1049,16 +1002,16 @@ func getOrCreateTag(ctx context.Context, tagName string) (int64, error) {
 	// Try to get existing tag
 	var tagID int64
 	query := `SELECT id FROM tags WHERE name = ?`
-	err := db.QueryRowContext(ctx, query, tagName).Scan(&tagID)
+	err := dbConn(ctx).QueryRowContext(ctx, query, tagName).Scan(&tagID)
 
 	if err == sql.ErrNoRows {
-		// Tag doesn't exist, create it
-		insertQuery := `INSERT INTO tags (name) VALUES (?)`
-		result, err := db.ExecContext(ctx, insertQuery, tagName)
-		if err != nil {
+		// Tag doesn't exist, create it with an ID the replica will share
+		tagID = newRowID()
+		insertQuery := `INSERT INTO tags (id, name) VALUES (?, ?)`
+		if _, err := execWrite(ctx, insertQuery, tagID, tagName); err != nil {
 			return 0, err
 		}
-		return result.LastInsertId()
+		return tagID, nil
 	}
 
 	if err != nil {
@@ -1070,28 +1023,29 @@ func getOrCreateTag(ctx context.Context, tagName string) (int64, error) {
 
 // Helper functions for image management
 
-// getRecipeImages returns all images for a given recipe
-func getRecipeImages(ctx context.Context, recipeID string) ([]RecipeImage, error) {
+// getImagesByRecipe returns the images of each recipe, by recipe ID
+func getImagesByRecipe(ctx context.Context, recipeIDs []string) (map[string][]RecipeImage, error) {
+	placeholders, args := inArgs(recipeIDs)
 	query := `
 		SELECT id, recipe_id, image_url, display_order, created_at
 		FROM recipe_images
-		WHERE recipe_id = ?
+		WHERE recipe_id IN (` + placeholders + `)
 		ORDER BY display_order, created_at
 	`
 
-	rows, err := db.QueryContext(ctx, query, recipeID)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var images []RecipeImage
+	images := make(map[string][]RecipeImage)
 	for rows.Next() {
 		var img RecipeImage
 		if err := rows.Scan(&img.ID, &img.RecipeID, &img.ImageURL, &img.DisplayOrder, &img.CreatedAt); err != nil {
 			return nil, err
 		}
-		images = append(images, img)
+		images[img.RecipeID] = append(images[img.RecipeID], img)
 	}
 
 	return images, rows.Err()
@@ -1099,16 +1053,22 @@ func getRecipeImages(ctx context.Context, recipeID string) ([]RecipeImage, error
 
 // addRecipeImage adds an image to a recipe
 func addRecipeImage(ctx context.Context, recipeID, imageURL string, displayOrder int) error {
-	query := `INSERT INTO recipe_images (recipe_id, image_url, display_order) VALUES (?, ?, ?)`
-	_, err := db.ExecContext(ctx, query, recipeID, imageURL, displayOrder)
-	return err
+	imageID := newRowID()
+	createdAt := time.Now()
+	return applyChange(ctx, "add image to recipe "+recipeID, func(ctx context.Context) error {
+		query := `INSERT INTO recipe_images (id, recipe_id, image_url, display_order, created_at) VALUES (?, ?, ?, ?, ?)`
+		_, err := execWrite(ctx, query, imageID, recipeID, imageURL, displayOrder, createdAt)
+		return err
+	})
 }
 
 // deleteRecipeImage deletes an image by ID
 func deleteRecipeImage(ctx context.Context, imageID int64) error {
-	query := `DELETE FROM recipe_images WHERE id = ?`
-	_, err := db.ExecContext(ctx, query, imageID)
-	return err
+	return applyChange(ctx, fmt.Sprintf("delete image %d", imageID), func(ctx context.Context) error {
+		query := `DELETE FROM recipe_images WHERE id = ?`
+		_, err := execWrite(ctx, query, imageID)
+		return err
+	})
 }
 
 // User management functions
@@ -1125,7 +1085,7 @@ type DBUser struct {
 
 // GetUserByUID retrieves a user from SQLite by Firebase UID
 func GetUserByUID(ctx context.Context, firebaseUID string) (*DBUser, error) {
-	dbMutex.RLock()
+	rlockDB(ctx)
 	defer dbMutex.RUnlock()
 
 	query := `SELECT firebase_uid, email, display_name, role, created_at, last_login_at FROM users WHERE firebase_uid = ?`
@@ -1153,26 +1113,38 @@ func GetUserByUID(ctx context.Context, firebaseUID string) (*DBUser, error) {
 	return &user, nil
 }
 
+// GetUserByEmail retrieves a user from SQLite by email address (ignoring case)
+func GetUserByEmail(ctx context.Context, email string) (*DBUser, error) {
+	rlockDB(ctx)
+	var firebaseUID string
+	err := db.QueryRowContext(ctx, `SELECT firebase_uid FROM users WHERE email = ? COLLATE NOCASE`, strings.TrimSpace(email)).Scan(&firebaseUID)
+	dbMutex.RUnlock()
+
+	if err == sql.ErrNoRows {
+		return nil, nil // User not found
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return GetUserByUID(ctx, firebaseUID)
+}
+
 // CreateUser creates a new user in SQLite
 func CreateUser(ctx context.Context, firebaseUID, email, role string) (*DBUser, error) {
 	dbMutex.Lock()
 	defer dbMutex.Unlock()
 
 	now := time.Now()
-	query := `INSERT INTO users (firebase_uid, email, role, created_at, last_login_at) VALUES (?, ?, ?, ?, ?)`
-
-	_, err := db.ExecContext(ctx, query, firebaseUID, email, role, now, now)
+	err := applyChange(ctx, "create user "+firebaseUID, func(ctx context.Context) error {
+		query := `INSERT INTO users (firebase_uid, email, role, created_at, last_login_at) VALUES (?, ?, ?, ?, ?)`
+		_, err := execWrite(ctx, query, firebaseUID, email, role, now, now)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// Upload to Cloud Storage (async)
-	go func() {
-		if err := uploadDBToGCS(context.Background()); err != nil {
-			log.Printf("Failed to upload database to Cloud Storage: %v", err)
-		}
-	}()
-
 	return &DBUser{
 		FirebaseUID: firebaseUID,
 		Email:       email,
@@ -1188,20 +1160,12 @@ func UpdateUserDisplayName(ctx context.Context, firebaseUID, displayName string)
 	dbMutex.Lock()
 	defer dbMutex.Unlock()
 
-	query := `UPDATE users SET display_name = ? WHERE firebase_uid = ?`
-	_, err := db.ExecContext(ctx, query, displayName, firebaseUID)
-	if err != nil {
+	err := applyChange(ctx, "update display name of "+firebaseUID, func(ctx context.Context) error {
+		query := `UPDATE users SET display_name = ? WHERE firebase_uid = ?`
+		_, err := execWrite(ctx, query, displayName, firebaseUID)
 		return err
-	}
-
-	// Upload to Cloud Storage (async)
-	go func() {
-		if err := uploadDBToGCS(context.Background()); err != nil {
-			log.Printf("Failed to upload database to Cloud Storage: %v", err)
-		}
-	}()
-
-	return nil
+	})
+	return err
 }
 
 // UpdateUserLastLogin updates a user's last login timestamp
@@ -1209,20 +1173,13 @@ func UpdateUserLastLogin(ctx context.Context, firebaseUID string) error {
 	dbMutex.Lock()
 	defer dbMutex.Unlock()
 
-	query := `UPDATE users SET last_login_at = ? WHERE firebase_uid = ?`
-	_, err := db.ExecContext(ctx, query, time.Now(), firebaseUID)
-	if err != nil {
+	now := time.Now()
+	err := applyChange(ctx, "update last login of "+firebaseUID, func(ctx context.Context) error {
+		query := `UPDATE users SET last_login_at = ? WHERE firebase_uid = ?`
+		_, err := execWrite(ctx, query, now, firebaseUID)
 		return err
-	}
-
-	// Upload to Cloud Storage (async)
-	go func() {
-		if err := uploadDBToGCS(context.Background()); err != nil {
-			log.Printf("Failed to upload database to Cloud Storage: %v", err)
-		}
-	}()
-
-	return nil
+	})
+	return err
 }
 
 // UpdateUserRole updates a user's role (admin only)
@@ -1230,31 +1187,23 @@ func UpdateUserRole(ctx context.Context, firebaseUID, role string) error {
 	dbMutex.Lock()
 	defer dbMutex.Unlock()
 
-	query := `UPDATE users SET role = ? WHERE firebase_uid = ?`
-	_, err := db.ExecContext(ctx, query, role, firebaseUID)
-	if err != nil {
+	err := applyChange(ctx, "update role of "+firebaseUID, func(ctx context.Context) error {
+		query := `UPDATE users SET role = ? WHERE firebase_uid = ?`
+		_, err := execWrite(ctx, query, role, firebaseUID)
 		return err
-	}
-
-	// Upload to Cloud Storage (async)
-	go func() {
-		if err := uploadDBToGCS(context.Background()); err != nil {
-			log.Printf("Failed to upload database to Cloud Storage: %v", err)
-		}
-	}()
-
-	return nil
+	})
+	return err
 }
 
 // Make log management functions
 
 // GetMakeLogsByRecipe returns all make logs for a given recipe
 func GetMakeLogsByRecipe(ctx context.Context, recipeID string) ([]MakeLog, error) {
-	dbMutex.RLock()
+	rlockDB(ctx)
 	defer dbMutex.RUnlock()
 
 	query := `
-		SELECT id, recipe_id, made_at, notes, created_by_user_id, created_at
+		SELECT id, recipe_id, made_at, notes, rating, created_by_user_id, created_at
 		FROM make_logs
 		WHERE recipe_id = ?
 		ORDER BY made_at DESC, created_at DESC
@@ -1272,7 +1221,7 @@ func GetMakeLogsByRecipe(ctx context.Context, recipeID string) ([]MakeLog, error
 		var notes sql.NullString
 		var createdByUserID sql.NullString
 
-		if err := rows.Scan(&log.ID, &log.RecipeID, &log.MadeAt, &notes, &createdByUserID, &log.CreatedAt); err != nil {
+		if err := rows.Scan(&log.ID, &log.RecipeID, &log.MadeAt, &notes, &log.Rating, &createdByUserID, &log.CreatedAt); err != nil {
 			return nil, err
 		}
 
@@ -1290,15 +1239,52 @@ func GetMakeLogsByRecipe(ctx context.Context, recipeID string) ([]MakeLog, error
 	return logs, rows.Err()
 }
 
-// GetMakeCountByRecipe returns the count of make logs for a given recipe
-func GetMakeCountByRecipe(ctx context.Context, recipeID string) (int, error) {
-	query := `SELECT COUNT(*) FROM make_logs WHERE recipe_id = ?`
-	var count int
-	err := db.QueryRowContext(ctx, query, recipeID).Scan(&count)
+// GetMakeLogByID returns a make log, or nil if it doesn't exist
+func GetMakeLogByID(ctx context.Context, logID int64) (*MakeLog, error) {
+	rlockDB(ctx)
+	defer dbMutex.RUnlock()
+
+	query := `
+		SELECT id, recipe_id, made_at, notes, rating, created_by_user_id, created_at
+		FROM make_logs
+		WHERE id = ?
+	`
+
+	var log MakeLog
+	var notes sql.NullString
+	err := db.QueryRowContext(ctx, query, logID).Scan(&log.ID, &log.RecipeID, &log.MadeAt, &notes, &log.Rating, &log.CreatedByUserID, &log.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return count, nil
+	log.Notes = notes.String
+
+	return &log, nil
+}
+
+// getMakeCountsByRecipe returns how many make logs each recipe has, by
+// recipe ID
+func getMakeCountsByRecipe(ctx context.Context, recipeIDs []string) (map[string]int, error) {
+	placeholders, args := inArgs(recipeIDs)
+	query := `SELECT recipe_id, COUNT(*) FROM make_logs WHERE recipe_id IN (` + placeholders + `) GROUP BY recipe_id`
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var recipeID string
+		var count int
+		if err := rows.Scan(&recipeID, &count); err != nil {
+			return nil, err
+		}
+		counts[recipeID] = count
+	}
+	return counts, rows.Err()
 }
 
 // CreateMakeLog creates a new make log entry
@@ -1306,64 +1292,52 @@ func CreateMakeLog(ctx context.Context, makeLog *MakeLog) error {
 	dbMutex.Lock()
 	defer dbMutex.Unlock()
 
-	query := `
-		INSERT INTO make_logs (recipe_id, made_at, notes, created_by_user_id)
-		VALUES (?, ?, ?, ?)
-	`
-
-	result, err := db.ExecContext(ctx, query, makeLog.RecipeID, makeLog.MadeAt, makeLog.Notes, makeLog.CreatedByUserID)
+	ml := *makeLog
+	ml.ID = newRowID()
+	ml.CreatedAt = time.Now()
+	err := applyChange(ctx, "create make log for recipe "+ml.RecipeID, func(ctx context.Context) error {
+		return insertMakeLog(ctx, ml)
+	})
 	if err != nil {
 		return err
 	}
-
-	id, err := result.LastInsertId()
-	if err != nil {
-		return err
-	}
-	makeLog.ID = id
-
-	// Upload to Cloud Storage (async)
-	go func() {
-		if err := uploadDBToGCS(context.Background()); err != nil {
-			log.Printf("Failed to upload database to Cloud Storage: %v", err)
-		}
-	}()
+	makeLog.ID = ml.ID
+	makeLog.CreatedAt = ml.CreatedAt
 
 	return nil
 }
 
+// insertMakeLog inserts a make log with its ID and creation time already set.
+// The caller must be inside applyChange.
+func insertMakeLog(ctx context.Context, ml MakeLog) error {
+	query := `
+		INSERT INTO make_logs (id, recipe_id, made_at, notes, rating, created_by_user_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := execWrite(ctx, query, ml.ID, ml.RecipeID, ml.MadeAt, ml.Notes, ml.Rating, ml.CreatedByUserID, ml.CreatedAt)
+	return err
+}
+
 // UpdateMakeLog updates a make log entry
 func UpdateMakeLog(ctx context.Context, makeLog *MakeLog) error {
 	dbMutex.Lock()
 	defer dbMutex.Unlock()
 
-	query := `
-		UPDATE make_logs
-		SET made_at = ?, notes = ?
-		WHERE id = ?
-	`
-
-	result, err := db.ExecContext(ctx, query, makeLog.MadeAt, makeLog.Notes, makeLog.ID)
-	if err != nil {
-		return err
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
-	if rowsAffected == 0 {
-		return fmt.Errorf("make log not found")
-	}
+	ml := *makeLog
+	err := applyChange(ctx, fmt.Sprintf("update make log %d", ml.ID), func(ctx context.Context) error {
+		query := `
+			UPDATE make_logs
+			SET made_at = ?, notes = ?, rating = ?
+			WHERE id = ?
+		`
 
-	// Upload to Cloud Storage (async)
-	go func() {
-		if err := uploadDBToGCS(context.Background()); err != nil {
-			log.Printf("Failed to upload database to Cloud Storage: %v", err)
+		result, err := execWrite(ctx, query, ml.MadeAt, ml.Notes, ml.Rating, ml.ID)
+		if err != nil {
+			return err
 		}
-	}()
-
-	return nil
+		return requireRowAffected(result, errMakeLogNotFound)
+	})
+	return err
 }
 
 // DeleteMakeLog deletes a make log entry
@@ -1371,26 +1345,19 @@ func DeleteMakeLog(ctx context.Context, logID int64) error {
 	dbMutex.Lock()
 	defer dbMutex.Unlock()
 
-	query := `DELETE FROM make_logs WHERE id = ?`
-	result, err := db.ExecContext(ctx, query, logID)
-	if err != nil {
-		return err
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
-	if rowsAffected == 0 {
-		return fmt.Errorf("make log not found")
-	}
-
-	// Upload to Cloud Storage (async)
-	go func() {
-		if err := uploadDBToGCS(context.Background()); err != nil {
-			log.Printf("Failed to upload database to Cloud Storage: %v", err)
+	err := applyChange(ctx, fmt.Sprintf("delete make log %d", logID), func(ctx context.Context) error {
+		query := `DELETE FROM make_logs WHERE id = ?`
+		result, err := execWrite(ctx, query, logID)
+		if err != nil {
+			return err
+		}
+		if err := requireRowAffected(result, errMakeLogNotFound); err != nil {
+			return err
 		}
-	}()
 
-	return nil
+		// A planned meal made with this log is no longer cooked
+		_, err = execWrite(ctx, `UPDATE meal_plans SET make_log_id = NULL WHERE make_log_id = ?`, logID)
+		return err
+	})
+	return err
 }
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errNoPours is returned for a drink spec without any measured pours
+var errNoPours = errors.New("drink has no measured pours")
+
+// defaultPartMillilitres is how much "1 part" pours in a spec, unless asked
+// for with ?part=
+const defaultPartMillilitres = 30.0
+
+// drinkMillilitres are the millilitres in one of each unit a drink is poured in.
+// Ounces in a drink are fluid ounces, not weight. Parts are sized per request.
+var drinkMillilitres = map[string]float64{
+	"ml": 1, "l": 1000, "tsp": spoonMillilitres["tsp"], "tbsp": spoonMillilitres["tbsp"],
+	"cup": cupMillilitres, "oz": fluidOunceMillilitres, "fl oz": fluidOunceMillilitres,
+}
+
+// DrinkFilter narrows recipes to drinks served or made a certain way. Empty
+// fields don't filter.
+type DrinkFilter struct {
+	Glass       string
+	Garnish     string // Matches garnishes containing it, e.g. "orange"
+	Preparation string
+	Ice         string
+	BaseSpirit  string
+}
+
+// DrinkSpec is a drink's recipe as a bartender writes it: the pour of each
+// ingredient in parts, millilitres and ounces
+type DrinkSpec struct {
+	RecipeID    string          `json:"recipeId"`
+	Title       string          `json:"title"`
+	Glass       string          `json:"glass"`
+	Garnish     string          `json:"garnish"`
+	Preparation string          `json:"preparation"`
+	Ice         string          `json:"ice"`
+	BaseSpirit  string          `json:"baseSpirit"`
+	Ratio       string          `json:"ratio"` // Parts of each pour in recipe order, e.g. "2:1:1"
+	Pours       []DrinkSpecPour `json:"pours"`
+	Other       []string        `json:"other"` // Lines without a volume, e.g. dashes, garnishes and ice
+	TotalMl     float64         `json:"totalMl"`
+	TotalOz     float64         `json:"totalOz"`
+	Scale       float64         `json:"scale"`
+	PartMl      float64         `json:"partMl"` // Millilitres poured for "1 part"
+}
+
+// DrinkSpecPour is one measured ingredient of a drink
+type DrinkSpecPour struct {
+	Name  string  `json:"name"`
+	Text  string  `json:"text"`  // The line as written
+	Parts float64 `json:"parts"` // Relative to the smallest pour, to the nearest half
+	Ml    float64 `json:"ml"`    // To the nearest half millilitre
+	Oz    float64 `json:"oz"`    // To the nearest quarter ounce
+}
+
+// normalizeDrinkFields tidies a recipe's drink fields so they can be
+// filtered on, and clears them for recipes that aren't drinks
+func normalizeDrinkFields(recipe *Recipe) {
+	if recipe.RecipeType != "drink" {
+		recipe.Glass, recipe.Garnish, recipe.Preparation, recipe.Ice, recipe.BaseSpirit = "", "", "", "", ""
+		return
+	}
+
+	recipe.Glass = strings.ToLower(strings.TrimSpace(recipe.Glass))
+	recipe.Garnish = strings.TrimSpace(recipe.Garnish)
+	recipe.Preparation = strings.ToLower(strings.TrimSpace(recipe.Preparation))
+	recipe.Ice = strings.ToLower(strings.TrimSpace(recipe.Ice))
+	recipe.BaseSpirit = strings.ToLower(strings.TrimSpace(recipe.BaseSpirit))
+}
+
+// drinkSpec works out the spec of a drink, pouring "1 part" as partMl. Lines
+// that pour nothing, such as "0 ml soda", go in Other. If no pours are left it
+// returns errNoPours.
+func drinkSpec(recipe *Recipe, partMl float64) (DrinkSpec, error) {
+	spec := DrinkSpec{
+		RecipeID:    recipe.ID,
+		Title:       recipe.Title,
+		Glass:       recipe.Glass,
+		Garnish:     recipe.Garnish,
+		Preparation: recipe.Preparation,
+		Ice:         recipe.Ice,
+		BaseSpirit:  recipe.BaseSpirit,
+		Pours:       []DrinkSpecPour{},
+		Other:       []string{},
+		PartMl:      partMl,
+	}
+
+	smallest := 0.0
+	for _, ing := range recipe.IngredientList {
+		perUnit, ok := drinkMillilitres[ing.Unit]
+		if ing.Unit == "part" {
+			perUnit, ok = partMl, true
+		}
+		key := inventoryKey(ing.Name)
+		if !ing.Parsed || ing.Quantity == nil || !ok || key == "ice" || strings.HasSuffix(key, " ice") {
+			spec.Other = append(spec.Other, ing.Text)
+			continue
+		}
+
+		ml := *ing.Quantity * perUnit
+		if ml <= 0 {
+			spec.Other = append(spec.Other, ing.Text)
+			continue
+		}
+		spec.Pours = append(spec.Pours, DrinkSpecPour{Name: ing.Name, Text: ing.Text, Ml: ml})
+		spec.TotalMl += ml
+		if smallest == 0 || ml < smallest {
+			smallest = ml
+		}
+	}
+
+	if len(spec.Pours) == 0 {
+		return spec, errNoPours
+	}
+
+	parts := make([]string, len(spec.Pours))
+	for i := range spec.Pours {
+		pour := &spec.Pours[i]
+		pour.Parts = math.Round(pour.Ml/smallest*2) / 2
+		pour.Oz = math.Round(pour.Ml/fluidOunceMillilitres*4) / 4
+		pour.Ml = math.Round(pour.Ml*2) / 2
+		parts[i] = strconv.FormatFloat(pour.Parts, 'f', -1, 64)
+	}
+	spec.Ratio = strings.Join(parts, ":")
+	spec.TotalOz = math.Round(spec.TotalMl/fluidOunceMillilitres*4) / 4
+	spec.TotalMl = math.Round(spec.TotalMl*2) / 2
+	return spec, nil
+}
+
+// recipeSpecHandler serves GET /recipes/{id}/spec, a drink's spec in parts,
+// millilitres and ounces. ?scale= batches it, and ?part= sets the
+// millilitres poured for "1 part".
+func recipeSpecHandler(w http.ResponseWriter, r *http.Request, recipeID string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	partMl := defaultPartMillilitres
+	if v := r.URL.Query().Get("part"); v != "" {
+		ml, ok := parseNumber(v)
+		if !ok || math.IsNaN(ml) || ml <= 0 || math.IsInf(ml, 0) {
+			http.Error(w, "part must be a positive number of millilitres", http.StatusBadRequest)
+			return
+		}
+		partMl = ml
+	}
+
+	// Public read - no auth required
+	recipe, err := GetRecipeByID(r.Context(), recipeID)
+	if err != nil {
+		log.Printf("Error getting recipe: %v", err)
+		http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+		return
+	}
+	if recipe == nil {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	}
+	if recipe.RecipeType != "drink" {
+		http.Error(w, "Specs are only rendered for drink recipes", http.StatusBadRequest)
+		return
+	}
+
+	factor, err := scaleFactor(r.URL.Query(), recipe)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	scaleRecipe(recipe, factor)
+
+	spec, err := drinkSpec(recipe, partMl)
+	if errors.Is(err, errNoPours) {
+		http.Error(w, "Drink has no measured pours to make a spec from", http.StatusUnprocessableEntity)
+		return
+	}
+	spec.Scale = factor
+	json.NewEncoder(w).Encode(spec)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// recipeViewFlushInterval is how often recorded recipe views are saved
+const recipeViewFlushInterval = time.Minute
+
+// SetRecipeFavorite stars a recipe for a user, or unstars it
+func SetRecipeFavorite(ctx context.Context, userID, recipeID string, favorite bool) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if !favorite {
+		return applyChange(ctx, "unstar recipe "+recipeID+" for "+userID, func(ctx context.Context) error {
+			_, err := execWrite(ctx, `DELETE FROM recipe_favorites WHERE user_id = ? AND recipe_id = ?`, userID, recipeID)
+			return err
+		})
+	}
+
+	starredAt := time.Now()
+	return applyChange(ctx, "star recipe "+recipeID+" for "+userID, func(ctx context.Context) error {
+		query := `INSERT OR IGNORE INTO recipe_favorites (user_id, recipe_id, created_at) VALUES (?, ?, ?)`
+		_, err := execWrite(ctx, query, userID, recipeID, starredAt)
+		return err
+	})
+}
+
+// recipeView is a user's view of a recipe
+type recipeView struct {
+	userID   string
+	recipeID string
+}
+
+// Views are kept in memory and written in one change every
+// recipeViewFlushInterval, so browsing doesn't add a replicated change per
+// page view
+var (
+	pendingViewsMutex sync.Mutex
+	pendingViews      = make(map[recipeView]time.Time)
+)
+
+// RecordRecipeView notes that a user has just viewed a recipe, for their
+// recently viewed list. It is saved by the next flushRecipeViews.
+func RecordRecipeView(userID, recipeID string) {
+	pendingViewsMutex.Lock()
+	defer pendingViewsMutex.Unlock()
+
+	pendingViews[recipeView{userID: userID, recipeID: recipeID}] = time.Now()
+}
+
+// flushRecipeViews saves the views recorded since the last flush as one change.
+// Views of recipes or users that no longer exist are skipped.
+func flushRecipeViews(ctx context.Context) error {
+	pendingViewsMutex.Lock()
+	views := pendingViews
+	pendingViews = make(map[recipeView]time.Time)
+	pendingViewsMutex.Unlock()
+
+	if len(views) == 0 {
+		return nil
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	err := applyChange(ctx, fmt.Sprintf("record %d recipe views", len(views)), func(ctx context.Context) error {
+		query := `
+			INSERT INTO recipe_views (user_id, recipe_id, viewed_at)
+			SELECT u.firebase_uid, r.id, ?
+			FROM users u, recipes r
+			WHERE u.firebase_uid = ? AND r.id = ?
+			ON CONFLICT (user_id, recipe_id) DO UPDATE SET viewed_at = excluded.viewed_at
+		`
+		for view, viewedAt := range views {
+			if _, err := execWrite(ctx, query, viewedAt, view.userID, view.recipeID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// Keep the views for the next flush, unless they were viewed again since
+		pendingViewsMutex.Lock()
+		for view, viewedAt := range views {
+			if newer, ok := pendingViews[view]; !ok || newer.Before(viewedAt) {
+				pendingViews[view] = viewedAt
+			}
+		}
+		pendingViewsMutex.Unlock()
+		return fmt.Errorf("failed to record recipe views: %w", err)
+	}
+	return nil
+}
+
+// startRecipeViewFlusher saves recorded views every recipeViewFlushInterval
+// until ctx is done
+func startRecipeViewFlusher(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(recipeViewFlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := flushRecipeViews(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Warning: %v", err)
+			}
+		}
+	}()
+}
+
+// markFavorites sets IsFavorite on the recipes the user has starred. Nothing
+// happens for anonymous requests (userID "").
+func markFavorites(ctx context.Context, userID string, recipes []Recipe) error {
+	if userID == "" || len(recipes) == 0 {
+		return nil
+	}
+
+	rlockDB(ctx)
+	defer dbMutex.RUnlock()
+
+	rows, err := db.QueryContext(ctx, `SELECT recipe_id FROM recipe_favorites WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	favorites := make(map[string]bool)
+	for rows.Next() {
+		var recipeID string
+		if err := rows.Scan(&recipeID); err != nil {
+			return err
+		}
+		favorites[recipeID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range recipes {
+		recipes[i].IsFavorite = favorites[recipes[i].ID]
+	}
+	return nil
+}
+
+// recipeFavoriteHandler stars (PUT) or unstars (DELETE) a recipe for the
+// signed-in user: /recipes/{id}/favorite
+func recipeFavoriteHandler(w http.ResponseWriter, r *http.Request, recipeID string) {
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := authenticateRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	favorite := r.Method == http.MethodPut
+	if favorite {
+		recipe, err := GetRecipeByID(r.Context(), recipeID)
+		if err != nil {
+			log.Printf("Error getting recipe: %v", err)
+			http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+			return
+		}
+		if recipe == nil {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	if err := SetRecipeFavorite(r.Context(), userID, recipeID, favorite); err != nil {
+		log.Printf("Error updating favorite: %v", err)
+		http.Error(w, "Failed to update favorite", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
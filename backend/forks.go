@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LineageNode is a recipe in a tree of variants
+type LineageNode struct {
+	ID       string        `json:"id"`
+	Title    string        `json:"title"`
+	Variants []LineageNode `json:"variants"` // Recipes forked from this one
+}
+
+// VariantDiff lists the fields a variant changed from the recipe it is based on
+type VariantDiff struct {
+	RecipeID string           `json:"recipeId"`
+	BasedOn  RecipeReference  `json:"basedOn"`
+	Changes  []RevisionChange `json:"changes"`
+}
+
+var errForkParentNotFound = errors.New("recipe not found")
+
+// getLineageByRecipe returns the recipes each recipe is a variant of, from
+// the original to its parent, by recipe ID. Recipes in the trash are skipped
+// over, so a variant of a trashed recipe shows as a variant of the one before
+// it.
+func getLineageByRecipe(ctx context.Context, recipeIDs []string) (map[string][]RecipeReference, error) {
+	placeholders, args := inArgs(recipeIDs)
+	query := `
+		WITH RECURSIVE ancestors (recipe_id, id, depth) AS (
+			SELECT id, parent_recipe_id, 1 FROM recipes WHERE id IN (` + placeholders + `) AND parent_recipe_id IS NOT NULL
+			UNION ALL
+			SELECT a.recipe_id, r.parent_recipe_id, a.depth + 1
+			FROM recipes r JOIN ancestors a ON r.id = a.id
+			WHERE r.parent_recipe_id IS NOT NULL
+		)
+		SELECT a.recipe_id, r.id, r.title
+		FROM ancestors a
+		JOIN recipes r ON r.id = a.id AND r.deleted_at IS NULL
+		ORDER BY a.depth DESC
+	`
+	return queryRecipeReferencesByRecipe(ctx, query, args...)
+}
+
+// getVariantsByRecipe returns the recipes forked from each recipe, oldest
+// first, by recipe ID. Variants of its trashed variants are included in their
+// place.
+func getVariantsByRecipe(ctx context.Context, recipeIDs []string) (map[string][]RecipeReference, error) {
+	placeholders, args := inArgs(recipeIDs)
+	query := `
+		WITH RECURSIVE variants (recipe_id, id) AS (
+			SELECT parent_recipe_id, id FROM recipes WHERE parent_recipe_id IN (` + placeholders + `)
+			UNION
+			SELECT v.recipe_id, r.id
+			FROM recipes r
+			JOIN variants v ON r.parent_recipe_id = v.id
+			JOIN recipes trashed ON trashed.id = v.id AND trashed.deleted_at IS NOT NULL
+		)
+		SELECT v.recipe_id, r.id, r.title
+		FROM variants v
+		JOIN recipes r ON r.id = v.id AND r.deleted_at IS NULL
+		ORDER BY r.created_at
+	`
+	return queryRecipeReferencesByRecipe(ctx, query, args...)
+}
+
+// GetRecipeLineageTree returns the tree of variants that a recipe belongs to,
+// starting from the original recipe, or nil if the recipe doesn't exist
+func GetRecipeLineageTree(ctx context.Context, recipeID string) (*LineageNode, error) {
+	rlockDB(ctx)
+	defer dbMutex.RUnlock()
+
+	root := LineageNode{ID: recipeID}
+	err := db.QueryRowContext(ctx, `SELECT title FROM recipes WHERE id = ? AND deleted_at IS NULL`, recipeID).Scan(&root.Title)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lineages, err := getLineageByRecipe(ctx, []string{recipeID})
+	if err != nil {
+		return nil, err
+	}
+	if lineage := lineages[recipeID]; len(lineage) > 0 {
+		root = LineageNode{ID: lineage[0].ID, Title: lineage[0].Title}
+	}
+
+	if err := addVariants(ctx, &root); err != nil {
+		return nil, err
+	}
+	return &root, nil
+}
+
+// addVariants fills in the variants below node
+func addVariants(ctx context.Context, node *LineageNode) error {
+	byRecipe, err := getVariantsByRecipe(ctx, []string{node.ID})
+	if err != nil {
+		return err
+	}
+
+	variants := byRecipe[node.ID]
+	node.Variants = make([]LineageNode, len(variants))
+	for i, variant := range variants {
+		node.Variants[i] = LineageNode{ID: variant.ID, Title: variant.Title}
+		if err := addVariants(ctx, &node.Variants[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForkRecipe copies a recipe and its tags into a new variant by userID. The
+// variant shares the parent's images rather than copying them in storage.
+// An empty title names it after the parent.
+func ForkRecipe(ctx context.Context, parentID, title, userID string, userName *string) (*Recipe, error) {
+	parent, err := GetRecipeByID(ctx, parentID)
+	if err != nil {
+		return nil, err
+	}
+	if parent == nil {
+		return nil, errForkParentNotFound
+	}
+
+	if title == "" {
+		title = parent.Title + " (variant)"
+	}
+
+	now := time.Now()
+	fork := Recipe{
+		ID:               uuid.New().String(),
+		Title:            title,
+		Description:      parent.Description,
+		RecipeType:       parent.RecipeType,
+		Cuisine:          parent.Cuisine,
+		Servings:         parent.Servings,
+		Yield:            parent.Yield,
+		PrepTimeMinutes:  parent.PrepTimeMinutes,
+		CookTimeMinutes:  parent.CookTimeMinutes,
+		TotalTimeMinutes: parent.TotalTimeMinutes,
+		Glass:            parent.Glass,
+		Garnish:          parent.Garnish,
+		Preparation:      parent.Preparation,
+		Ice:              parent.Ice,
+		BaseSpirit:       parent.BaseSpirit,
+		Ingredients:      parent.Ingredients,
+		Method:           parent.Method,
+		Notes:            parent.Notes,
+		Sources:          parent.Sources,
+		IconID:           parent.IconID,
+		Tags:             parent.Tags,
+		CreatedByUserID:  &userID,
+		CreatedByName:    userName,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	// The parent may have gone to the trash since it was read
+	var exists bool
+	err = db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM recipes WHERE id = ? AND deleted_at IS NULL)`, parentID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errForkParentNotFound
+	}
+
+	imageIDs := make([]int64, len(parent.Images))
+	for i := range parent.Images {
+		imageIDs[i] = newRowID()
+	}
+
+	err = applyChange(ctx, "fork recipe "+parentID+" as "+fork.ID, func(ctx context.Context) error {
+		if err := insertRecipe(ctx, fork, &parentID); err != nil {
+			return err
+		}
+
+		for i, img := range parent.Images {
+			query := `INSERT INTO recipe_images (id, recipe_id, image_url, display_order, created_at) VALUES (?, ?, ?, ?, ?)`
+			if _, err := execWrite(ctx, query, imageIDs[i], fork.ID, img.ImageURL, img.DisplayOrder, img.CreatedAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &fork, nil
+}
+
+// recipeContent returns a recipe's content in the form revisions are diffed in
+func recipeContent(r *Recipe) *RecipeRevision {
+	return &RecipeRevision{
+		RecipeID:         r.ID,
+		Title:            r.Title,
+		Description:      r.Description,
+		RecipeType:       r.RecipeType,
+		Cuisine:          r.Cuisine,
+		Servings:         r.Servings,
+		Yield:            r.Yield,
+		PrepTimeMinutes:  r.PrepTimeMinutes,
+		CookTimeMinutes:  r.CookTimeMinutes,
+		TotalTimeMinutes: r.TotalTimeMinutes,
+		Glass:            r.Glass,
+		Garnish:          r.Garnish,
+		Preparation:      r.Preparation,
+		Ice:              r.Ice,
+		BaseSpirit:       r.BaseSpirit,
+		Ingredients:      r.Ingredients,
+		Method:           r.Method,
+		Notes:            r.Notes,
+		Sources:          r.Sources,
+		IconID:           r.IconID,
+		Tags:             r.Tags,
+	}
+}
+
+// recipeVariantsHandler serves the variant paths below /recipes/{id}:
+//
+//	POST /recipes/{id}/fork      copy the recipe into a new variant
+//	GET  /recipes/{id}/lineage   tree of variants, from the original recipe
+//	GET  /recipes/{id}/diff      changes from the recipe it is based on
+func recipeVariantsHandler(w http.ResponseWriter, r *http.Request, recipeID, subPath string) {
+	switch subPath {
+	case "fork":
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Auth required for writes
+		userID, err := authenticateRequest(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		log.Printf("Forking recipe %s - authenticated user: %s", recipeID, userID)
+
+		// The body is optional: {"title": "Spicy chicken curry"}
+		var body struct {
+			Title string `json:"title"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		fork, err := ForkRecipe(r.Context(), recipeID, strings.TrimSpace(body.Title), userID, userDisplayName(r.Context(), userID))
+		if errors.Is(err, errForkParentNotFound) {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error forking recipe: %v", err)
+			http.Error(w, "Failed to fork recipe", http.StatusInternalServerError)
+			return
+		}
+
+		recipe, err := GetRecipeByID(r.Context(), fork.ID)
+		if err != nil || recipe == nil {
+			log.Printf("Error getting forked recipe: %v", err)
+			http.Error(w, "Failed to get forked recipe", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(recipe)
+
+	case "lineage":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Public read - no auth required
+		tree, err := GetRecipeLineageTree(r.Context(), recipeID)
+		if err != nil {
+			log.Printf("Error getting recipe lineage: %v", err)
+			http.Error(w, "Failed to get recipe lineage", http.StatusInternalServerError)
+			return
+		}
+		if tree == nil {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(tree)
+
+	case "diff":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Public read - no auth required
+		recipe, err := GetRecipeByID(r.Context(), recipeID)
+		if err != nil {
+			log.Printf("Error getting recipe: %v", err)
+			http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+			return
+		}
+		if recipe == nil {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		if len(recipe.Lineage) == 0 {
+			http.Error(w, "Recipe isn't a variant of another recipe", http.StatusNotFound)
+			return
+		}
+
+		basedOn := recipe.Lineage[len(recipe.Lineage)-1]
+		parent, err := GetRecipeByID(r.Context(), basedOn.ID)
+		if err != nil {
+			log.Printf("Error getting parent recipe: %v", err)
+			http.Error(w, "Failed to get parent recipe", http.StatusInternalServerError)
+			return
+		}
+		if parent == nil {
+			http.Error(w, "Recipe isn't a variant of another recipe", http.StatusNotFound)
+			return
+		}
+
+		json.NewEncoder(w).Encode(VariantDiff{
+			RecipeID: recipeID,
+			BasedOn:  basedOn,
+			Changes:  diffRevisions(recipeContent(parent), recipeContent(recipe)),
+		})
+
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
@@ -3,12 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
-	"io"
 	"mime/multipart"
 	"path/filepath"
 	"strings"
 
-	"cloud.google.com/go/storage"
 	"github.com/google/uuid"
 )
 
@@ -22,7 +20,7 @@ const (
 	allowedIconExts = ".jpg,.jpeg,.png,.svg,.webp"
 )
 
-// UploadImageToGCS uploads an image file to Google Cloud Storage
+// UploadImageToGCS uploads an image file to blob storage
 func UploadImageToGCS(ctx context.Context, file multipart.File, fileHeader *multipart.FileHeader, recipeID string) (string, error) {
 	// Validate file size
 	if fileHeader.Size > maxImageSize {
@@ -38,35 +36,17 @@ func UploadImageToGCS(ctx context.Context, file multipart.File, fileHeader *mult
 	// Generate unique filename
 	filename := fmt.Sprintf("%s/%s/%s%s", imagePathPrefix, recipeID, uuid.New().String(), ext)
 
-	// Create GCS client
-	client, err := storage.NewClient(ctx)
+	// Upload to blob storage
+	_, err := blobStore.Put(ctx, filename, file, PutOptions{ContentType: getContentType(ext)})
 	if err != nil {
-		return "", fmt.Errorf("failed to create storage client: %w", err)
-	}
-	defer client.Close()
-
-	// Create GCS writer
-	wc := client.Bucket(bucketName).Object(filename).NewWriter(ctx)
-	wc.ContentType = getContentType(ext)
-
-	// Copy file to GCS
-	if _, err = io.Copy(wc, file); err != nil {
-		wc.Close()
 		return "", fmt.Errorf("failed to write file to storage: %w", err)
 	}
 
-	// Close writer (uploads the file)
-	if err := wc.Close(); err != nil {
-		return "", fmt.Errorf("failed to close storage writer: %w", err)
-	}
-
 	// Return public URL
-	// Format: https://storage.googleapis.com/{bucket}/{object}
-	imageURL := fmt.Sprintf("https://storage.googleapis.com/%s/%s", bucketName, filename)
-	return imageURL, nil
+	return blobStore.PublicURL(filename), nil
 }
 
-// UploadIconToGCS uploads an icon file to Google Cloud Storage
+// UploadIconToGCS uploads an icon file to blob storage
 func UploadIconToGCS(ctx context.Context, file multipart.File, fileHeader *multipart.FileHeader) (string, string, error) {
 	// Validate file size
 	if fileHeader.Size > maxIconSize {
@@ -83,53 +63,27 @@ func UploadIconToGCS(ctx context.Context, file multipart.File, fileHeader *multi
 	uniqueFilename := fmt.Sprintf("%s%s", uuid.New().String(), ext)
 	objectPath := fmt.Sprintf("%s/%s", iconPathPrefix, uniqueFilename)
 
-	// Create GCS client
-	client, err := storage.NewClient(ctx)
+	// Upload to blob storage
+	_, err := blobStore.Put(ctx, objectPath, file, PutOptions{ContentType: getContentType(ext)})
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create storage client: %w", err)
-	}
-	defer client.Close()
-
-	// Create GCS writer
-	wc := client.Bucket(bucketName).Object(objectPath).NewWriter(ctx)
-	wc.ContentType = getContentType(ext)
-
-	// Copy file to GCS
-	if _, err = io.Copy(wc, file); err != nil {
-		wc.Close()
 		return "", "", fmt.Errorf("failed to write file to storage: %w", err)
 	}
 
-	// Close writer (uploads the file)
-	if err := wc.Close(); err != nil {
-		return "", "", fmt.Errorf("failed to close storage writer: %w", err)
-	}
-
 	// Return both the filename and public URL
-	iconURL := fmt.Sprintf("https://storage.googleapis.com/%s/%s", bucketName, objectPath)
+	iconURL := blobStore.PublicURL(objectPath)
 	return uniqueFilename, iconURL, nil
 }
 
-// DeleteImageFromGCS deletes an image from Google Cloud Storage
+// DeleteImageFromGCS deletes an image from blob storage
 func DeleteImageFromGCS(ctx context.Context, imageURL string) error {
 	// Extract object path from URL
-	// URL format: https://storage.googleapis.com/{bucket}/{object}
-	parts := strings.Split(imageURL, "/")
-	if len(parts) < 5 {
-		return fmt.Errorf("invalid image URL format")
-	}
-
-	// Get object path (everything after bucket name)
-	objectPath := strings.Join(parts[4:], "/")
-
-	client, err := storage.NewClient(ctx)
+	objectPath, err := blobKeyFromURL(imageURL)
 	if err != nil {
-		return fmt.Errorf("failed to create storage client: %w", err)
+		return err
 	}
-	defer client.Close()
 
 	// Delete object
-	if err := client.Bucket(bucketName).Object(objectPath).Delete(ctx); err != nil {
+	if err := blobStore.Delete(ctx, objectPath); err != nil {
 		return fmt.Errorf("failed to delete image from storage: %w", err)
 	}
 
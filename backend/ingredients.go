@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Ingredient is one line of a recipe's ingredients markdown, broken into parts.
+// Lines the parser can't make sense of are kept with Parsed false and only
+// Text (and Group) set.
+type Ingredient struct {
+	Group       string   `json:"group"`       // "### heading" the line appears under, if any
+	Quantity    *float64 `json:"quantity"`    // Nullable: "salt to taste" has none
+	QuantityMax *float64 `json:"quantityMax"` // Upper bound of a range such as "1-2"
+	Unit        string   `json:"unit"`        // Canonical unit, e.g. "tbsp", "g", "clove"
+	Name        string   `json:"name"`
+	Preparation string   `json:"preparation"` // e.g. "finely chopped"
+	Text        string   `json:"text"`        // The line as written, without its list marker
+	Parsed      bool     `json:"parsed"`
+}
+
+// unicodeFractions maps vulgar fraction characters to their values
+var unicodeFractions = map[rune]float64{
+	'½': 1.0 / 2, '⅓': 1.0 / 3, '⅔': 2.0 / 3, '¼': 1.0 / 4, '¾': 3.0 / 4,
+	'⅕': 1.0 / 5, '⅖': 2.0 / 5, '⅗': 3.0 / 5, '⅘': 4.0 / 5, '⅙': 1.0 / 6,
+	'⅚': 5.0 / 6, '⅛': 1.0 / 8, '⅜': 3.0 / 8, '⅝': 5.0 / 8, '⅞': 7.0 / 8,
+}
+
+// ingredientUnits maps the ways a unit is written to its canonical name
+var ingredientUnits = map[string]string{
+	"tsp": "tsp", "tsps": "tsp", "teaspoon": "tsp", "teaspoons": "tsp",
+	"tbsp": "tbsp", "tbsps": "tbsp", "tbs": "tbsp", "tablespoon": "tbsp", "tablespoons": "tbsp",
+	"cup": "cup", "cups": "cup",
+	"ml": "ml", "mls": "ml", "millilitre": "ml", "millilitres": "ml", "milliliter": "ml", "milliliters": "ml",
+	"l": "l", "litre": "l", "litres": "l", "liter": "l", "liters": "l",
+	"g": "g", "gm": "g", "gms": "g", "gram": "g", "grams": "g",
+	"kg": "kg", "kgs": "kg", "kilo": "kg", "kilos": "kg", "kilogram": "kg", "kilograms": "kg",
+	"oz": "oz", "ounce": "oz", "ounces": "oz",
+	"lb": "lb", "lbs": "lb", "pound": "lb", "pounds": "lb",
+	"part": "part", "parts": "part",
+	"pinch": "pinch", "pinches": "pinch",
+	"dash": "dash", "dashes": "dash",
+	"splash": "splash", "splashes": "splash",
+	"drop": "drop", "drops": "drop",
+	"clove": "clove", "cloves": "clove",
+	"can": "can", "cans": "can", "tin": "tin", "tins": "tin",
+	"bunch": "bunch", "bunches": "bunch",
+	"handful": "handful", "handfuls": "handful",
+	"slice": "slice", "slices": "slice",
+	"sprig": "sprig", "sprigs": "sprig",
+	"stalk": "stalk", "stalks": "stalk",
+	"piece": "piece", "pieces": "piece",
+	"cube": "cube", "cubes": "cube",
+	"head": "head", "heads": "head",
+	"sheet": "sheet", "sheets": "sheet",
+	"packet": "packet", "packets": "packet", "package": "packet", "packages": "packet", "pack": "packet", "packs": "packet",
+}
+
+// unitModifiers qualify a unit ("2 heaped tsp") and are kept as preparation
+var unitModifiers = map[string]bool{
+	"heaped": true, "heaping": true, "level": true, "rounded": true, "scant": true, "generous": true,
+}
+
+// leadingAdjectives can come before a comma that is still part of the name,
+// as in "boneless, skinless chicken thighs"
+var leadingAdjectives = map[string]bool{
+	"large": true, "small": true, "medium": true, "fresh": true, "ripe": true, "firm": true, "lean": true, "whole": true,
+}
+
+const numberPattern = `\d+\s+\d+/\d+|\d*\s*[½⅓⅔¼¾⅕⅖⅗⅘⅙⅚⅛⅜⅝⅞]|\d+/\d+|\d+(?:\.\d+)?`
+
+// quantityRegexp matches a leading quantity or range: "2", "1.5", "1/2",
+// "1 1/2", "½", "1½", "1-2", "10 to 12"
+var quantityRegexp = regexp.MustCompile(`^(` + numberPattern + `)(?:\s*(?:-|–|to)\s*(` + numberPattern + `))?`)
+
+// parseIngredients breaks an ingredients markdown list into ingredients.
+// "### heading" lines set the group for the lines after them.
+func parseIngredients(markdown string) []Ingredient {
+	ingredients := []Ingredient{}
+	group := ""
+
+	for _, line := range strings.Split(markdown, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "#") {
+			group = strings.TrimSpace(strings.TrimLeft(trimmed, "#"))
+			continue
+		}
+
+		ingredient := parseIngredientLine(stripListMarker(trimmed))
+		ingredient.Group = group
+		ingredients = append(ingredients, ingredient)
+	}
+
+	return ingredients
+}
+
+// stripListMarker removes a leading "- ", "* ", "+ " or "1. " from a line
+func stripListMarker(line string) string {
+	for _, marker := range []string{"- ", "* ", "+ "} {
+		if strings.HasPrefix(line, marker) {
+			return strings.TrimSpace(line[len(marker):])
+		}
+	}
+
+	digits := strings.IndexFunc(line, func(r rune) bool { return r < '0' || r > '9' })
+	if digits > 0 && (strings.HasPrefix(line[digits:], ". ") || strings.HasPrefix(line[digits:], ") ")) {
+		return strings.TrimSpace(line[digits+2:])
+	}
+	return line
+}
+
+// parseIngredientLine splits a single ingredient into quantity, unit, name and
+// preparation, e.g. "2 tbsp soy sauce" or "3 garlic cloves, minced"
+func parseIngredientLine(text string) Ingredient {
+	ingredient := Ingredient{Text: text, Parsed: true}
+
+	rest := text
+	var notes []string
+	if quantity, quantityMax, afterQuantity, ok := parseQuantity(rest); ok {
+		ingredient.Quantity = &quantity
+		ingredient.QuantityMax = quantityMax
+		rest = afterQuantity
+
+		// "2 heaped tsp cumin"
+		if word, afterWord, found := strings.Cut(strings.TrimLeft(rest, " "), " "); found && unitModifiers[strings.ToLower(word)] {
+			if unit, _ := parseUnit(afterWord); unit != "" {
+				notes = append(notes, word)
+				rest = afterWord
+			}
+		}
+
+		// A unit needs something after it: "5 cloves" is five cloves, not
+		// five cloves of nothing
+		if unit, afterUnit := parseUnit(rest); unit != "" && strings.TrimSpace(afterUnit) != "" {
+			ingredient.Unit = unit
+			rest = afterUnit
+
+			// "1/2 cup / 125 ml water" gives the same amount in another unit
+			if alternative, afterAlternative, ok := cutAlternativeMeasure(rest); ok {
+				notes = append(notes, alternative)
+				rest = afterAlternative
+			}
+		}
+	} else if article, afterArticle, found := strings.Cut(rest, " "); found && (strings.EqualFold(article, "a") || strings.EqualFold(article, "an")) {
+		// "a pinch of salt"
+		if unit, afterUnit := parseUnit(afterArticle); unit != "" && strings.TrimSpace(afterUnit) != "" {
+			one := 1.0
+			ingredient.Quantity = &one
+			ingredient.Unit = unit
+			rest = afterUnit
+		}
+	} else if unit, afterUnit := parseUnit(rest); unit != "" && strings.HasPrefix(strings.ToLower(strings.TrimSpace(afterUnit)), "of ") {
+		// "pinch of salt" has a unit but no quantity
+		ingredient.Unit = unit
+		rest = afterUnit
+	}
+
+	// "1 cup [[Simple syrup]]" is simple syrup, made from another recipe
+	rest = strings.TrimSpace(stripRecipeLinks(rest))
+	if lower := strings.ToLower(rest); strings.HasPrefix(lower, "of ") {
+		rest = strings.TrimSpace(rest[3:])
+	}
+
+	name, preparation := splitPreparation(rest)
+	if preparation != "" {
+		notes = append(notes, preparation)
+	}
+	ingredient.Name, ingredient.Preparation = name, strings.Join(notes, "; ")
+
+	// Without a leading quantity, a line that still mentions numbers is most
+	// likely prose ("juice of 1 lime") and is kept as written
+	if ingredient.Name == "" || (ingredient.Quantity == nil && strings.IndexFunc(text, unicode.IsDigit) >= 0) {
+		return Ingredient{Text: text}
+	}
+	return ingredient
+}
+
+// cutAlternativeMeasure reads a leading "/ 125 ml" from s
+func cutAlternativeMeasure(s string) (string, string, bool) {
+	trimmed := strings.TrimSpace(s)
+	if !strings.HasPrefix(trimmed, "/") {
+		return "", s, false
+	}
+	trimmed = strings.TrimSpace(trimmed[1:])
+
+	_, _, afterQuantity, ok := parseQuantity(trimmed)
+	if !ok {
+		return "", s, false
+	}
+	unit, afterUnit := parseUnit(afterQuantity)
+	if unit == "" {
+		return "", s, false
+	}
+	return strings.TrimSpace(trimmed[:len(trimmed)-len(afterUnit)]), afterUnit, true
+}
+
+// parseQuantity reads a leading quantity or range from s
+func parseQuantity(s string) (float64, *float64, string, bool) {
+	lower := strings.ToLower(s)
+	for _, half := range []string{"half a ", "half an "} {
+		if strings.HasPrefix(lower, half) {
+			return 0.5, nil, s[len(half)-1:], true
+		}
+	}
+
+	match := quantityRegexp.FindStringSubmatchIndex(s)
+	if match == nil {
+		return 0, nil, s, false
+	}
+
+	// The quantity must end the word: "2L" and "2 cups" are fine, "2nd" is not
+	rest := s[match[1]:]
+	if next := []rune(rest); len(next) > 0 && unicode.IsLetter(next[0]) {
+		if unit, _ := parseUnit(rest); unit == "" {
+			return 0, nil, s, false
+		}
+	}
+
+	quantity, ok := parseNumber(s[match[2]:match[3]])
+	if !ok {
+		return 0, nil, s, false
+	}
+
+	var quantityMax *float64
+	if match[4] >= 0 {
+		if max, ok := parseNumber(s[match[4]:match[5]]); ok {
+			quantityMax = &max
+		}
+	}
+
+	return quantity, quantityMax, rest, true
+}
+
+// parseNumber parses "2", "1.5", "1/2", "1 1/2", "½" or "1½"
+func parseNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+
+	var whole float64
+	if fields := strings.Fields(s); len(fields) == 2 {
+		w, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return 0, false
+		}
+		whole, s = w, fields[1]
+	}
+
+	if runes := []rune(s); len(runes) > 0 {
+		if fraction, ok := unicodeFractions[runes[len(runes)-1]]; ok {
+			if len(runes) > 1 {
+				w, err := strconv.ParseFloat(string(runes[:len(runes)-1]), 64)
+				if err != nil {
+					return 0, false
+				}
+				whole += w
+			}
+			return whole + fraction, true
+		}
+	}
+
+	if numerator, denominator, ok := strings.Cut(s, "/"); ok {
+		n, err1 := strconv.ParseFloat(numerator, 64)
+		d, err2 := strconv.ParseFloat(denominator, 64)
+		if err1 != nil || err2 != nil || d == 0 {
+			return 0, false
+		}
+		return whole + n/d, true
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return whole + v, true
+}
+
+// parseUnit reads a leading unit from s, returning its canonical name and the
+// text after it, or "" if s doesn't start with a unit
+func parseUnit(s string) (string, string) {
+	s = strings.TrimLeft(s, " ")
+	lower := strings.ToLower(s)
+
+	for _, flOz := range []string{"fl oz", "fl. oz", "fluid ounces", "fluid ounce"} {
+		if strings.HasPrefix(lower, flOz) && wordEnds(s[len(flOz):]) {
+			return "fl oz", strings.TrimPrefix(s[len(flOz):], ".")
+		}
+	}
+
+	end := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsLetter(r) })
+	if end < 0 {
+		end = len(s)
+	}
+	if end == 0 {
+		return "", s
+	}
+
+	unit, ok := ingredientUnits[strings.ToLower(s[:end])]
+	if !ok {
+		return "", s
+	}
+	// "2 tbsp. oil"
+	return unit, strings.TrimPrefix(s[end:], ".")
+}
+
+// wordEnds reports whether s starts at a word boundary
+func wordEnds(s string) bool {
+	runes := []rune(s)
+	return len(runes) == 0 || !unicode.IsLetter(runes[0])
+}
+
+// splitPreparation separates a name from its preparation note: the text after
+// the first comma ("garlic, minced") and any parentheses ("chilli (optional)")
+func splitPreparation(s string) (string, string) {
+	name, preparation := s, ""
+
+	depth := 0
+commas:
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			before := strings.TrimSpace(s[:i])
+			word := strings.ToLower(before)
+			if depth != 0 || (!strings.Contains(word, " ") && (strings.HasSuffix(word, "less") || leadingAdjectives[word])) {
+				continue
+			}
+			name, preparation = before, strings.TrimSpace(s[i+1:])
+			break commas
+		}
+	}
+
+	// Move parenthesised notes out of the name
+	var notes []string
+	for {
+		open := strings.Index(name, "(")
+		close := strings.Index(name, ")")
+		if open < 0 || close < open {
+			break
+		}
+		notes = append(notes, strings.TrimSpace(name[open+1:close]))
+		name = name[:open] + name[close+1:]
+	}
+	if preparation != "" {
+		notes = append(notes, preparation)
+	}
+
+	return strings.Join(strings.Fields(name), " "), strings.Join(notes, "; ")
+}
+
+// setRecipeIngredients replaces a recipe's structured ingredients with those
+// parsed from its ingredients markdown. The caller must be inside applyChange.
+func setRecipeIngredients(ctx context.Context, recipeID, markdown string) error {
+	if _, err := execWrite(ctx, `DELETE FROM recipe_ingredients WHERE recipe_id = ?`, recipeID); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO recipe_ingredients (recipe_id, position, group_name, quantity, quantity_max, unit, name, preparation, raw, parsed)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	for i, ing := range parseIngredients(markdown) {
+		_, err := execWrite(ctx, query,
+			recipeID, i, ing.Group, ing.Quantity, ing.QuantityMax,
+			ing.Unit, ing.Name, ing.Preparation, ing.Text, ing.Parsed,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getIngredientsByRecipe returns the structured ingredients of each recipe in
+// order, by recipe ID
+func getIngredientsByRecipe(ctx context.Context, recipeIDs []string) (map[string][]Ingredient, error) {
+	placeholders, args := inArgs(recipeIDs)
+	query := `
+		SELECT recipe_id, COALESCE(group_name, ''), quantity, quantity_max, COALESCE(unit, ''), COALESCE(name, ''), COALESCE(preparation, ''), raw, parsed
+		FROM recipe_ingredients
+		WHERE recipe_id IN (` + placeholders + `)
+		ORDER BY position
+	`
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ingredients := make(map[string][]Ingredient)
+	for rows.Next() {
+		var recipeID string
+		var ing Ingredient
+		if err := rows.Scan(&recipeID, &ing.Group, &ing.Quantity, &ing.QuantityMax, &ing.Unit, &ing.Name, &ing.Preparation, &ing.Text, &ing.Parsed); err != nil {
+			return nil, err
+		}
+		ingredients[recipeID] = append(ingredients[recipeID], ing)
+	}
+
+	return ingredients, rows.Err()
+}
+
+// backfillRecipeIngredients parses the ingredients of recipes that don't have
+// structured ingredients yet, such as those created before they existed or
+// loaded with cmd/import-recipes
+func backfillRecipeIngredients(ctx context.Context) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	query := `
+		SELECT id, ingredients
+		FROM recipes r
+		WHERE COALESCE(ingredients, '') != ''
+		  AND NOT EXISTS (SELECT 1 FROM recipe_ingredients WHERE recipe_id = r.id)
+	`
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	markdown := make(map[string]string)
+	for rows.Next() {
+		var id, ingredients string
+		if err := rows.Scan(&id, &ingredients); err != nil {
+			rows.Close()
+			return err
+		}
+		// Headings alone give nothing to store, and would be retried at every startup
+		if len(parseIngredients(ingredients)) > 0 {
+			markdown[id] = ingredients
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(markdown) == 0 {
+		return nil
+	}
+
+	err = applyChange(ctx, "parse recipe ingredients", func(ctx context.Context) error {
+		for id, ingredients := range markdown {
+			if err := setRecipeIngredients(ctx, id, ingredients); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Parsed structured ingredients for %d recipes", len(markdown))
+	return nil
+}
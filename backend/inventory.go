@@ -0,0 +1,556 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InventoryItem is an ingredient the household has on hand. Like recipes and
+// the meal plan, the inventory is shared by the household.
+type InventoryItem struct {
+	ID            int64     `json:"id"`
+	Name          string    `json:"name"`
+	AddedByUserID *string   `json:"addedByUserId"`
+	AddedAt       time.Time `json:"addedAt"`
+}
+
+// IngredientAlias says that an inventory item covers an ingredient with a
+// different name, e.g. "lime" covers "lime juice"
+type IngredientAlias struct {
+	Alias           string     `json:"alias"`      // Ingredient name, normalised
+	Ingredient      string     `json:"ingredient"` // Name of the item that covers it, normalised
+	BuiltIn         bool       `json:"builtIn"`    // Part of the server rather than added by a user
+	CreatedByUserID *string    `json:"createdByUserId"`
+	CreatedAt       *time.Time `json:"createdAt"` // Nullable for built-in aliases
+}
+
+// InventoryMatch is how much of a recipe's ingredients are on hand
+type InventoryMatch struct {
+	RecipeID   string   `json:"recipeId"`
+	Title      string   `json:"title"`
+	RecipeType string   `json:"type"`
+	Have       int      `json:"have"`     // Ingredients on hand
+	Need       int      `json:"need"`     // Ingredients needed, leaving out optional ones and staples
+	Coverage   float64  `json:"coverage"` // Have / need, from 0 to 1
+	Missing    []string `json:"missing"`  // Ingredients not on hand, as named in the recipe
+}
+
+var (
+	errInventoryItemNotFound   = errors.New("inventory item not found")
+	errIngredientAliasNotFound = errors.New("ingredient alias not found")
+)
+
+// builtInAliases map ingredients to the inventory items that cover them. An
+// ingredient also matches an alias it ends with, so "fresh lime juice" is
+// covered by "lime" too.
+var builtInAliases = map[string]string{
+	"lime juice": "lime", "lime wedge": "lime", "lime wheel": "lime", "lime slice": "lime",
+	"lime zest": "lime", "lime peel": "lime", "lime twist": "lime",
+	"lemon juice": "lemon", "lemon wedge": "lemon", "lemon wheel": "lemon", "lemon slice": "lemon",
+	"lemon zest": "lemon", "lemon peel": "lemon", "lemon twist": "lemon",
+	"orange juice": "orange", "orange wedge": "orange", "orange wheel": "orange", "orange slice": "orange",
+	"orange zest": "orange", "orange peel": "orange", "orange twist": "orange",
+	"grapefruit juice": "grapefruit", "grapefruit peel": "grapefruit", "grapefruit twist": "grapefruit",
+	"egg white": "egg", "egg yolk": "egg",
+	"simple syrup": "sugar", "sugar syrup": "sugar",
+	"club soda": "soda water", "sparkling water": "soda water",
+	"vermouth rosso": "sweet vermouth", "rosso vermouth": "sweet vermouth",
+	"garlic clove": "garlic",
+}
+
+// alwaysOnHand are staples that are assumed to be on hand and left out of matching
+var alwaysOnHand = map[string]bool{
+	"water": true, "cold water": true, "warm water": true, "hot water": true, "boiling water": true,
+	"ice": true, "ice cube": true, "crushed ice": true, "cubed ice": true,
+	"salt": true, "sea salt": true, "kosher salt": true, "table salt": true, "flaky salt": true,
+	"pepper": true, "black pepper": true, "ground black pepper": true, "salt and pepper": true,
+}
+
+// optionalMarkers in an ingredient's name or preparation mean a recipe can do without it
+var optionalMarkers = []string{"optional", "garnish", "to taste", "to serve", "for serving"}
+
+// inventoryKey normalises an ingredient or item name so "Limes" and "lime"
+// are the same
+func inventoryKey(name string) string {
+	return shoppingItemKey(strings.Trim(shoppingName(name), " .,;:()"))
+}
+
+// inventoryItemName returns the name to keep for an item written like an
+// ingredient, e.g. "limes" for "2 limes"
+func inventoryItemName(text string) string {
+	ing := parseIngredientLine(strings.TrimSpace(text))
+	if !ing.Parsed || ing.Name == "" {
+		return strings.TrimSpace(text)
+	}
+	return ing.Name
+}
+
+// GetInventory returns the items on hand, by name
+func GetInventory(ctx context.Context) ([]InventoryItem, error) {
+	rlockDB(ctx)
+	defer dbMutex.RUnlock()
+
+	query := `SELECT id, name, added_by_user_id, added_at FROM inventory_items ORDER BY name COLLATE NOCASE`
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []InventoryItem{}
+	for rows.Next() {
+		var item InventoryItem
+		if err := rows.Scan(&item.ID, &item.Name, &item.AddedByUserID, &item.AddedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// AddInventoryItem puts an item on hand. If an item with the same name is
+// already there, it is returned instead and created is false.
+func AddInventoryItem(ctx context.Context, item *InventoryItem) (created bool, err error) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	key := inventoryKey(item.Name)
+	newID := newRowID()
+	addedAt := time.Now()
+	i := *item
+	err = applyChange(ctx, "add inventory item "+key, func(ctx context.Context) error {
+		// Keep an existing item with the same name, which a replay on a newer
+		// replica may find
+		query := `
+			INSERT INTO inventory_items (id, name, item_key, added_by_user_id, added_at)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (item_key) DO NOTHING
+		`
+		if _, err := execWrite(ctx, query, newID, i.Name, key, i.AddedByUserID, addedAt); err != nil {
+			return err
+		}
+
+		query = `SELECT id, name, added_by_user_id, added_at FROM inventory_items WHERE item_key = ?`
+		return dbConn(ctx).QueryRowContext(ctx, query, key).Scan(&item.ID, &item.Name, &item.AddedByUserID, &item.AddedAt)
+	})
+	if err != nil {
+		return false, err
+	}
+	return item.ID == newID, nil
+}
+
+// DeleteInventoryItem takes an item off hand
+func DeleteInventoryItem(ctx context.Context, itemID int64) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	return applyChange(ctx, fmt.Sprintf("delete inventory item %d", itemID), func(ctx context.Context) error {
+		result, err := execWrite(ctx, `DELETE FROM inventory_items WHERE id = ?`, itemID)
+		if err != nil {
+			return err
+		}
+		return requireRowAffected(result, errInventoryItemNotFound)
+	})
+}
+
+// GetIngredientAliases returns the built-in aliases and those added by users,
+// which take precedence, by alias
+func GetIngredientAliases(ctx context.Context) ([]IngredientAlias, error) {
+	rlockDB(ctx)
+	defer dbMutex.RUnlock()
+
+	return getIngredientAliases(ctx)
+}
+
+func getIngredientAliases(ctx context.Context) ([]IngredientAlias, error) {
+	rows, err := db.QueryContext(ctx, `SELECT alias, ingredient, created_by_user_id, created_at FROM ingredient_aliases`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aliases := []IngredientAlias{}
+	custom := make(map[string]bool)
+	for rows.Next() {
+		var a IngredientAlias
+		if err := rows.Scan(&a.Alias, &a.Ingredient, &a.CreatedByUserID, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, a)
+		custom[a.Alias] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for alias, ingredient := range builtInAliases {
+		if !custom[alias] {
+			aliases = append(aliases, IngredientAlias{Alias: alias, Ingredient: ingredient, BuiltIn: true})
+		}
+	}
+	sort.Slice(aliases, func(i, j int) bool { return aliases[i].Alias < aliases[j].Alias })
+	return aliases, nil
+}
+
+// SetIngredientAlias says which item covers an ingredient, replacing any
+// alias for it, including a built-in one
+func SetIngredientAlias(ctx context.Context, alias *IngredientAlias) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	createdAt := time.Now()
+	alias.CreatedAt = &createdAt
+	a := *alias
+	return applyChange(ctx, "set ingredient alias "+a.Alias, func(ctx context.Context) error {
+		query := `
+			INSERT INTO ingredient_aliases (alias, ingredient, created_by_user_id, created_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT (alias) DO UPDATE SET
+				ingredient = excluded.ingredient, created_by_user_id = excluded.created_by_user_id, created_at = excluded.created_at
+		`
+		_, err := execWrite(ctx, query, a.Alias, a.Ingredient, a.CreatedByUserID, createdAt)
+		return err
+	})
+}
+
+// DeleteIngredientAlias removes an alias added by a user. A built-in alias
+// for the same ingredient applies again.
+func DeleteIngredientAlias(ctx context.Context, alias string) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	return applyChange(ctx, "delete ingredient alias "+alias, func(ctx context.Context) error {
+		result, err := execWrite(ctx, `DELETE FROM ingredient_aliases WHERE alias = ?`, alias)
+		if err != nil {
+			return err
+		}
+		return requireRowAffected(result, errIngredientAliasNotFound)
+	})
+}
+
+// inventoryMatcher decides whether an ingredient is on hand
+type inventoryMatcher struct {
+	items   []string          // Item keys
+	aliases map[string]string // Ingredient key to the item key that covers it
+}
+
+// covers reports whether an ingredient, by key, is on hand. Any one of
+// alternatives such as "lime or lemon juice" will do.
+func (m inventoryMatcher) covers(key string) bool {
+	for _, alternative := range strings.Split(key, " or ") {
+		candidates := []string{alternative}
+		for alias, ingredient := range m.aliases {
+			if alternative == alias || strings.HasSuffix(alternative, " "+alias) {
+				candidates = append(candidates, ingredient)
+			}
+		}
+
+		// An item covers kinds of itself: "gin" covers "london dry gin"
+		for _, candidate := range candidates {
+			for _, item := range m.items {
+				if candidate == item || strings.HasSuffix(candidate, " "+item) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// optionalIngredient reports whether a recipe can do without an ingredient
+func optionalIngredient(ing Ingredient) bool {
+	text := strings.ToLower(ing.Name + " " + ing.Preparation)
+	for _, marker := range optionalMarkers {
+		if strings.Contains(text, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchInventory ranks recipes by how much of their ingredients are on hand,
+// best first. Only recipes with something on hand are included, or with at
+// most maxMissing ingredients missing if maxMissing isn't negative.
+// recipeType limits the recipes to one type, e.g. "drink".
+func MatchInventory(ctx context.Context, recipeType string, maxMissing int) ([]InventoryMatch, error) {
+	rlockDB(ctx)
+	defer dbMutex.RUnlock()
+
+	matcher := inventoryMatcher{aliases: make(map[string]string)}
+	rows, err := db.QueryContext(ctx, `SELECT item_key FROM inventory_items`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		matcher.items = append(matcher.items, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	aliases, err := getIngredientAliases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range aliases {
+		matcher.aliases[a.Alias] = a.Ingredient
+	}
+
+	query := `
+		SELECT r.id, r.title, COALESCE(r.recipe_type, ''), COALESCE(i.name, ''), COALESCE(i.preparation, ''), i.raw, i.parsed
+		FROM recipes r
+		JOIN recipe_ingredients i ON i.recipe_id = r.id
+		WHERE r.deleted_at IS NULL AND (? = '' OR r.recipe_type = ?)
+		ORDER BY r.id, i.position
+	`
+	rows, err = db.QueryContext(ctx, query, recipeType, recipeType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []InventoryMatch{}
+	var current *InventoryMatch
+	seen := make(map[string]bool)
+	finish := func() {
+		if current == nil || current.Need == 0 {
+			return
+		}
+		if maxMissing < 0 && current.Have == 0 || maxMissing >= 0 && len(current.Missing) > maxMissing {
+			return
+		}
+		current.Coverage = math.Round(float64(current.Have)/float64(current.Need)*100) / 100
+		matches = append(matches, *current)
+	}
+
+	for rows.Next() {
+		var m InventoryMatch
+		var ing Ingredient
+		if err := rows.Scan(&m.RecipeID, &m.Title, &m.RecipeType, &ing.Name, &ing.Preparation, &ing.Text, &ing.Parsed); err != nil {
+			return nil, err
+		}
+		if current == nil || current.RecipeID != m.RecipeID {
+			finish()
+			m.Missing = []string{}
+			current = &m
+			seen = make(map[string]bool)
+		}
+
+		if !ing.Parsed {
+			ing.Name = ing.Text
+		}
+		key := inventoryKey(ing.Name)
+		if key == "" || seen[key] || alwaysOnHand[key] || optionalIngredient(ing) {
+			continue
+		}
+		seen[key] = true
+
+		current.Need++
+		if matcher.covers(key) {
+			current.Have++
+		} else {
+			current.Missing = append(current.Missing, shoppingName(ing.Name))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	finish()
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.Coverage != b.Coverage {
+			return a.Coverage > b.Coverage
+		}
+		if len(a.Missing) != len(b.Missing) {
+			return len(a.Missing) < len(b.Missing)
+		}
+		return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+	})
+	return matches, nil
+}
+
+// inventoryHandler serves /inventory and the paths below it. The household
+// shares one inventory, and every endpoint needs authentication.
+//
+//	GET    /inventory                    list the items on hand
+//	POST   /inventory                    add an item
+//	DELETE /inventory/{id}               remove an item
+//	GET    /inventory/matches            recipes ranked by how much of them is on hand
+//	GET    /inventory/aliases            list ingredient aliases
+//	PUT    /inventory/aliases/{alias}    say which item covers an ingredient
+//	DELETE /inventory/aliases/{alias}    remove an alias
+func inventoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticateRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/inventory"), "/")
+	alias, isAlias := strings.CutPrefix(path, "aliases/")
+
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		items, err := GetInventory(r.Context())
+		if err != nil {
+			log.Printf("Error getting inventory: %v", err)
+			http.Error(w, "Failed to get inventory", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(items)
+
+	case path == "" && r.Method == http.MethodPost:
+		// {"name": "Campari"}, or written like an ingredient: {"name": "2 limes"}
+		var item InventoryItem
+		if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		item.Name = inventoryItemName(item.Name)
+		if inventoryKey(item.Name) == "" {
+			http.Error(w, "Name is required", http.StatusBadRequest)
+			return
+		}
+		item.AddedByUserID = &userID
+
+		created, err := AddInventoryItem(r.Context(), &item)
+		if err != nil {
+			log.Printf("Error adding inventory item: %v", err)
+			http.Error(w, "Failed to add inventory item", http.StatusInternalServerError)
+			return
+		}
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(item)
+
+	case path == "matches":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// ?type=drink limits the recipes, ?maxMissing=1 shows near misses
+		maxMissing := -1
+		if v := r.URL.Query().Get("maxMissing"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "maxMissing must be a whole number, 0 or more", http.StatusBadRequest)
+				return
+			}
+			maxMissing = n
+		}
+
+		matches, err := MatchInventory(r.Context(), r.URL.Query().Get("type"), maxMissing)
+		if err != nil {
+			log.Printf("Error matching inventory: %v", err)
+			http.Error(w, "Failed to match inventory", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(matches)
+
+	case path == "aliases":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		aliases, err := GetIngredientAliases(r.Context())
+		if err != nil {
+			log.Printf("Error getting ingredient aliases: %v", err)
+			http.Error(w, "Failed to get ingredient aliases", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(aliases)
+
+	case isAlias && alias != "":
+		alias = inventoryKey(alias)
+
+		switch r.Method {
+		case http.MethodPut:
+			// {"ingredient": "lime"}
+			var a IngredientAlias
+			if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			a.Alias = alias
+			a.Ingredient = inventoryKey(a.Ingredient)
+			if a.Ingredient == "" || a.Ingredient == a.Alias {
+				http.Error(w, "Ingredient is required, and must differ from the alias", http.StatusBadRequest)
+				return
+			}
+			a.CreatedByUserID = &userID
+
+			if err := SetIngredientAlias(r.Context(), &a); err != nil {
+				log.Printf("Error setting ingredient alias: %v", err)
+				http.Error(w, "Failed to set ingredient alias", http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(a)
+
+		case http.MethodDelete:
+			err := DeleteIngredientAlias(r.Context(), alias)
+			if errors.Is(err, errIngredientAliasNotFound) {
+				http.Error(w, "Ingredient alias not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("Error deleting ingredient alias: %v", err)
+				http.Error(w, "Failed to delete ingredient alias", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+
+	case path != "" && !strings.Contains(path, "/"):
+		if r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		itemID, err := strconv.ParseInt(path, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+
+		err = DeleteInventoryItem(r.Context(), itemID)
+		if errors.Is(err, errInventoryItemNotFound) {
+			http.Error(w, "Inventory item not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error deleting inventory item: %v", err)
+			http.Error(w, "Failed to delete inventory item", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case path == "":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+)
+
+// recipeLinkRegexp finds links to other recipes in markdown: [[Pizza dough]]
+// links by title (ignoring case), and [[<recipe id>]] by ID. Either can be
+// shown as other text with [[Pizza dough|the dough]].
+var recipeLinkRegexp = regexp.MustCompile(`\[\[([^\[\]|\n]+)(?:\|([^\[\]\n]*))?\]\]`)
+
+// RecipeLink is a link from a recipe's markdown to another recipe
+type RecipeLink struct {
+	Text     string  `json:"text"`     // Title or ID the link was written with
+	RecipeID *string `json:"recipeId"` // Nullable: no recipe has that title, or it is in the trash
+	Title    string  `json:"title"`    // The linked recipe's current title
+}
+
+// RecipeReference names another recipe, e.g. one that links to this one
+type RecipeReference struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// parsedLink is a link found in a recipe's markdown
+type parsedLink struct {
+	field string
+	text  string
+}
+
+// parseRecipeLinks returns the links in a recipe's ingredients and method, in
+// order, each once
+func parseRecipeLinks(ingredients, method string) []parsedLink {
+	var links []parsedLink
+	seen := make(map[string]bool)
+	for _, field := range []struct{ name, markdown string }{{"ingredients", ingredients}, {"method", method}} {
+		for _, match := range recipeLinkRegexp.FindAllStringSubmatch(field.markdown, -1) {
+			text := strings.TrimSpace(match[1])
+			key := strings.ToLower(text)
+			if text == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			links = append(links, parsedLink{field: field.name, text: text})
+		}
+	}
+	return links
+}
+
+// stripRecipeLinks replaces links with the text they show: [[Pizza dough]]
+// with Pizza dough, and [[Pizza dough|the dough]] with the dough
+func stripRecipeLinks(s string) string {
+	return recipeLinkRegexp.ReplaceAllStringFunc(s, func(link string) string {
+		match := recipeLinkRegexp.FindStringSubmatch(link)
+		if label := strings.TrimSpace(match[2]); label != "" {
+			return label
+		}
+		return strings.TrimSpace(match[1])
+	})
+}
+
+// setRecipeLinks replaces a recipe's links with those in its ingredients and
+// method, then links any recipes that were waiting for one with its title.
+// The caller must be inside applyChange.
+func setRecipeLinks(ctx context.Context, r Recipe) error {
+	if _, err := execWrite(ctx, `DELETE FROM recipe_links WHERE recipe_id = ?`, r.ID); err != nil {
+		return err
+	}
+
+	for i, link := range parseRecipeLinks(r.Ingredients, r.Method) {
+		// Prefer an ID, then the oldest recipe with the title
+		query := `
+			SELECT id FROM recipes
+			WHERE (id = ? OR title = ? COLLATE NOCASE) AND id != ? AND deleted_at IS NULL
+			ORDER BY id = ? DESC, created_at
+			LIMIT 1
+		`
+		var linkedID sql.NullString
+		err := dbConn(ctx).QueryRowContext(ctx, query, link.text, link.text, r.ID, link.text).Scan(&linkedID)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		query = `INSERT INTO recipe_links (recipe_id, position, field, link_text, linked_recipe_id) VALUES (?, ?, ?, ?, ?)`
+		if _, err := execWrite(ctx, query, r.ID, i, link.field, link.text, linkedID); err != nil {
+			return err
+		}
+	}
+
+	query := `
+		UPDATE recipe_links SET linked_recipe_id = ?
+		WHERE linked_recipe_id IS NULL AND link_text = ? COLLATE NOCASE AND recipe_id != ?
+	`
+	_, err := execWrite(ctx, query, r.ID, strings.TrimSpace(r.Title), r.ID)
+	return err
+}
+
+// getLinksByRecipe returns the links in each recipe's markdown in order, by
+// recipe ID
+func getLinksByRecipe(ctx context.Context, recipeIDs []string) (map[string][]RecipeLink, error) {
+	placeholders, args := inArgs(recipeIDs)
+	query := `
+		SELECT l.recipe_id, l.link_text, r.id, COALESCE(r.title, '')
+		FROM recipe_links l
+		LEFT JOIN recipes r ON r.id = l.linked_recipe_id AND r.deleted_at IS NULL
+		WHERE l.recipe_id IN (` + placeholders + `)
+		ORDER BY l.position
+	`
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := make(map[string][]RecipeLink)
+	for rows.Next() {
+		var recipeID string
+		var link RecipeLink
+		if err := rows.Scan(&recipeID, &link.Text, &link.RecipeID, &link.Title); err != nil {
+			return nil, err
+		}
+		links[recipeID] = append(links[recipeID], link)
+	}
+	return links, rows.Err()
+}
+
+// getBacklinksByRecipe returns the recipes that link to each recipe, by
+// title, keyed by the linked recipe's ID. Recipes in the trash are left out.
+func getBacklinksByRecipe(ctx context.Context, recipeIDs []string) (map[string][]RecipeReference, error) {
+	placeholders, args := inArgs(recipeIDs)
+	query := `
+		SELECT DISTINCT l.linked_recipe_id, r.id, r.title
+		FROM recipe_links l
+		JOIN recipes r ON r.id = l.recipe_id AND r.deleted_at IS NULL
+		WHERE l.linked_recipe_id IN (` + placeholders + `)
+		ORDER BY r.title COLLATE NOCASE
+	`
+	return queryRecipeReferencesByRecipe(ctx, query, args...)
+}
+
+// queryRecipeReferencesByRecipe runs a query that selects the recipe each
+// reference belongs to, then the referenced recipe's ID and title
+func queryRecipeReferencesByRecipe(ctx context.Context, query string, args ...interface{}) (map[string][]RecipeReference, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refs := make(map[string][]RecipeReference)
+	for rows.Next() {
+		var recipeID string
+		var ref RecipeReference
+		if err := rows.Scan(&recipeID, &ref.ID, &ref.Title); err != nil {
+			return nil, err
+		}
+		refs[recipeID] = append(refs[recipeID], ref)
+	}
+	return refs, rows.Err()
+}
+
+// orNoReferences returns refs, or an empty list if it is nil
+func orNoReferences(refs []RecipeReference) []RecipeReference {
+	if refs == nil {
+		return []RecipeReference{}
+	}
+	return refs
+}
+
+// GetRecipeBacklinks returns the recipes that link to a recipe
+func GetRecipeBacklinks(ctx context.Context, recipeID string) ([]RecipeReference, error) {
+	rlockDB(ctx)
+	defer dbMutex.RUnlock()
+
+	backlinks, err := getBacklinksByRecipe(ctx, []string{recipeID})
+	if err != nil {
+		return nil, err
+	}
+	return orNoReferences(backlinks[recipeID]), nil
+}
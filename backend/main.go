@@ -3,13 +3,22 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
+// shutdownTimeout bounds graceful shutdown, including the final database
+// upload. Cloud Run sends SIGKILL 10 seconds after SIGTERM.
+const shutdownTimeout = 8 * time.Second
+
 func main() {
 	ctx := context.Background()
 
@@ -18,21 +27,33 @@ func main() {
 		log.Fatalf("Failed to initialize Firebase: %v", err)
 	}
 
-	// Initialize SQLite database
-	bucketName := os.Getenv("DB_BUCKET_NAME")
-	if bucketName == "" {
-		log.Fatal("DB_BUCKET_NAME environment variable is required")
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
 	}
 
-	if err := InitDatabase(ctx, bucketName); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+	// Initialize blob storage (Cloud Storage, or a local directory for development)
+	store, err := newBlobStoreFromEnv(ctx, port)
+	if err != nil {
+		log.Fatalf("Failed to initialize blob storage: %v", err)
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// Initialize SQLite database
+	if err := InitDatabase(ctx, store); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
+	// Stop when Cloud Run sends SIGTERM or on Ctrl+C (SIGINT)
+	stopCtx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	// Upload local changes and pick up other instances' changes in the background
+	startSyncWorker(syncInterval())
+	startReloadWatcher(stopCtx, reloadInterval())
+	startBackupScheduler(stopCtx, backupInterval())
+	startTrashPurger(stopCtx, trashRetention())
+	startRecipeViewFlusher(stopCtx)
+
 	http.HandleFunc("/health", corsMiddleware(healthHandler))
 	// Public read, auth required for writes
 	http.HandleFunc("/recipes", corsMiddleware(recipesHandler))
@@ -40,6 +61,7 @@ func main() {
 	http.HandleFunc("/recipes/search", corsMiddleware(searchHandler))
 	// Filter metadata endpoints
 	http.HandleFunc("/tags", corsMiddleware(tagsHandler))
+	http.HandleFunc("/tags/", corsMiddleware(tagsHandler))
 	http.HandleFunc("/cuisines", corsMiddleware(cuisinesHandler))
 	// Image upload endpoint
 	http.HandleFunc("/recipes/images", corsMiddleware(imageUploadHandler))
@@ -47,19 +69,68 @@ func main() {
 	http.HandleFunc("/icons", corsMiddleware(iconsHandler))
 	// User profile endpoint
 	http.HandleFunc("/user/profile", corsMiddleware(userProfileHandler))
+	// Trash endpoints (editors and admins)
+	http.HandleFunc("/trash", corsMiddleware(trashHandler))
+	http.HandleFunc("/trash/", corsMiddleware(trashHandler))
+	// Collection endpoints (public read, auth required for changes)
+	http.HandleFunc("/collections", corsMiddleware(collectionsHandler))
+	http.HandleFunc("/collections/", corsMiddleware(collectionsHandler))
+	// Shopping list endpoints (signed-in users)
+	http.HandleFunc("/shopping-lists", corsMiddleware(shoppingListsHandler))
+	http.HandleFunc("/shopping-lists/", corsMiddleware(shoppingListsHandler))
+	// Meal planner endpoints (signed-in users)
+	http.HandleFunc("/meal-plans", corsMiddleware(mealPlansHandler))
+	http.HandleFunc("/meal-plans/", corsMiddleware(mealPlansHandler))
 	// Make log endpoints
 	http.HandleFunc("/make-logs/", corsMiddleware(makeLogsHandler))
 	http.HandleFunc("/make-log/", corsMiddleware(makeLogByIDHandler))
+	// Nutrition endpoints (public read, editors and admins override)
+	http.HandleFunc("/nutrition/", corsMiddleware(nutritionHandler))
+	// Inventory endpoints (signed-in users)
+	http.HandleFunc("/inventory", corsMiddleware(inventoryHandler))
+	http.HandleFunc("/inventory/", corsMiddleware(inventoryHandler))
+	// Serve uploaded images directly when they are stored on the local filesystem
+	if handler, ok := store.(http.Handler); ok {
+		http.Handle("/blobs/", http.StripPrefix("/blobs", handler))
+	}
+
+	server := &http.Server{Addr: ":" + port}
+
+	go func() {
+		log.Printf("Server starting on port %s", port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-stopCtx.Done()
+	log.Println("Shutting down server")
 
-	log.Printf("Server starting on port %s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatal(err)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// Finish in-flight requests first so no writes arrive after the final flush
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to shut down server cleanly: %v", err)
+	}
+
+	if err := flushRecipeViews(shutdownCtx); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
+	if err := flushSyncWorker(shutdownCtx); err != nil {
+		log.Printf("Failed to upload pending database changes on shutdown: %v", err)
+		return
 	}
+	log.Println("Pending database changes uploaded, exiting")
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "healthy",
+		"sync":   getSyncStatus(),
+	})
 }
 
 func recipesHandler(w http.ResponseWriter, r *http.Request) {
@@ -67,30 +138,74 @@ func recipesHandler(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet:
-		// Public read - no auth required
+		// Public read - auth optional, for favorites
 		// Check for filter parameters
 		searchQuery := r.URL.Query().Get("search")
 		cuisine := r.URL.Query().Get("cuisine")
 		recipeType := r.URL.Query().Get("type")
 		sortBy := r.URL.Query().Get("sort")
 		tagsParam := r.URL.Query()["tags"] // Get all tags parameters (can be multiple)
+		drink := DrinkFilter{
+			Glass:       r.URL.Query().Get("glass"),
+			Garnish:     r.URL.Query().Get("garnish"),
+			Preparation: r.URL.Query().Get("preparation"),
+			Ice:         r.URL.Query().Get("ice"),
+			BaseSpirit:  r.URL.Query().Get("baseSpirit"),
+		}
+
+		maxTotalTime := 0
+		if v := r.URL.Query().Get("maxTotalTime"); v != "" {
+			minutes, err := strconv.Atoi(v)
+			if err != nil || minutes <= 0 {
+				http.Error(w, "maxTotalTime must be a positive number of minutes", http.StatusBadRequest)
+				return
+			}
+			maxTotalTime = minutes
+		}
+
+		units, err := unitSystem(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Signed-in users see their favorites; ?favorites=true and
+		// sort=viewed_desc need a user
+		userID := authenticateOptional(r)
+		favoritesOnly := r.URL.Query().Get("favorites") == "true"
+		if (favoritesOnly || sortBy == "viewed_desc") && userID == "" {
+			http.Error(w, "Sign in to see favorites and recently viewed recipes", http.StatusUnauthorized)
+			return
+		}
+
+		if sortBy == "viewed_desc" {
+			// Save views still waiting to be flushed so they are listed
+			if err := flushRecipeViews(r.Context()); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		}
 
 		var recipes []Recipe
-		var err error
 
 		// If any filters are provided, use FilterRecipes
-		if searchQuery != "" || cuisine != "" || recipeType != "" || len(tagsParam) > 0 || sortBy != "" {
-			recipes, err = FilterRecipes(r.Context(), searchQuery, tagsParam, cuisine, recipeType, sortBy)
+		if searchQuery != "" || cuisine != "" || recipeType != "" || len(tagsParam) > 0 || maxTotalTime > 0 || drink != (DrinkFilter{}) || sortBy != "" || favoritesOnly {
+			recipes, err = FilterRecipes(r.Context(), searchQuery, tagsParam, cuisine, recipeType, maxTotalTime, drink, sortBy, userID, favoritesOnly)
 		} else {
 			// No filters, get all recipes
 			recipes, err = GetRecipes(r.Context())
 		}
 
+		if err == nil {
+			err = markFavorites(r.Context(), userID, recipes)
+		}
 		if err != nil {
 			log.Printf("Error getting recipes: %v", err)
 			http.Error(w, "Failed to get recipes", http.StatusInternalServerError)
 			return
 		}
+		for i := range recipes {
+			convertRecipeUnits(&recipes[i], units)
+		}
 		json.NewEncoder(w).Encode(recipes)
 
 	case http.MethodPost:
@@ -112,9 +227,7 @@ func recipesHandler(w http.ResponseWriter, r *http.Request) {
 		recipe.CreatedByUserID = &userID
 
 		// Try to get user's display name from SQLite
-		if user, err := GetUserByUID(r.Context(), userID); err == nil && user != nil && user.DisplayName != "" {
-			recipe.CreatedByName = &user.DisplayName
-		}
+		recipe.CreatedByName = userDisplayName(r.Context(), userID)
 
 		if err := CreateRecipe(r.Context(), &recipe); err != nil {
 			log.Printf("Error creating recipe: %v", err)
@@ -140,9 +253,43 @@ func recipeByIDHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Sub-resources: /recipes/{id}/revisions/...
+	if id, subPath, ok := strings.Cut(recipeID, "/"); ok {
+		if subPath == "revisions" || strings.HasPrefix(subPath, "revisions/") {
+			recipeRevisionsHandler(w, r, id, strings.TrimPrefix(strings.TrimPrefix(subPath, "revisions"), "/"))
+			return
+		}
+		if subPath == "favorite" {
+			recipeFavoriteHandler(w, r, id)
+			return
+		}
+		if subPath == "fork" || subPath == "lineage" || subPath == "diff" {
+			recipeVariantsHandler(w, r, id, subPath)
+			return
+		}
+		if subPath == "nutrition" {
+			recipeNutritionHandler(w, r, id)
+			return
+		}
+		if subPath == "spec" {
+			recipeSpecHandler(w, r, id)
+			return
+		}
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
-		// Public read - no auth required
+		// Public read - auth optional, for favorites and recently viewed
+		units, err := unitSystem(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		userID := authenticateOptional(r)
+
 		recipe, err := GetRecipeByID(r.Context(), recipeID)
 		if err != nil {
 			log.Printf("Error getting recipe: %v", err)
@@ -153,6 +300,31 @@ func recipeByIDHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Recipe not found", http.StatusNotFound)
 			return
 		}
+
+		if userID != "" {
+			// Record the view for the user's recently viewed list
+			RecordRecipeView(userID, recipeID)
+
+			recipes := []Recipe{*recipe}
+			if err := markFavorites(r.Context(), userID, recipes); err != nil {
+				log.Printf("Error getting favorites: %v", err)
+				http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+				return
+			}
+			recipe.IsFavorite = recipes[0].IsFavorite
+		}
+
+		// ?scale=2 or ?servings=6 multiplies the ingredient quantities
+		factor, err := scaleFactor(r.URL.Query(), recipe)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		scaleRecipe(recipe, factor)
+
+		// ?units=metric or ?units=imperial converts measurements and temperatures
+		convertRecipeUnits(recipe, units)
+
 		json.NewEncoder(w).Encode(recipe)
 
 	case http.MethodPut:
@@ -172,7 +344,12 @@ func recipeByIDHandler(w http.ResponseWriter, r *http.Request) {
 
 		recipe.ID = recipeID
 
-		if err := UpdateRecipe(r.Context(), &recipe); err != nil {
+		err = UpdateRecipe(r.Context(), &recipe, userID, userDisplayName(r.Context(), userID))
+		if errors.Is(err, errRecipeNotFound) {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
 			log.Printf("Error updating recipe: %v", err)
 			http.Error(w, "Failed to update recipe", http.StatusInternalServerError)
 			return
@@ -189,7 +366,31 @@ func recipeByIDHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		log.Printf("Deleting recipe - authenticated user: %s", userID)
 
-		if err := DeleteRecipe(r.Context(), recipeID); err != nil {
+		// Warn before breaking the links from recipes that use this one,
+		// unless ?force=true says to go ahead
+		if r.URL.Query().Get("force") != "true" {
+			usedIn, err := GetRecipeBacklinks(r.Context(), recipeID)
+			if err != nil {
+				log.Printf("Error getting recipe backlinks: %v", err)
+				http.Error(w, "Failed to delete recipe", http.StatusInternalServerError)
+				return
+			}
+			if len(usedIn) > 0 {
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"error":  "Recipe is used in other recipes; delete with ?force=true to break their links",
+					"usedIn": usedIn,
+				})
+				return
+			}
+		}
+
+		err = DeleteRecipe(r.Context(), recipeID, userID)
+		if errors.Is(err, errRecipeNotFound) {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
 			log.Printf("Error deleting recipe: %v", err)
 			http.Error(w, "Failed to delete recipe", http.StatusInternalServerError)
 			return
@@ -210,41 +411,35 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Public read - no auth required
+	// Public read - auth optional, for favorites
 	query := r.URL.Query().Get("q")
 	if query == "" {
 		http.Error(w, "Missing search query parameter 'q'", http.StatusBadRequest)
 		return
 	}
 
-	recipes, err := SearchRecipes(r.Context(), query)
+	units, err := unitSystem(r.URL.Query())
 	if err != nil {
-		log.Printf("Error searching recipes: %v", err)
-		http.Error(w, "Failed to search recipes", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	json.NewEncoder(w).Encode(recipes)
-}
+	userID := authenticateOptional(r)
 
-func tagsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
+	recipes, err := SearchRecipes(r.Context(), query)
+	if err == nil {
+		err = markFavorites(r.Context(), userID, recipes)
 	}
-
-	// Public read - no auth required
-	recipeType := r.URL.Query().Get("type")
-	tags, err := GetAllTags(r.Context(), recipeType)
 	if err != nil {
-		log.Printf("Error getting tags: %v", err)
-		http.Error(w, "Failed to get tags", http.StatusInternalServerError)
+		log.Printf("Error searching recipes: %v", err)
+		http.Error(w, "Failed to search recipes", http.StatusInternalServerError)
 		return
 	}
+	for i := range recipes {
+		convertRecipeUnits(&recipes[i], units)
+	}
 
-	json.NewEncoder(w).Encode(tags)
+	json.NewEncoder(w).Encode(recipes)
 }
 
 func cuisinesHandler(w http.ResponseWriter, r *http.Request) {
@@ -303,7 +498,7 @@ func imageUploadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	// Upload to GCS
+	// Upload to blob storage
 	imageURL, err := UploadImageToGCS(r.Context(), file, fileHeader, recipeID)
 	if err != nil {
 		log.Printf("Error uploading image: %v", err)
@@ -326,7 +521,7 @@ func imageUploadHandler(w http.ResponseWriter, r *http.Request) {
 
 	if err != nil {
 		log.Printf("Error saving image to database: %v", err)
-		// Try to delete from GCS
+		// Try to delete from blob storage
 		DeleteImageFromGCS(r.Context(), imageURL)
 		http.Error(w, "Failed to save image", http.StatusInternalServerError)
 		return
@@ -377,7 +572,7 @@ func iconsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		defer file.Close()
 
-		// Upload to GCS
+		// Upload to blob storage
 		filename, iconURL, err := UploadIconToGCS(r.Context(), file, fileHeader)
 		if err != nil {
 			log.Printf("Error uploading icon: %v", err)
@@ -392,7 +587,7 @@ func iconsHandler(w http.ResponseWriter, r *http.Request) {
 
 		if err != nil {
 			log.Printf("Error saving icon to database: %v", err)
-			// Try to delete from GCS
+			// Try to delete from blob storage
 			DeleteImageFromGCS(r.Context(), iconURL)
 			http.Error(w, "Failed to save icon", http.StatusInternalServerError)
 			return
@@ -448,6 +643,11 @@ func makeLogsHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if !validRating(makeLog.Rating) {
+			http.Error(w, "rating must be from 1 to 5", http.StatusBadRequest)
+			return
+		}
+
 		// Set recipe ID from URL and user ID from auth
 		makeLog.RecipeID = recipeID
 		makeLog.CreatedByUserID = &userID
@@ -498,10 +698,44 @@ func makeLogByIDHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if !validRating(makeLog.Rating) {
+			http.Error(w, "rating must be from 1 to 5", http.StatusBadRequest)
+			return
+		}
+
+		existing, err := GetMakeLogByID(r.Context(), logID)
+		if err != nil {
+			log.Printf("Error getting make log: %v", err)
+			http.Error(w, "Failed to update make log", http.StatusInternalServerError)
+			return
+		}
+		if existing == nil {
+			http.Error(w, "Make log not found", http.StatusNotFound)
+			return
+		}
+
+		// Ratings are per user: only the person who made it can rate it, and
+		// anyone else's edit keeps their rating
+		if existing.CreatedByUserID == nil || *existing.CreatedByUserID != userID {
+			if makeLog.Rating != nil && (existing.Rating == nil || *makeLog.Rating != *existing.Rating) {
+				http.Error(w, "Only the person who made it can rate a make log", http.StatusForbidden)
+				return
+			}
+			makeLog.Rating = existing.Rating
+		}
+
 		// Set log ID from URL
 		makeLog.ID = logID
+		makeLog.RecipeID = existing.RecipeID
+		makeLog.CreatedByUserID = existing.CreatedByUserID
+		makeLog.CreatedAt = existing.CreatedAt
 
-		if err := UpdateMakeLog(r.Context(), &makeLog); err != nil {
+		err = UpdateMakeLog(r.Context(), &makeLog)
+		if errors.Is(err, errMakeLogNotFound) {
+			http.Error(w, "Make log not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
 			log.Printf("Error updating make log: %v", err)
 			http.Error(w, "Failed to update make log", http.StatusInternalServerError)
 			return
@@ -518,7 +752,12 @@ func makeLogByIDHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		log.Printf("Deleting make log - authenticated user: %s", userID)
 
-		if err := DeleteMakeLog(r.Context(), logID); err != nil {
+		err = DeleteMakeLog(r.Context(), logID)
+		if errors.Is(err, errMakeLogNotFound) {
+			http.Error(w, "Make log not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
 			log.Printf("Error deleting make log: %v", err)
 			http.Error(w, "Failed to delete make log", http.StatusInternalServerError)
 			return
@@ -543,8 +782,12 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		}
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Expose-Headers", dbGenerationHeader)
 		w.Header().Set("Access-Control-Max-Age", "3600")
 
+		// Let clients see which database generation served the request
+		w, r = withServedGeneration(w, r)
+
 		// Handle preflight OPTIONS request
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
@@ -558,45 +801,19 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 // authenticateRequest validates Firebase ID token and returns user ID
 // Auto-creates user in SQLite on first login
 func authenticateRequest(r *http.Request) (string, error) {
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		return "", fmt.Errorf("missing authorization header")
-	}
-
-	parts := strings.Split(authHeader, " ")
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		return "", fmt.Errorf("invalid authorization header format")
-	}
-
-	idToken := parts[1]
-	token, err := firebaseAuth.VerifyIDToken(r.Context(), idToken)
+	token, err := verifyRequestToken(r)
 	if err != nil {
-		return "", fmt.Errorf("invalid or expired token: %w", err)
+		return "", err
 	}
 
 	userID := token.UID
 
-	// Check if user exists in SQLite, create if not (first login)
-	user, err := GetUserByUID(r.Context(), userID)
+	created, err := ensureUser(r.Context(), token)
 	if err != nil {
-		return "", fmt.Errorf("failed to check user existence: %w", err)
+		return "", err
 	}
 
-	if user == nil {
-		// User doesn't exist - create them (first login)
-		email := ""
-		if emailClaim, ok := token.Claims["email"]; ok {
-			if e, ok := emailClaim.(string); ok {
-				email = e
-			}
-		}
-
-		log.Printf("First login for user %s (%s), creating user record", userID, email)
-		_, err := CreateUser(r.Context(), userID, email, "viewer")
-		if err != nil {
-			return "", fmt.Errorf("failed to create user: %w", err)
-		}
-	} else {
+	if !created {
 		// User exists - update last login time
 		if err := UpdateUserLastLogin(r.Context(), userID); err != nil {
 			log.Printf("Warning: failed to update last login for user %s: %v", userID, err)
@@ -606,3 +823,47 @@ func authenticateRequest(r *http.Request) (string, error) {
 
 	return userID, nil
 }
+
+// authenticateOptional authenticates a request to a public endpoint. It
+// returns "" for anonymous requests, and also for a missing, invalid or
+// expired token so public reads are still served. Unlike authenticateRequest
+// it doesn't update the user's last login, so browsing doesn't write to the
+// database.
+func authenticateOptional(r *http.Request) string {
+	if r.Header.Get("Authorization") == "" {
+		return ""
+	}
+
+	token, err := verifyRequestToken(r)
+	if err != nil {
+		return ""
+	}
+
+	if _, err := ensureUser(r.Context(), token); err != nil {
+		log.Printf("Warning: serving request anonymously: %v", err)
+		return ""
+	}
+	return token.UID
+}
+
+// userDisplayName returns the user's display name from SQLite, or nil if they haven't set one
+func userDisplayName(ctx context.Context, userID string) *string {
+	if user, err := GetUserByUID(ctx, userID); err == nil && user != nil && user.DisplayName != "" {
+		return &user.DisplayName
+	}
+	return nil
+}
+
+// userHasRole reports whether the user has one of roles
+func userHasRole(ctx context.Context, userID string, roles ...string) bool {
+	user, err := GetUserByUID(ctx, userID)
+	if err != nil || user == nil {
+		return false
+	}
+	for _, role := range roles {
+		if user.Role == role {
+			return true
+		}
+	}
+	return false
+}
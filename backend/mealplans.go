@@ -0,0 +1,540 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mealDateLayout is how planned dates are written, the same as MakeLog.MadeAt
+const mealDateLayout = "2006-01-02"
+
+// mealSlots are the meals of the day a recipe can be planned for, in order
+var mealSlots = []string{"breakfast", "lunch", "dinner", "drinks"}
+
+// PlannedMeal is a recipe planned for a date and meal slot. The household
+// shares one plan, as it shares its recipes.
+type PlannedMeal struct {
+	ID              int64     `json:"id"`
+	Date            string    `json:"date"` // Date in YYYY-MM-DD format
+	Slot            string    `json:"slot"` // breakfast, lunch, dinner or drinks
+	RecipeID        string    `json:"recipeId"`
+	RecipeTitle     string    `json:"recipeTitle"`
+	Notes           string    `json:"notes"`
+	Cooked          bool      `json:"cooked"`
+	MakeLogID       *int64    `json:"makeLogId"` // Make log created when the meal was cooked
+	CreatedByUserID *string   `json:"createdByUserId"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+// MealPlanWeek is the plan for a week, Monday to Sunday
+type MealPlanWeek struct {
+	Start string        `json:"start"` // Monday, YYYY-MM-DD
+	End   string        `json:"end"`   // Sunday, YYYY-MM-DD
+	Meals []PlannedMeal `json:"meals"` // By date, then slot
+}
+
+var (
+	errPlannedMealNotFound = errors.New("planned meal not found")
+	errMealAlreadyCooked   = errors.New("meal has already been cooked")
+	errMealNotCooked       = errors.New("meal hasn't been cooked")
+)
+
+// validMealSlot reports whether slot is one of mealSlots
+func validMealSlot(slot string) bool {
+	for _, s := range mealSlots {
+		if s == slot {
+			return true
+		}
+	}
+	return false
+}
+
+// weekStart returns the Monday of the week date falls in
+func weekStart(date time.Time) time.Time {
+	daysSinceMonday := (int(date.Weekday()) + 6) % 7
+	return time.Date(date.Year(), date.Month(), date.Day()-daysSinceMonday, 0, 0, 0, 0, time.UTC)
+}
+
+// plannedMealColumns selects a planned meal for scanPlannedMeal. Meals of
+// recipes in the trash are left out, and come back if the recipe is restored.
+const plannedMealColumns = `
+	SELECT m.id, m.plan_date, m.slot, m.recipe_id, r.title, m.notes, m.make_log_id, m.created_by_user_id, m.created_at, m.updated_at
+	FROM meal_plans m
+	JOIN recipes r ON r.id = m.recipe_id AND r.deleted_at IS NULL
+`
+
+func scanPlannedMeal(row interface{ Scan(...interface{}) error }) (*PlannedMeal, error) {
+	var meal PlannedMeal
+	var makeLogID sql.NullInt64
+	var createdByUserID sql.NullString
+
+	err := row.Scan(&meal.ID, &meal.Date, &meal.Slot, &meal.RecipeID, &meal.RecipeTitle, &meal.Notes,
+		&makeLogID, &createdByUserID, &meal.CreatedAt, &meal.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if makeLogID.Valid {
+		meal.Cooked = true
+		meal.MakeLogID = &makeLogID.Int64
+	}
+	if createdByUserID.Valid {
+		uid := createdByUserID.String
+		meal.CreatedByUserID = &uid
+	}
+	return &meal, nil
+}
+
+// GetMealPlanWeek returns the meals planned for the week starting on start
+func GetMealPlanWeek(ctx context.Context, start time.Time) (*MealPlanWeek, error) {
+	rlockDB(ctx)
+	defer dbMutex.RUnlock()
+
+	week := &MealPlanWeek{
+		Start: start.Format(mealDateLayout),
+		End:   start.AddDate(0, 0, 6).Format(mealDateLayout),
+		Meals: []PlannedMeal{},
+	}
+
+	query := plannedMealColumns + `
+		WHERE m.plan_date BETWEEN ? AND ?
+		ORDER BY m.plan_date,
+			CASE m.slot WHEN 'breakfast' THEN 0 WHEN 'lunch' THEN 1 WHEN 'dinner' THEN 2 ELSE 3 END,
+			m.id
+	`
+	rows, err := db.QueryContext(ctx, query, week.Start, week.End)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		meal, err := scanPlannedMeal(rows)
+		if err != nil {
+			return nil, err
+		}
+		week.Meals = append(week.Meals, *meal)
+	}
+	return week, rows.Err()
+}
+
+// GetPlannedMeal returns a planned meal, or nil if it doesn't exist
+func GetPlannedMeal(ctx context.Context, mealID int64) (*PlannedMeal, error) {
+	rlockDB(ctx)
+	defer dbMutex.RUnlock()
+
+	meal, err := scanPlannedMeal(db.QueryRowContext(ctx, plannedMealColumns+` WHERE m.id = ?`, mealID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return meal, err
+}
+
+// CreatePlannedMeal adds a recipe to the plan. The meal is updated with its ID.
+func CreatePlannedMeal(ctx context.Context, meal *PlannedMeal) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	meal.CreatedAt = time.Now()
+	meal.UpdatedAt = meal.CreatedAt
+
+	m := *meal
+	m.ID = newRowID()
+	err := applyChange(ctx, "plan recipe "+m.RecipeID+" for "+m.Date, func(ctx context.Context) error {
+		query := `
+			INSERT INTO meal_plans (id, plan_date, slot, recipe_id, notes, created_by_user_id, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`
+		_, err := execWrite(ctx, query, m.ID, m.Date, m.Slot, m.RecipeID, m.Notes, m.CreatedByUserID, m.CreatedAt, m.UpdatedAt)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	meal.ID = m.ID
+
+	return nil
+}
+
+// UpdatePlannedMeal moves a planned meal or changes its recipe or notes. If
+// the meal has been cooked, its make log is moved along with it.
+func UpdatePlannedMeal(ctx context.Context, meal *PlannedMeal) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	updatedAt := time.Now()
+	m := *meal
+	return applyChange(ctx, fmt.Sprintf("update planned meal %d", m.ID), func(ctx context.Context) error {
+		query := `UPDATE meal_plans SET plan_date = ?, slot = ?, recipe_id = ?, notes = ?, updated_at = ? WHERE id = ?`
+		result, err := execWrite(ctx, query, m.Date, m.Slot, m.RecipeID, m.Notes, updatedAt, m.ID)
+		if err != nil {
+			return err
+		}
+		if err := requireRowAffected(result, errPlannedMealNotFound); err != nil {
+			return err
+		}
+
+		query = `UPDATE make_logs SET recipe_id = ?, made_at = ? WHERE id = (SELECT make_log_id FROM meal_plans WHERE id = ?)`
+		_, err = execWrite(ctx, query, m.RecipeID, m.Date, m.ID)
+		return err
+	})
+}
+
+// DeletePlannedMeal removes a meal from the plan. If it was cooked, the make
+// log stays: the recipe was still made.
+func DeletePlannedMeal(ctx context.Context, mealID int64) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	return applyChange(ctx, fmt.Sprintf("delete planned meal %d", mealID), func(ctx context.Context) error {
+		result, err := execWrite(ctx, `DELETE FROM meal_plans WHERE id = ?`, mealID)
+		if err != nil {
+			return err
+		}
+		return requireRowAffected(result, errPlannedMealNotFound)
+	})
+}
+
+// MarkMealCooked records that a planned meal was made as a make log, so that
+// it shows in the recipe's make history
+func MarkMealCooked(ctx context.Context, meal *PlannedMeal, notes string, rating *int, userID string) error {
+	if meal.Cooked {
+		return errMealAlreadyCooked
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	makeLog := MakeLog{
+		ID:              newRowID(),
+		RecipeID:        meal.RecipeID,
+		MadeAt:          meal.Date,
+		Notes:           notes,
+		Rating:          rating,
+		CreatedByUserID: &userID,
+		CreatedAt:       time.Now(),
+	}
+	return applyChange(ctx, fmt.Sprintf("mark planned meal %d cooked", meal.ID), func(ctx context.Context) error {
+		// Someone else may have marked it cooked (or deleted it) first
+		query := `UPDATE meal_plans SET make_log_id = ?, updated_at = ? WHERE id = ? AND make_log_id IS NULL`
+		result, err := execWrite(ctx, query, makeLog.ID, makeLog.CreatedAt, meal.ID)
+		if err != nil {
+			return err
+		}
+		if err := requireRowAffected(result, errMealAlreadyCooked); err != nil {
+			return err
+		}
+		return insertMakeLog(ctx, makeLog)
+	})
+}
+
+// UnmarkMealCooked deletes the make log created when a meal was cooked.
+// DeleteMakeLog clears it from the plan.
+func UnmarkMealCooked(ctx context.Context, meal *PlannedMeal) error {
+	if !meal.Cooked {
+		return errMealNotCooked
+	}
+	return DeleteMakeLog(ctx, *meal.MakeLogID)
+}
+
+// CopyMealPlanWeek copies the meals planned in the week starting on from to
+// the same days of the week starting on to. Meals already planned there are
+// not duplicated, recipes in the trash are skipped, and copies aren't cooked.
+func CopyMealPlanWeek(ctx context.Context, from, to time.Time, userID string) (int64, error) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	shift := fmt.Sprintf("%+d days", int(to.Sub(from).Hours()/24))
+	fromStart, fromEnd := from.Format(mealDateLayout), from.AddDate(0, 0, 6).Format(mealDateLayout)
+	createdAt := time.Now()
+
+	// Each copy gets its ID up front, so the meals to copy are the ones
+	// planned now
+	rows, err := db.QueryContext(ctx, `SELECT id FROM meal_plans WHERE plan_date BETWEEN ? AND ? ORDER BY plan_date, id`, fromStart, fromEnd)
+	if err != nil {
+		return 0, err
+	}
+	var mealIDs, copyIDs []int64
+	for rows.Next() {
+		var mealID int64
+		if err := rows.Scan(&mealID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		mealIDs = append(mealIDs, mealID)
+		copyIDs = append(copyIDs, newRowID())
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var copied int64
+	err = applyChange(ctx, fmt.Sprintf("copy meal plan from week of %s to week of %s", fromStart, to.Format(mealDateLayout)), func(ctx context.Context) error {
+		copied = 0
+		for i, mealID := range mealIDs {
+			query := `
+				INSERT INTO meal_plans (id, plan_date, slot, recipe_id, notes, created_by_user_id, created_at, updated_at)
+				SELECT ?, date(m.plan_date, ?), m.slot, m.recipe_id, m.notes, ?, ?, ?
+				FROM meal_plans m
+				JOIN recipes r ON r.id = m.recipe_id AND r.deleted_at IS NULL
+				WHERE m.id = ?
+				  AND NOT EXISTS (
+					SELECT 1 FROM meal_plans existing
+					WHERE existing.plan_date = date(m.plan_date, ?)
+					  AND existing.slot = m.slot
+					  AND existing.recipe_id = m.recipe_id
+				  )
+			`
+			result, err := execWrite(ctx, query, copyIDs[i], shift, userID, createdAt, createdAt, mealID, shift)
+			if err != nil {
+				return err
+			}
+
+			n, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			copied += n
+		}
+		return nil
+	})
+	return copied, err
+}
+
+// mealPlansHandler serves the meal planner. Any signed-in user can read and
+// change the household's plan.
+//
+//	GET    /meal-plans?week=YYYY-MM-DD     the week containing the date (default this week)
+//	POST   /meal-plans                     plan a recipe
+//	POST   /meal-plans/copy                copy a week's plan to another week
+//	GET    /meal-plans/{id}                get a planned meal
+//	PUT    /meal-plans/{id}                move a meal or change its recipe or notes
+//	DELETE /meal-plans/{id}                remove a meal from the plan
+//	POST   /meal-plans/{id}/cooked         mark a meal cooked, creating a make log
+//	DELETE /meal-plans/{id}/cooked         unmark it, deleting the make log
+func mealPlansHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticateRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/meal-plans"), "/")
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		date := time.Now()
+		if v := r.URL.Query().Get("week"); v != "" {
+			if date, err = time.Parse(mealDateLayout, v); err != nil {
+				http.Error(w, "week must be a date in YYYY-MM-DD format", http.StatusBadRequest)
+				return
+			}
+		}
+		writeMealPlanWeek(w, r, weekStart(date))
+		return
+
+	case path == "" && r.Method == http.MethodPost:
+		var meal PlannedMeal
+		if err := json.NewDecoder(r.Body).Decode(&meal); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !validatePlannedMeal(w, r, &meal) {
+			return
+		}
+		meal.CreatedByUserID = &userID
+		log.Printf("Planning recipe %s for %s %s - authenticated user: %s", meal.RecipeID, meal.Date, meal.Slot, userID)
+
+		if err := CreatePlannedMeal(r.Context(), &meal); err != nil {
+			log.Printf("Error creating planned meal: %v", err)
+			http.Error(w, "Failed to plan meal", http.StatusInternalServerError)
+			return
+		}
+		writePlannedMeal(w, r, meal.ID, http.StatusCreated)
+		return
+
+	case path == "copy" && r.Method == http.MethodPost:
+		var body struct {
+			FromWeek string `json:"fromWeek"`
+			ToWeek   string `json:"toWeek"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		from, fromErr := time.Parse(mealDateLayout, body.FromWeek)
+		to, toErr := time.Parse(mealDateLayout, body.ToWeek)
+		if fromErr != nil || toErr != nil {
+			http.Error(w, "fromWeek and toWeek must be dates in YYYY-MM-DD format", http.StatusBadRequest)
+			return
+		}
+		from, to = weekStart(from), weekStart(to)
+		if from.Equal(to) {
+			http.Error(w, "fromWeek and toWeek must be different weeks", http.StatusBadRequest)
+			return
+		}
+
+		copied, err := CopyMealPlanWeek(r.Context(), from, to, userID)
+		if err != nil {
+			log.Printf("Error copying meal plan: %v", err)
+			http.Error(w, "Failed to copy meal plan", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Copied %d meals from week of %s to week of %s - authenticated user: %s", copied, body.FromWeek, body.ToWeek, userID)
+		writeMealPlanWeek(w, r, to)
+		return
+
+	case path == "" || path == "copy":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr, subPath, _ := strings.Cut(path, "/")
+	mealID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid meal ID", http.StatusBadRequest)
+		return
+	}
+
+	meal, err := GetPlannedMeal(r.Context(), mealID)
+	if err != nil {
+		log.Printf("Error getting planned meal: %v", err)
+		http.Error(w, "Failed to get planned meal", http.StatusInternalServerError)
+		return
+	}
+	if meal == nil {
+		http.Error(w, "Planned meal not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case subPath == "" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(meal)
+		return
+
+	case subPath == "" && r.Method == http.MethodPut:
+		var update PlannedMeal
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !validatePlannedMeal(w, r, &update) {
+			return
+		}
+		update.ID = mealID
+		log.Printf("Updating planned meal %d - authenticated user: %s", mealID, userID)
+		err = UpdatePlannedMeal(r.Context(), &update)
+
+	case subPath == "" && r.Method == http.MethodDelete:
+		log.Printf("Deleting planned meal %d - authenticated user: %s", mealID, userID)
+		if err := DeletePlannedMeal(r.Context(), mealID); err != nil && !errors.Is(err, errPlannedMealNotFound) {
+			log.Printf("Error deleting planned meal: %v", err)
+			http.Error(w, "Failed to delete planned meal", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+
+	case subPath == "cooked" && r.Method == http.MethodPost:
+		var body struct {
+			Notes  string `json:"notes"`
+			Rating *int   `json:"rating"`
+		}
+		// The body is optional
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !validRating(body.Rating) {
+			http.Error(w, "rating must be from 1 to 5", http.StatusBadRequest)
+			return
+		}
+		log.Printf("Marking planned meal %d cooked - authenticated user: %s", mealID, userID)
+		err = MarkMealCooked(r.Context(), meal, body.Notes, body.Rating, userID)
+
+	case subPath == "cooked" && r.Method == http.MethodDelete:
+		log.Printf("Unmarking planned meal %d cooked - authenticated user: %s", mealID, userID)
+		err = UnmarkMealCooked(r.Context(), meal)
+
+	case subPath == "" || subPath == "cooked":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case errors.Is(err, errPlannedMealNotFound):
+		http.Error(w, "Planned meal not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errMealAlreadyCooked), errors.Is(err, errMealNotCooked):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Error updating planned meal: %v", err)
+		http.Error(w, "Failed to update planned meal", http.StatusInternalServerError)
+		return
+	}
+	writePlannedMeal(w, r, mealID, http.StatusOK)
+}
+
+// validatePlannedMeal checks the date, slot and recipe of a meal from a
+// request body, writing a 400 response and returning false if one is wrong
+func validatePlannedMeal(w http.ResponseWriter, r *http.Request, meal *PlannedMeal) bool {
+	if _, err := time.Parse(mealDateLayout, meal.Date); err != nil {
+		http.Error(w, "date must be in YYYY-MM-DD format", http.StatusBadRequest)
+		return false
+	}
+	meal.Slot = strings.ToLower(strings.TrimSpace(meal.Slot))
+	if !validMealSlot(meal.Slot) {
+		http.Error(w, "slot must be one of "+strings.Join(mealSlots, ", "), http.StatusBadRequest)
+		return false
+	}
+
+	recipe, err := GetRecipeByID(r.Context(), meal.RecipeID)
+	if err != nil {
+		log.Printf("Error getting recipe: %v", err)
+		http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+		return false
+	}
+	if recipe == nil {
+		http.Error(w, "recipe not found: "+meal.RecipeID, http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeMealPlanWeek(w http.ResponseWriter, r *http.Request, start time.Time) {
+	week, err := GetMealPlanWeek(r.Context(), start)
+	if err != nil {
+		log.Printf("Error getting meal plan: %v", err)
+		http.Error(w, "Failed to get meal plan", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(week)
+}
+
+func writePlannedMeal(w http.ResponseWriter, r *http.Request, mealID int64, status int) {
+	meal, err := GetPlannedMeal(r.Context(), mealID)
+	if err != nil || meal == nil {
+		log.Printf("Error getting planned meal %d: %v", mealID, err)
+		http.Error(w, "Failed to get planned meal", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(meal)
+}
@@ -0,0 +1,298 @@
+// Package migrations applies the numbered SQL files in sql/ to a SQLite
+// database. Each migration runs once, in its own transaction, and is recorded
+// in the schema_migrations table. A new database is built by running all of
+// them in order.
+//
+// To change the schema, add a file named NNNN_description.sql with the next
+// number. Never edit a migration that has already been deployed.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// Migration is one numbered schema change
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// String returns the migration's file name without the extension, e.g. "0003_users"
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// Status describes whether a migration has been applied to a database
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt *time.Time // nil if not applied, or if recorded by the legacy baseline
+}
+
+// BeforeApplyFunc is called with the pending migrations before any of them is
+// applied to a database that already holds data. Returning an error aborts
+// the migration.
+type BeforeApplyFunc func(ctx context.Context, pending []Migration) error
+
+const createSchemaMigrations = `
+	CREATE TABLE schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME
+	)
+`
+
+// All returns every migration in version order
+func All() ([]Migration, error) {
+	entries, err := files.ReadDir("sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		base := strings.TrimSuffix(entry.Name(), ".sql")
+		number, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(number)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration file name %q, expected NNNN_description.sql", entry.Name())
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, entry.Name(), version)
+		}
+		seen[version] = entry.Name()
+
+		data, err := files.ReadFile(path.Join("sql", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// List reports every migration and whether it has been applied to db. It does
+// not modify the database.
+func List(ctx context.Context, db *sql.DB) ([]Status, error) {
+	all, err := All()
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := inspect(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(all))
+	for i, m := range all {
+		appliedAt, ok := state.applied[m.Version]
+		statuses[i] = Status{Migration: m, Applied: ok, AppliedAt: appliedAt}
+	}
+	return statuses, nil
+}
+
+// Pending returns the migrations not yet applied to db, in version order
+func Pending(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	statuses, err := List(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, s := range statuses {
+		if !s.Applied {
+			pending = append(pending, s.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// Apply runs every pending migration against db and returns the ones applied.
+// beforeApply, if non-nil, is called first unless db is a new, empty database.
+func Apply(ctx context.Context, db *sql.DB, beforeApply BeforeApplyFunc) ([]Migration, error) {
+	state, err := inspect(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record what a database from before schema_migrations already has, so
+	// those steps are not run again
+	if !state.recorded {
+		if err := recordBaseline(ctx, db, state.applied); err != nil {
+			return nil, err
+		}
+	}
+
+	pending, err := Pending(ctx, db)
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+
+	if beforeApply != nil && !state.fresh {
+		if err := beforeApply(ctx, pending); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, m := range pending {
+		log.Printf("Running migration %s", m)
+		if err := applyOne(ctx, db, m); err != nil {
+			return pending[:i], fmt.Errorf("migration %s failed: %w", m, err)
+		}
+	}
+
+	log.Printf("Applied %d migrations", len(pending))
+	return pending, nil
+}
+
+// applyOne runs a migration and records it in a single transaction
+func applyOne(ctx context.Context, db *sql.DB, m Migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+		m.Version, m.Name, time.Now().UTC(),
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// schemaState is what inspect learned about a database
+type schemaState struct {
+	applied  map[int]*time.Time // Applied versions and when, if known
+	recorded bool               // schema_migrations exists
+	fresh    bool               // A new database with no schema yet
+}
+
+// inspect works out which migrations have been applied to db. Databases
+// created before schema_migrations existed are recognised by probing for the
+// changes each early migration made.
+func inspect(ctx context.Context, db *sql.DB) (*schemaState, error) {
+	recorded, err := tableExists(ctx, db, "schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	if recorded {
+		rows, err := db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+		}
+		defer rows.Close()
+
+		state := &schemaState{applied: make(map[int]*time.Time), recorded: true}
+		for rows.Next() {
+			var version int
+			var appliedAt sql.NullTime
+			if err := rows.Scan(&version, &appliedAt); err != nil {
+				return nil, err
+			}
+			state.applied[version] = nil
+			if appliedAt.Valid {
+				state.applied[version] = &appliedAt.Time
+			}
+		}
+		return state, rows.Err()
+	}
+
+	hasRecipes, err := tableExists(ctx, db, "recipes")
+	if err != nil {
+		return nil, err
+	}
+	if !hasRecipes {
+		return &schemaState{applied: map[int]*time.Time{}, fresh: true}, nil
+	}
+
+	// Legacy database: 0001 is the schema it was created with, and the next
+	// three migrations were previously applied by hand-written checks
+	state := &schemaState{applied: map[int]*time.Time{1: nil}}
+
+	var creatorColumns int
+	err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info('recipes') WHERE name='created_by_user_id'").Scan(&creatorColumns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for column existence: %w", err)
+	}
+	if creatorColumns > 0 {
+		state.applied[2] = nil
+	}
+
+	for version, table := range map[int]string{3: "users", 4: "make_logs"} {
+		exists, err := tableExists(ctx, db, table)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			state.applied[version] = nil
+		}
+	}
+
+	return state, nil
+}
+
+// recordBaseline creates schema_migrations and records the versions a legacy
+// database already has
+func recordBaseline(ctx context.Context, db *sql.DB, applied map[int]*time.Time) error {
+	all, err := All()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, createSchemaMigrations); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	for _, m := range all {
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		// applied_at stays NULL: we only know the change was made, not when
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO schema_migrations (version, name) VALUES (?, ?)",
+			m.Version, m.Name,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func tableExists(ctx context.Context, db *sql.DB, name string) (bool, error) {
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?", name).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for %s table existence: %w", name, err)
+	}
+	return count > 0, nil
+}
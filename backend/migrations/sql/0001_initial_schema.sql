@@ -0,0 +1,81 @@
+-- Icons table (shared collection of recipe icons)
+CREATE TABLE icons (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	filename TEXT NOT NULL,
+	icon_url TEXT NOT NULL,
+	uploaded_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE recipes (
+	id TEXT PRIMARY KEY,
+	title TEXT NOT NULL,
+	description TEXT,
+	recipe_type TEXT,
+	cuisine TEXT,
+	ingredients TEXT,
+	method TEXT,
+	notes TEXT,
+	sources TEXT,
+	icon_id INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (icon_id) REFERENCES icons(id)
+);
+
+CREATE INDEX idx_recipes_type ON recipes(recipe_type);
+CREATE INDEX idx_recipes_cuisine ON recipes(cuisine);
+CREATE INDEX idx_recipes_icon ON recipes(icon_id);
+
+-- Tags table
+CREATE TABLE tags (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT UNIQUE NOT NULL
+);
+
+-- Many-to-many relationship between recipes and tags
+CREATE TABLE recipe_tags (
+	recipe_id TEXT NOT NULL,
+	tag_id INTEGER NOT NULL,
+	PRIMARY KEY (recipe_id, tag_id),
+	FOREIGN KEY (recipe_id) REFERENCES recipes(id) ON DELETE CASCADE,
+	FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_recipe_tags_recipe ON recipe_tags(recipe_id);
+CREATE INDEX idx_recipe_tags_tag ON recipe_tags(tag_id);
+
+-- Recipe images table
+CREATE TABLE recipe_images (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	recipe_id TEXT NOT NULL,
+	image_url TEXT NOT NULL,
+	display_order INTEGER DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (recipe_id) REFERENCES recipes(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_recipe_images_recipe ON recipe_images(recipe_id);
+
+-- Full-text search table
+-- Note: We include recipe_id as an unindexed column to enable joining back to recipes table
+CREATE VIRTUAL TABLE recipes_fts USING fts5(
+	recipe_id UNINDEXED,
+	title, description, cuisine, ingredients, method, notes, sources
+);
+
+-- Triggers to keep FTS in sync
+CREATE TRIGGER recipes_fts_insert AFTER INSERT ON recipes BEGIN
+	INSERT INTO recipes_fts(recipe_id, title, description, cuisine, ingredients, method, notes, sources)
+	VALUES (new.id, new.title, new.description, new.cuisine, new.ingredients, new.method, new.notes, new.sources);
+END;
+
+CREATE TRIGGER recipes_fts_update AFTER UPDATE ON recipes BEGIN
+	UPDATE recipes_fts
+	SET title=new.title, description=new.description, cuisine=new.cuisine,
+		ingredients=new.ingredients, method=new.method, notes=new.notes, sources=new.sources
+	WHERE recipe_id=new.id;
+END;
+
+CREATE TRIGGER recipes_fts_delete AFTER DELETE ON recipes BEGIN
+	DELETE FROM recipes_fts WHERE recipe_id=old.id;
+END;
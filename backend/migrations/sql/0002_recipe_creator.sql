@@ -0,0 +1,3 @@
+-- Track who created each recipe
+ALTER TABLE recipes ADD COLUMN created_by_user_id TEXT;
+ALTER TABLE recipes ADD COLUMN created_by_name TEXT;
@@ -0,0 +1,11 @@
+-- Users table (stores user profiles from Firebase Auth)
+CREATE TABLE users (
+	firebase_uid TEXT PRIMARY KEY,
+	email TEXT NOT NULL,
+	display_name TEXT,
+	role TEXT DEFAULT 'viewer' CHECK(role IN ('viewer', 'editor', 'admin')),
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	last_login_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_users_email ON users(email);
@@ -0,0 +1,14 @@
+-- Make logs table (tracks when recipes were made)
+CREATE TABLE make_logs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	recipe_id TEXT NOT NULL,
+	made_at DATE NOT NULL,
+	notes TEXT,
+	created_by_user_id TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (recipe_id) REFERENCES recipes(id) ON DELETE CASCADE,
+	FOREIGN KEY (created_by_user_id) REFERENCES users(firebase_uid)
+);
+
+CREATE INDEX idx_make_logs_recipe ON make_logs(recipe_id);
+CREATE INDEX idx_make_logs_made_at ON make_logs(made_at);
@@ -0,0 +1,22 @@
+-- Recipe revisions (an immutable copy of a recipe's content after each save)
+CREATE TABLE recipe_revisions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	recipe_id TEXT NOT NULL,
+	revision INTEGER NOT NULL,
+	title TEXT NOT NULL,
+	description TEXT,
+	recipe_type TEXT,
+	cuisine TEXT,
+	ingredients TEXT,
+	method TEXT,
+	notes TEXT,
+	sources TEXT,
+	icon_id INTEGER,
+	tags TEXT NOT NULL DEFAULT '[]', -- JSON array of tag names
+	restored_from INTEGER,           -- Revision this one restored, if any
+	created_by_user_id TEXT,
+	created_by_name TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (recipe_id, revision),
+	FOREIGN KEY (recipe_id) REFERENCES recipes(id) ON DELETE CASCADE
+);
@@ -0,0 +1,5 @@
+-- Soft delete: trashed recipes keep their row until the purge job removes them
+ALTER TABLE recipes ADD COLUMN deleted_at DATETIME;
+ALTER TABLE recipes ADD COLUMN deleted_by_user_id TEXT;
+
+CREATE INDEX idx_recipes_deleted_at ON recipes(deleted_at);
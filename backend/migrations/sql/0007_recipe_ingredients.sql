@@ -0,0 +1,17 @@
+-- Structured ingredients parsed from each recipe's ingredients markdown
+CREATE TABLE recipe_ingredients (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	recipe_id TEXT NOT NULL,
+	position INTEGER NOT NULL,         -- Order within the recipe, from 0
+	group_name TEXT,                   -- "### heading" the line appears under
+	quantity REAL,                     -- NULL if the line has no quantity
+	quantity_max REAL,                 -- Upper bound of a range such as "1-2"
+	unit TEXT,                         -- Canonical unit, e.g. "tbsp", "g", "clove"
+	name TEXT,
+	preparation TEXT,                  -- e.g. "finely chopped"
+	raw TEXT NOT NULL,                 -- The line as written, without its list marker
+	parsed INTEGER NOT NULL DEFAULT 1, -- 0 if the line was kept verbatim
+	FOREIGN KEY (recipe_id) REFERENCES recipes(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_recipe_ingredients_recipe ON recipe_ingredients(recipe_id);
@@ -0,0 +1,3 @@
+-- Number of servings a recipe's ingredient quantities make (used to scale it)
+ALTER TABLE recipes ADD COLUMN servings INTEGER;
+ALTER TABLE recipe_revisions ADD COLUMN servings INTEGER;
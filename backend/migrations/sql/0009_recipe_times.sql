@@ -0,0 +1,11 @@
+-- Prep, cook and total time in minutes, and what a recipe makes ("1 loaf")
+ALTER TABLE recipes ADD COLUMN yield TEXT NOT NULL DEFAULT '';
+ALTER TABLE recipes ADD COLUMN prep_time_minutes INTEGER;
+ALTER TABLE recipes ADD COLUMN cook_time_minutes INTEGER;
+ALTER TABLE recipes ADD COLUMN total_time_minutes INTEGER;
+CREATE INDEX idx_recipes_total_time ON recipes(total_time_minutes);
+
+ALTER TABLE recipe_revisions ADD COLUMN yield TEXT NOT NULL DEFAULT '';
+ALTER TABLE recipe_revisions ADD COLUMN prep_time_minutes INTEGER;
+ALTER TABLE recipe_revisions ADD COLUMN cook_time_minutes INTEGER;
+ALTER TABLE recipe_revisions ADD COLUMN total_time_minutes INTEGER;
@@ -0,0 +1,49 @@
+-- Shopping lists (merged ingredients of a set of recipes, saved per user)
+CREATE TABLE shopping_lists (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	created_by_user_id TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (created_by_user_id) REFERENCES users(firebase_uid)
+);
+
+-- Recipes a list was made from
+CREATE TABLE shopping_list_recipes (
+	list_id TEXT NOT NULL,
+	recipe_id TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	title TEXT NOT NULL, -- Recipe title when the list was made
+	scale REAL NOT NULL DEFAULT 1,
+	PRIMARY KEY (list_id, recipe_id),
+	FOREIGN KEY (list_id) REFERENCES shopping_lists(id) ON DELETE CASCADE,
+	FOREIGN KEY (recipe_id) REFERENCES recipes(id) ON DELETE CASCADE
+);
+
+CREATE TABLE shopping_list_items (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	list_id TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	section TEXT NOT NULL, -- Store section, e.g. "Produce"
+	name TEXT NOT NULL,
+	quantity REAL,
+	unit TEXT NOT NULL DEFAULT '',
+	text TEXT NOT NULL,    -- What to buy as shown, e.g. "375 g flour"
+	checked INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY (list_id) REFERENCES shopping_lists(id) ON DELETE CASCADE
+);
+
+-- Users a list is shared with, besides its creator
+CREATE TABLE shopping_list_shares (
+	list_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (list_id, user_id),
+	FOREIGN KEY (list_id) REFERENCES shopping_lists(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(firebase_uid)
+);
+
+CREATE INDEX idx_shopping_lists_created_by ON shopping_lists(created_by_user_id);
+CREATE INDEX idx_shopping_list_recipes_recipe ON shopping_list_recipes(recipe_id);
+CREATE INDEX idx_shopping_list_items_list ON shopping_list_items(list_id);
+CREATE INDEX idx_shopping_list_shares_user ON shopping_list_shares(user_id);
@@ -0,0 +1,20 @@
+-- Meal planner: recipes planned for a date and meal slot. The household
+-- shares one plan, as it shares its recipes.
+CREATE TABLE meal_plans (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	plan_date TEXT NOT NULL, -- Date in YYYY-MM-DD format
+	slot TEXT NOT NULL,      -- breakfast, lunch, dinner or drinks
+	recipe_id TEXT NOT NULL,
+	notes TEXT NOT NULL DEFAULT '',
+	make_log_id INTEGER,     -- Set once the meal has been cooked
+	created_by_user_id TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (recipe_id) REFERENCES recipes(id) ON DELETE CASCADE,
+	FOREIGN KEY (make_log_id) REFERENCES make_logs(id) ON DELETE SET NULL,
+	FOREIGN KEY (created_by_user_id) REFERENCES users(firebase_uid)
+);
+
+CREATE INDEX idx_meal_plans_date ON meal_plans(plan_date);
+CREATE INDEX idx_meal_plans_recipe ON meal_plans(recipe_id);
+CREATE INDEX idx_meal_plans_make_log ON meal_plans(make_log_id);
@@ -0,0 +1,23 @@
+-- Collections: curated, ordered sets of recipes such as "Christmas 2026"
+CREATE TABLE collections (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	created_by_user_id TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (created_by_user_id) REFERENCES users(firebase_uid)
+);
+
+CREATE TABLE collection_recipes (
+	collection_id TEXT NOT NULL,
+	recipe_id TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (collection_id, recipe_id),
+	FOREIGN KEY (collection_id) REFERENCES collections(id) ON DELETE CASCADE,
+	FOREIGN KEY (recipe_id) REFERENCES recipes(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_collection_recipes_recipe ON collection_recipes(recipe_id);
+CREATE INDEX idx_collection_recipes_position ON collection_recipes(collection_id, position);
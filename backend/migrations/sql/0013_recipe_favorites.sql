@@ -0,0 +1,23 @@
+-- Recipes each user has starred
+CREATE TABLE recipe_favorites (
+	user_id TEXT NOT NULL,
+	recipe_id TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, recipe_id),
+	FOREIGN KEY (user_id) REFERENCES users(firebase_uid),
+	FOREIGN KEY (recipe_id) REFERENCES recipes(id) ON DELETE CASCADE
+);
+
+-- When each user last viewed each recipe
+CREATE TABLE recipe_views (
+	user_id TEXT NOT NULL,
+	recipe_id TEXT NOT NULL,
+	viewed_at DATETIME NOT NULL,
+	PRIMARY KEY (user_id, recipe_id),
+	FOREIGN KEY (user_id) REFERENCES users(firebase_uid),
+	FOREIGN KEY (recipe_id) REFERENCES recipes(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_recipe_favorites_recipe ON recipe_favorites(recipe_id);
+CREATE INDEX idx_recipe_views_recipe ON recipe_views(recipe_id);
+CREATE INDEX idx_recipe_views_user_viewed ON recipe_views(user_id, viewed_at);
@@ -0,0 +1,2 @@
+-- Optional 1-5 rating from the user who logged making a recipe
+ALTER TABLE make_logs ADD COLUMN rating INTEGER CHECK (rating BETWEEN 1 AND 5);
@@ -0,0 +1,15 @@
+-- Links from one recipe to another, written [[Recipe title]] in the
+-- ingredients or method markdown and resolved on save
+CREATE TABLE recipe_links (
+	recipe_id TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	field TEXT NOT NULL,     -- "ingredients" or "method"
+	link_text TEXT NOT NULL, -- Title or ID the link was written with
+	linked_recipe_id TEXT,   -- NULL until a recipe with that title exists
+	PRIMARY KEY (recipe_id, position),
+	FOREIGN KEY (recipe_id) REFERENCES recipes(id) ON DELETE CASCADE,
+	FOREIGN KEY (linked_recipe_id) REFERENCES recipes(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_recipe_links_linked ON recipe_links(linked_recipe_id);
+CREATE INDEX idx_recipe_links_text ON recipe_links(link_text COLLATE NOCASE);
@@ -0,0 +1,4 @@
+-- The recipe a variant was forked from, NULL for originals
+ALTER TABLE recipes ADD COLUMN parent_recipe_id TEXT REFERENCES recipes(id);
+
+CREATE INDEX idx_recipes_parent ON recipes(parent_recipe_id);
@@ -0,0 +1,17 @@
+-- Cached nutrition estimates, redone when a recipe's ingredients change or
+-- the bundled nutrient table does
+CREATE TABLE recipe_nutrition (
+	recipe_id TEXT PRIMARY KEY,
+	estimate TEXT NOT NULL,      -- JSON totals and per-ingredient breakdown
+	table_version TEXT NOT NULL, -- Hash of the nutrient table it was made with
+	computed_at DATETIME NOT NULL,
+	FOREIGN KEY (recipe_id) REFERENCES recipes(id) ON DELETE CASCADE
+);
+
+-- Foods chosen by editors for ingredient names the table matches badly
+CREATE TABLE nutrition_overrides (
+	ingredient TEXT PRIMARY KEY, -- Lowercase ingredient name
+	food TEXT NOT NULL,          -- Name of a food in the nutrient table
+	updated_by_user_id TEXT,
+	updated_at DATETIME NOT NULL
+);
@@ -0,0 +1,17 @@
+-- Ingredients the household has on hand, in the pantry or the bar
+CREATE TABLE inventory_items (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	item_key TEXT NOT NULL UNIQUE, -- Normalised name, e.g. "lime" for "Limes"
+	added_by_user_id TEXT,
+	added_at DATETIME NOT NULL
+);
+
+-- Ingredient names that an inventory item covers, besides its own, e.g.
+-- "lime juice" is covered by "lime"
+CREATE TABLE ingredient_aliases (
+	alias TEXT PRIMARY KEY,  -- Normalised ingredient name
+	ingredient TEXT NOT NULL, -- Normalised name of the item that covers it
+	created_by_user_id TEXT,
+	created_at DATETIME NOT NULL
+);
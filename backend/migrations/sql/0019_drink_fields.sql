@@ -0,0 +1,14 @@
+-- How a drink is served and made: glass, garnish, preparation ("shaken",
+-- "stirred", "built"), ice and base spirit. Empty for food.
+ALTER TABLE recipes ADD COLUMN glass TEXT NOT NULL DEFAULT '';
+ALTER TABLE recipes ADD COLUMN garnish TEXT NOT NULL DEFAULT '';
+ALTER TABLE recipes ADD COLUMN preparation TEXT NOT NULL DEFAULT '';
+ALTER TABLE recipes ADD COLUMN ice TEXT NOT NULL DEFAULT '';
+ALTER TABLE recipes ADD COLUMN base_spirit TEXT NOT NULL DEFAULT '';
+CREATE INDEX idx_recipes_base_spirit ON recipes(base_spirit);
+
+ALTER TABLE recipe_revisions ADD COLUMN glass TEXT NOT NULL DEFAULT '';
+ALTER TABLE recipe_revisions ADD COLUMN garnish TEXT NOT NULL DEFAULT '';
+ALTER TABLE recipe_revisions ADD COLUMN preparation TEXT NOT NULL DEFAULT '';
+ALTER TABLE recipe_revisions ADD COLUMN ice TEXT NOT NULL DEFAULT '';
+ALTER TABLE recipe_revisions ADD COLUMN base_spirit TEXT NOT NULL DEFAULT '';
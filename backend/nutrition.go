@@ -0,0 +1,680 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	_ "embed"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nutrientsCSV is the bundled nutrient table, so nutrition is estimated
+// without calling out to an external API
+//
+//go:embed nutrients.csv
+var nutrientsCSV string
+
+// Nutrients are the calories (kcal) and macronutrients (grams) in some food
+type Nutrients struct {
+	Calories float64 `json:"calories"`
+	Protein  float64 `json:"protein"`
+	Fat      float64 `json:"fat"`
+	Carbs    float64 `json:"carbs"`
+}
+
+// IngredientNutrition is the estimate for one line of a recipe's ingredients
+type IngredientNutrition struct {
+	Text      string     `json:"text"`             // The line as written
+	Food      string     `json:"food"`             // Nutrient table food it matched, "" if none
+	Override  bool       `json:"override"`         // Whether an editor chose the food
+	Grams     *float64   `json:"grams"`            // Estimated weight (nullable)
+	Nutrients *Nutrients `json:"nutrients"`        // Nullable: left out of the estimate
+	Reason    string     `json:"reason,omitempty"` // Why it was left out
+}
+
+// RecipeNutrition is the estimated nutrition of a recipe
+type RecipeNutrition struct {
+	RecipeID    string                `json:"recipeId"`
+	Servings    *int                  `json:"servings"`
+	PerServing  *Nutrients            `json:"perServing"` // Nullable: the recipe doesn't say how many servings it makes
+	Total       Nutrients             `json:"total"`
+	Ingredients []IngredientNutrition `json:"ingredients"`
+	Unmatched   []string              `json:"unmatched"` // Ingredients left out of the estimate
+	ComputedAt  time.Time             `json:"computedAt"`
+}
+
+// NutritionOverride maps an ingredient name to the food it should be counted as
+type NutritionOverride struct {
+	Ingredient      string    `json:"ingredient"` // Ingredient name, matched ignoring case
+	Food            string    `json:"food"`       // Name of a food in the nutrient table
+	UpdatedByUserID *string   `json:"updatedByUserId"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+// nutritionEstimate is the part of a recipe's nutrition that is cached. Per
+// serving figures are worked out when read, so changing servings is free.
+type nutritionEstimate struct {
+	Total       Nutrients             `json:"total"`
+	Ingredients []IngredientNutrition `json:"ingredients"`
+	Unmatched   []string              `json:"unmatched"`
+}
+
+// nutrientFood is one food in the nutrient table
+type nutrientFood struct {
+	name        string
+	per100g     Nutrients
+	gramsPerCup float64 // 0 if unknown
+	gramsEach   float64 // 0 if unknown
+}
+
+// nutrientTable is the parsed nutrient table
+type nutrientTable struct {
+	foods   map[string]*nutrientFood // By name
+	aliases map[string]*nutrientFood // By normalized name or other name
+	version string                   // Changes when the table does, so cached estimates are redone
+}
+
+var (
+	errNutritionOverrideNotFound = errors.New("nutrition override not found")
+	errUnknownFood               = errors.New("food is not in the nutrient table")
+)
+
+var nutrients = mustParseNutrientTable(nutrientsCSV)
+
+// countedUnits are weighed using the food's grams each
+var countedUnits = map[string]bool{
+	"": true, "clove": true, "slice": true, "piece": true, "stalk": true, "head": true,
+	"sheet": true, "cube": true, "packet": true, "sprig": true,
+}
+
+// unitGrams are rough weights of units that don't depend much on the food
+var unitGrams = map[string]float64{
+	"pinch": 0.4, "dash": 0.6, "splash": 5, "drop": 0.05,
+	"can": 400, "tin": 400, "handful": 30, "bunch": 50,
+}
+
+// mustParseNutrientTable parses the bundled table, which can only be broken
+// by a bad edit to nutrients.csv
+func mustParseNutrientTable(data string) *nutrientTable {
+	table, err := parseNutrientTable(data)
+	if err != nil {
+		panic(fmt.Sprintf("invalid nutrients.csv: %v", err))
+	}
+	return table
+}
+
+// parseNutrientTable reads the nutrient table CSV (see nutrients.csv)
+func parseNutrientTable(data string) (*nutrientTable, error) {
+	reader := csv.NewReader(strings.NewReader(data))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 7
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(data))
+	table := &nutrientTable{
+		foods:   make(map[string]*nutrientFood),
+		aliases: make(map[string]*nutrientFood),
+		version: hex.EncodeToString(sum[:8]),
+	}
+	for _, record := range records[1:] {
+		names := strings.Split(record[0], "|")
+		food := &nutrientFood{name: names[0]}
+
+		values := make([]float64, 6)
+		for i, field := range record[1:] {
+			if field == "" {
+				continue
+			}
+			if values[i], err = strconv.ParseFloat(field, 64); err != nil {
+				return nil, fmt.Errorf("%s: %w", food.name, err)
+			}
+		}
+		food.per100g = Nutrients{Calories: values[0], Protein: values[1], Fat: values[2], Carbs: values[3]}
+		food.gramsPerCup, food.gramsEach = values[4], values[5]
+
+		if table.foods[food.name] != nil {
+			return nil, fmt.Errorf("%s is listed twice", food.name)
+		}
+		table.foods[food.name] = food
+		for _, name := range names {
+			table.aliases[normalizeFoodName(name)] = food
+		}
+	}
+	return table, nil
+}
+
+// normalizeFoodName lowercases a name and makes its words singular, so that
+// "2 Eggs" and "egg" match
+func normalizeFoodName(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return r == ' ' || r == ',' || r == '(' || r == ')'
+	})
+	for i, word := range words {
+		words[i] = singular(word)
+	}
+	return strings.Join(words, " ")
+}
+
+// matchFood returns the food with the longest name found in an ingredient
+// name, e.g. "brown sugar" rather than "sugar" for "light brown sugar". Ties
+// go to the first name alphabetically, so estimates don't change between runs.
+func (t *nutrientTable) matchFood(name string) *nutrientFood {
+	words := " " + normalizeFoodName(name) + " "
+
+	match := ""
+	for alias := range t.aliases {
+		longer := len(alias) > len(match) || (len(alias) == len(match) && alias < match)
+		if longer && strings.Contains(words, " "+alias+" ") {
+			match = alias
+		}
+	}
+	return t.aliases[match]
+}
+
+// estimateNutrition works out the nutrients in a recipe's ingredients.
+// overrides maps lowercase ingredient names to the food an editor chose.
+func estimateNutrition(ingredients []Ingredient, overrides map[string]string) nutritionEstimate {
+	estimate := nutritionEstimate{Ingredients: []IngredientNutrition{}, Unmatched: []string{}}
+	for _, ing := range ingredients {
+		result, n := estimateIngredient(ing, overrides)
+		if n == nil {
+			estimate.Unmatched = append(estimate.Unmatched, ing.Text)
+		} else {
+			estimate.Total = estimate.Total.add(*n)
+		}
+		estimate.Ingredients = append(estimate.Ingredients, result)
+	}
+	estimate.Total = estimate.Total.rounded()
+	return estimate
+}
+
+// estimateIngredient matches an ingredient to a food and weighs it. It also
+// returns the unrounded nutrients, or nil if the ingredient is left out.
+func estimateIngredient(ing Ingredient, overrides map[string]string) (IngredientNutrition, *Nutrients) {
+	result := IngredientNutrition{Text: ing.Text}
+	if !ing.Parsed || ing.Name == "" {
+		result.Reason = "couldn't read the ingredient"
+		return result, nil
+	}
+
+	food := nutrients.foods[overrides[strings.ToLower(ing.Name)]]
+	result.Override = food != nil
+	if food == nil {
+		food = nutrients.matchFood(ing.Name)
+	}
+	if food == nil {
+		result.Reason = "not in the nutrient table"
+		return result, nil
+	}
+	result.Food = food.name
+
+	grams, reason := ingredientGrams(ing, food)
+	if reason != "" {
+		result.Reason = reason
+		return result, nil
+	}
+
+	rounded := math.Round(grams*10) / 10
+	result.Grams = &rounded
+	n := food.per100g.scaled(grams / 100)
+	r := n.rounded()
+	result.Nutrients = &r
+	return result, &n
+}
+
+// ingredientGrams estimates the weight of an ingredient, or returns why it
+// can't be weighed
+func ingredientGrams(ing Ingredient, food *nutrientFood) (float64, string) {
+	if ing.Quantity == nil {
+		return 0, "no amount given"
+	}
+	amount := *ing.Quantity
+	if ing.QuantityMax != nil {
+		amount = (amount + *ing.QuantityMax) / 2
+	}
+
+	if measure, ok := convertibleUnits[ing.Unit]; ok && measure.weight {
+		return amount * measure.base, ""
+	}
+
+	millilitres, ok := spoonMillilitres[ing.Unit]
+	if measure, isVolume := convertibleUnits[ing.Unit]; isVolume {
+		millilitres, ok = measure.base, true
+	}
+	if ok {
+		gramsPerCup := food.gramsPerCup
+		if gramsPerCup == 0 {
+			if density, found := ingredientDensity(ing.Name); found {
+				gramsPerCup = density
+			} else {
+				gramsPerCup = cupMillilitres
+			}
+		}
+		return amount * millilitres * gramsPerCup / cupMillilitres, ""
+	}
+
+	if grams, ok := unitGrams[ing.Unit]; ok {
+		return amount * grams, ""
+	}
+
+	if countedUnits[ing.Unit] && food.gramsEach > 0 {
+		return amount * food.gramsEach, ""
+	}
+	if ing.Unit == "" {
+		return 0, "no unit to weigh it by"
+	}
+	return 0, "can't weigh a " + ing.Unit
+}
+
+func (n Nutrients) add(other Nutrients) Nutrients {
+	return Nutrients{
+		Calories: n.Calories + other.Calories,
+		Protein:  n.Protein + other.Protein,
+		Fat:      n.Fat + other.Fat,
+		Carbs:    n.Carbs + other.Carbs,
+	}
+}
+
+func (n Nutrients) scaled(factor float64) Nutrients {
+	return Nutrients{
+		Calories: n.Calories * factor,
+		Protein:  n.Protein * factor,
+		Fat:      n.Fat * factor,
+		Carbs:    n.Carbs * factor,
+	}
+}
+
+// rounded rounds calories to whole numbers and grams to one decimal place
+func (n Nutrients) rounded() Nutrients {
+	return Nutrients{
+		Calories: math.Round(n.Calories),
+		Protein:  math.Round(n.Protein*10) / 10,
+		Fat:      math.Round(n.Fat*10) / 10,
+		Carbs:    math.Round(n.Carbs*10) / 10,
+	}
+}
+
+// getNutritionOverrides returns the editors' food choices by lowercase
+// ingredient name
+func getNutritionOverrides(ctx context.Context) (map[string]string, error) {
+	rows, err := dbConn(ctx).QueryContext(ctx, `SELECT ingredient, food FROM nutrition_overrides`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := make(map[string]string)
+	for rows.Next() {
+		var ingredient, food string
+		if err := rows.Scan(&ingredient, &food); err != nil {
+			return nil, err
+		}
+		overrides[ingredient] = food
+	}
+	return overrides, rows.Err()
+}
+
+// setRecipeNutrition estimates a recipe's nutrition from its ingredients and
+// caches it. The caller must be inside applyChange.
+func setRecipeNutrition(ctx context.Context, recipeID string, ingredients []Ingredient, computedAt time.Time) error {
+	overrides, err := getNutritionOverrides(ctx)
+	if err != nil {
+		return err
+	}
+
+	estimate, err := json.Marshal(estimateNutrition(ingredients, overrides))
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO recipe_nutrition (recipe_id, estimate, table_version, computed_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (recipe_id) DO UPDATE SET
+			estimate = excluded.estimate, table_version = excluded.table_version, computed_at = excluded.computed_at
+	`
+	_, err = execWrite(ctx, query, recipeID, string(estimate), nutrients.version, computedAt)
+	return err
+}
+
+// GetRecipeNutrition returns the estimated nutrition of a loaded recipe. An
+// estimate missing from the cache is worked out without being stored.
+func GetRecipeNutrition(ctx context.Context, recipe *Recipe) (*RecipeNutrition, error) {
+	rlockDB(ctx)
+	defer dbMutex.RUnlock()
+
+	var estimate nutritionEstimate
+	var data, version string
+	var computedAt time.Time
+	query := `SELECT estimate, table_version, computed_at FROM recipe_nutrition WHERE recipe_id = ?`
+	err := db.QueryRowContext(ctx, query, recipe.ID).Scan(&data, &version, &computedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if err == nil && version == nutrients.version {
+		if err := json.Unmarshal([]byte(data), &estimate); err != nil {
+			return nil, err
+		}
+	} else {
+		overrides, err := getNutritionOverrides(ctx)
+		if err != nil {
+			return nil, err
+		}
+		estimate = estimateNutrition(recipe.IngredientList, overrides)
+		computedAt = time.Now()
+	}
+
+	nutrition := &RecipeNutrition{
+		RecipeID:    recipe.ID,
+		Servings:    recipe.Servings,
+		Total:       estimate.Total,
+		Ingredients: estimate.Ingredients,
+		Unmatched:   estimate.Unmatched,
+		ComputedAt:  computedAt,
+	}
+	if recipe.Servings != nil && *recipe.Servings > 0 {
+		perServing := estimate.Total.scaled(1 / float64(*recipe.Servings)).rounded()
+		nutrition.PerServing = &perServing
+	}
+	return nutrition, nil
+}
+
+// backfillRecipeNutrition estimates the nutrition of recipes that have no
+// cached estimate, or one made with a different nutrient table
+func backfillRecipeNutrition(ctx context.Context) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	query := `
+		SELECT r.id, COALESCE(r.ingredients, '')
+		FROM recipes r
+		LEFT JOIN recipe_nutrition n ON n.recipe_id = r.id
+		WHERE n.recipe_id IS NULL OR n.table_version != ?
+	`
+	markdown, err := queryRecipeIngredientsMarkdown(ctx, query, nutrients.version)
+	if err != nil || len(markdown) == 0 {
+		return err
+	}
+
+	computedAt := time.Now()
+	err = applyChange(ctx, "estimate recipe nutrition", func(ctx context.Context) error {
+		for id, ingredients := range markdown {
+			if err := setRecipeNutrition(ctx, id, parseIngredients(ingredients), computedAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Estimated nutrition for %d recipes", len(markdown))
+	return nil
+}
+
+// queryRecipeIngredientsMarkdown runs a query that selects recipe IDs and
+// ingredients markdown
+func queryRecipeIngredientsMarkdown(ctx context.Context, query string, args ...interface{}) (map[string]string, error) {
+	rows, err := dbConn(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	markdown := make(map[string]string)
+	for rows.Next() {
+		var id, ingredients string
+		if err := rows.Scan(&id, &ingredients); err != nil {
+			return nil, err
+		}
+		markdown[id] = ingredients
+	}
+	return markdown, rows.Err()
+}
+
+// GetNutritionOverrides returns every override, by ingredient name
+func GetNutritionOverrides(ctx context.Context) ([]NutritionOverride, error) {
+	rlockDB(ctx)
+	defer dbMutex.RUnlock()
+
+	query := `SELECT ingredient, food, updated_by_user_id, updated_at FROM nutrition_overrides ORDER BY ingredient`
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := []NutritionOverride{}
+	for rows.Next() {
+		var o NutritionOverride
+		if err := rows.Scan(&o.Ingredient, &o.Food, &o.UpdatedByUserID, &o.UpdatedAt); err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, o)
+	}
+	return overrides, rows.Err()
+}
+
+// SetNutritionOverride counts an ingredient as food in every recipe that uses
+// it, and re-estimates those recipes
+func SetNutritionOverride(ctx context.Context, override *NutritionOverride) error {
+	if nutrients.foods[override.Food] == nil {
+		return errUnknownFood
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	override.UpdatedAt = time.Now()
+	o := *override
+	return changeNutritionOverride(ctx, "set nutrition override for "+o.Ingredient, o.Ingredient, o.UpdatedAt, func(ctx context.Context) error {
+		query := `
+			INSERT INTO nutrition_overrides (ingredient, food, updated_by_user_id, updated_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT (ingredient) DO UPDATE SET
+				food = excluded.food, updated_by_user_id = excluded.updated_by_user_id, updated_at = excluded.updated_at
+		`
+		_, err := execWrite(ctx, query, o.Ingredient, o.Food, o.UpdatedByUserID, o.UpdatedAt)
+		return err
+	})
+}
+
+// DeleteNutritionOverride goes back to matching an ingredient automatically
+func DeleteNutritionOverride(ctx context.Context, ingredient string) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	computedAt := time.Now()
+	return changeNutritionOverride(ctx, "delete nutrition override for "+ingredient, ingredient, computedAt, func(ctx context.Context) error {
+		result, err := execWrite(ctx, `DELETE FROM nutrition_overrides WHERE ingredient = ?`, ingredient)
+		if err != nil {
+			return err
+		}
+		return requireRowAffected(result, errNutritionOverrideNotFound)
+	})
+}
+
+// changeNutritionOverride applies a change to an ingredient's override, then
+// re-estimates the recipes that use the ingredient. The caller must hold dbMutex.
+func changeNutritionOverride(ctx context.Context, desc, ingredient string, computedAt time.Time, change func(ctx context.Context) error) error {
+	query := `
+		SELECT DISTINCT r.id, COALESCE(r.ingredients, '')
+		FROM recipes r
+		JOIN recipe_ingredients i ON i.recipe_id = r.id
+		WHERE i.name = ? COLLATE NOCASE
+	`
+	return applyChange(ctx, desc, func(ctx context.Context) error {
+		// Look up the affected recipes in the change, so a replay on a newer
+		// replica updates the recipes that use the ingredient there
+		markdown, err := queryRecipeIngredientsMarkdown(ctx, query, ingredient)
+		if err != nil {
+			return err
+		}
+		if err := change(ctx); err != nil {
+			return err
+		}
+		for id, ingredients := range markdown {
+			if err := setRecipeNutrition(ctx, id, parseIngredients(ingredients), computedAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// nutritionFoods returns the names of the foods in the nutrient table
+func nutritionFoods() []string {
+	names := make([]string, 0, len(nutrients.foods))
+	for name := range nutrients.foods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// recipeNutritionHandler serves GET /recipes/{id}/nutrition
+func recipeNutritionHandler(w http.ResponseWriter, r *http.Request, recipeID string) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Public read - no auth required
+	recipe, err := GetRecipeByID(r.Context(), recipeID)
+	if err != nil {
+		log.Printf("Error getting recipe: %v", err)
+		http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+		return
+	}
+	if recipe == nil {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	}
+	if recipe.RecipeType != "food" {
+		http.Error(w, "Nutrition is only estimated for food recipes", http.StatusBadRequest)
+		return
+	}
+
+	nutrition, err := GetRecipeNutrition(r.Context(), recipe)
+	if err != nil {
+		log.Printf("Error getting recipe nutrition: %v", err)
+		http.Error(w, "Failed to get recipe nutrition", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(nutrition)
+}
+
+// nutritionHandler serves /nutrition and the paths below it:
+//
+//	GET    /nutrition/foods                    foods in the nutrient table
+//	GET    /nutrition/overrides                list overrides
+//	PUT    /nutrition/overrides/{ingredient}   count an ingredient as a food (editors and admins)
+//	DELETE /nutrition/overrides/{ingredient}   go back to matching it automatically (editors and admins)
+func nutritionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/nutrition"), "/")
+	ingredient, isOverride := strings.CutPrefix(path, "overrides/")
+
+	switch {
+	case path == "foods":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Public read - no auth required
+		json.NewEncoder(w).Encode(nutritionFoods())
+
+	case path == "overrides":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Public read - no auth required
+		overrides, err := GetNutritionOverrides(r.Context())
+		if err != nil {
+			log.Printf("Error getting nutrition overrides: %v", err)
+			http.Error(w, "Failed to get nutrition overrides", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(overrides)
+
+	case isOverride && ingredient != "":
+		if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Editors and admins only
+		userID, err := authenticateRequest(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !userHasRole(r.Context(), userID, "editor", "admin") {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		ingredient = strings.ToLower(strings.Join(strings.Fields(ingredient), " "))
+
+		if r.Method == http.MethodDelete {
+			log.Printf("Deleting nutrition override for %q - authenticated user: %s", ingredient, userID)
+
+			err := DeleteNutritionOverride(r.Context(), ingredient)
+			if errors.Is(err, errNutritionOverrideNotFound) {
+				http.Error(w, "Nutrition override not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("Error deleting nutrition override: %v", err)
+				http.Error(w, "Failed to delete nutrition override", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		log.Printf("Setting nutrition override for %q - authenticated user: %s", ingredient, userID)
+
+		var override NutritionOverride
+		if err := json.NewDecoder(r.Body).Decode(&override); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		override.Ingredient = ingredient
+		override.UpdatedByUserID = &userID
+
+		err = SetNutritionOverride(r.Context(), &override)
+		if errors.Is(err, errUnknownFood) {
+			http.Error(w, "Food is not in the nutrient table; see GET /nutrition/foods", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Error setting nutrition override: %v", err)
+			http.Error(w, "Failed to set nutrition override", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(override)
+
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"math"
+)
+
+// UserRating is one user's average rating of a recipe, from the make logs
+// they rated
+type UserRating struct {
+	UserID        string  `json:"userId"`
+	DisplayName   *string `json:"displayName"` // Nullable
+	AverageRating float64 `json:"averageRating"`
+	RatingCount   int     `json:"ratingCount"`
+}
+
+// validRating reports whether rating is unset or from 1 to 5
+func validRating(rating *int) bool {
+	return rating == nil || (*rating >= 1 && *rating <= 5)
+}
+
+// recipeRatings adds up the ratings of a recipe
+type recipeRatings struct {
+	users []UserRating // Highest average first
+	total int
+	count int
+}
+
+// summary returns each user's average rating of the recipe, highest first,
+// along with the overall average (nil if nobody has rated it) and the number
+// of ratings
+func (r recipeRatings) summary() ([]UserRating, *float64, int) {
+	users := r.users
+	if users == nil {
+		users = []UserRating{}
+	}
+	if r.count == 0 {
+		return users, nil, 0
+	}
+	average := roundRating(float64(r.total) / float64(r.count))
+	return users, &average, r.count
+}
+
+// getRatingsByRecipe returns the ratings of each recipe, by recipe ID
+func getRatingsByRecipe(ctx context.Context, recipeIDs []string) (map[string]recipeRatings, error) {
+	placeholders, args := inArgs(recipeIDs)
+	query := `
+		SELECT ml.recipe_id, COALESCE(ml.created_by_user_id, ''), u.display_name, SUM(ml.rating), COUNT(ml.rating)
+		FROM make_logs ml
+		LEFT JOIN users u ON u.firebase_uid = ml.created_by_user_id
+		WHERE ml.recipe_id IN (` + placeholders + `) AND ml.rating IS NOT NULL
+		GROUP BY ml.recipe_id, ml.created_by_user_id
+		ORDER BY AVG(ml.rating) DESC, COUNT(ml.rating) DESC
+	`
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ratings := make(map[string]recipeRatings)
+	for rows.Next() {
+		var recipeID string
+		var rating UserRating
+		var userTotal int
+		if err := rows.Scan(&recipeID, &rating.UserID, &rating.DisplayName, &userTotal, &rating.RatingCount); err != nil {
+			return nil, err
+		}
+		if rating.DisplayName != nil && *rating.DisplayName == "" {
+			rating.DisplayName = nil
+		}
+		rating.AverageRating = roundRating(float64(userTotal) / float64(rating.RatingCount))
+
+		r := ratings[recipeID]
+		r.users = append(r.users, rating)
+		r.total += userTotal
+		r.count += rating.RatingCount
+		ratings[recipeID] = r
+	}
+	return ratings, rows.Err()
+}
+
+// roundRating rounds an average rating to one decimal place
+func roundRating(rating float64) float64 {
+	return math.Round(rating*10) / 10
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// dbGenerationHeader carries the replica position a response was served from
+	dbGenerationHeader = "X-DB-Generation"
+
+	// defaultReloadInterval is how often the replica is checked for changes made
+	// by other instances (override with DB_RELOAD_INTERVAL, 0 disables)
+	defaultReloadInterval = 30 * time.Second
+)
+
+// servedGenerationKey is the context key for where a request's reads note
+// the replica position they were served from
+type servedGenerationKey struct{}
+
+// rlockDB takes dbMutex for reading and notes the replica position the read
+// sees, so the response can report it. Callers release it with
+// dbMutex.RUnlock.
+func rlockDB(ctx context.Context) {
+	dbMutex.RLock()
+	if served, ok := ctx.Value(servedGenerationKey{}).(*atomic.Int64); ok {
+		served.Store(dbGeneration.Load())
+	}
+}
+
+// generationWriter sets the X-DB-Generation header as the response is
+// written, to the position the request's last read saw
+type generationWriter struct {
+	http.ResponseWriter
+	served      *atomic.Int64
+	wroteHeader bool
+}
+
+// withServedGeneration wraps a request so its reads note the replica position
+// they see and the response reports it
+func withServedGeneration(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request) {
+	// A request that doesn't read reports the position it arrived at
+	w.Header().Set(dbGenerationHeader, strconv.FormatInt(dbGeneration.Load(), 10))
+
+	served := &atomic.Int64{}
+	served.Store(-1)
+	ctx := context.WithValue(r.Context(), servedGenerationKey{}, served)
+	return &generationWriter{ResponseWriter: w, served: served}, r.WithContext(ctx)
+}
+
+func (w *generationWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if generation := w.served.Load(); generation >= 0 {
+			w.Header().Set(dbGenerationHeader, strconv.FormatInt(generation, 10))
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *generationWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// reloadInterval returns how often to poll the replica for newer changes
+func reloadInterval() time.Duration {
+	if v := os.Getenv("DB_RELOAD_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d
+		}
+		log.Printf("Warning: invalid DB_RELOAD_INTERVAL %q, using %s", v, defaultReloadInterval)
+	}
+	return defaultReloadInterval
+}
+
+// startReloadWatcher polls the replica every interval and applies changes
+// uploaded by other instances, so a long-lived instance doesn't keep serving
+// stale reads. It stops when ctx is cancelled.
+func startReloadWatcher(ctx context.Context, interval time.Duration) {
+	if interval == 0 {
+		log.Println("Database reload watcher disabled")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := reloadFromReplica(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to reload database from replica: %v", err)
+			}
+		}
+	}()
+}
+
+// latestReplicaPositions returns the position of the newest snapshot and the
+// newest position available in the replica overall
+func latestReplicaPositions(ctx context.Context) (snapshot, latest int64, err error) {
+	snapshots, err := listReplica(ctx, replicaSnapshotPrefix)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(snapshots) > 0 {
+		snapshot = snapshots[len(snapshots)-1]
+	}
+
+	segments, err := listReplica(ctx, replicaSegmentPrefix)
+	if err != nil {
+		return 0, 0, err
+	}
+	latest = snapshot
+	if len(segments) > 0 && segments[len(segments)-1] > latest {
+		latest = segments[len(segments)-1]
+	}
+	return snapshot, latest, nil
+}
+
+// reloadFromReplica brings the local database up to the latest replica
+// position. It does nothing while there are local changes waiting to be
+// uploaded, since that upload will conflict and rebase onto the newer replica
+// anyway. Newer segments are downloaded first and applied under dbMutex so
+// readers see either the old state or the new one. If they have already been
+// compacted away, or a snapshot newer than the local copy exists (as written
+// when a backup is restored), a full restore is swapped in instead.
+func reloadFromReplica(ctx context.Context) error {
+	syncMutex.Lock()
+	defer syncMutex.Unlock()
+
+	local := dbGeneration.Load()
+	snapshot, remote, err := latestReplicaPositions(ctx)
+	if err != nil {
+		return err
+	}
+	if remote <= local || pendingChangeCount() > 0 {
+		return nil
+	}
+	if snapshot > local {
+		return swapInReplica(ctx)
+	}
+
+	segments, err := listReplica(ctx, replicaSegmentPrefix)
+	if err != nil {
+		return err
+	}
+
+	var newer []*replicaSegment
+	for _, position := range segments {
+		if position <= local {
+			continue
+		}
+		if position != local+int64(len(newer))+1 {
+			// The segments we need are gone, only a full restore will do
+			newer = nil
+			break
+		}
+		segment, err := fetchSegment(ctx, position)
+		if err != nil {
+			return fmt.Errorf("failed to download segment %d: %w", position, err)
+		}
+		newer = append(newer, segment)
+	}
+
+	if len(newer) > 0 && newer[len(newer)-1].Position >= remote {
+		return applyNewerSegments(ctx, newer)
+	}
+	return swapInReplica(ctx)
+}
+
+// applyNewerSegments replays downloaded segments on the local database
+func applyNewerSegments(ctx context.Context, segments []*replicaSegment) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	// A write may have slipped in while the segments were downloading
+	if pendingChangeCount() > 0 {
+		return nil
+	}
+
+	for _, segment := range segments {
+		if err := applySegmentChanges(ctx, db, segment); err != nil {
+			return fmt.Errorf("failed to apply segment %d: %w", segment.Position, err)
+		}
+		dbGeneration.Store(segment.Position)
+	}
+
+	log.Printf("Reloaded database to position %d (%d newer segments)", dbGeneration.Load(), len(segments))
+	return nil
+}
+
+// swapInReplica restores the replica to a temporary file and swaps it in for
+// the local database
+func swapInReplica(ctx context.Context) error {
+	reloadPath := localDBPath + ".reload"
+	position, snapshot, err := restoreReplica(ctx, reloadPath)
+	if err != nil {
+		os.Remove(reloadPath)
+		return err
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if pendingChangeCount() > 0 {
+		os.Remove(reloadPath)
+		return nil
+	}
+
+	if err := replaceLocalDB(ctx, reloadPath); err != nil {
+		return err
+	}
+	dbGeneration.Store(position)
+	snapshotPosition.Store(snapshot)
+
+	log.Printf("Reloaded database to position %d from snapshot %d", position, snapshot)
+	return nil
+}
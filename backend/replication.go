@@ -0,0 +1,514 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// The database is replicated to blob storage as a series of numbered segments,
+// each holding the SQL statements of the writes made since the previous
+// segment, plus periodic snapshots of the whole file. A snapshot at position N
+// contains every segment up to and including N, so restoring means downloading
+// the latest snapshot and replaying the segments after it in order.
+const (
+	replicaSnapshotPrefix = "replica/snapshots/"
+	replicaSegmentPrefix  = "replica/segments/"
+
+	// defaultSnapshotEvery is how many segments accumulate before they are
+	// compacted into a new snapshot (override with DB_SNAPSHOT_EVERY)
+	defaultSnapshotEvery = 100
+)
+
+// replicaSegment is the JSON document stored for each segment
+type replicaSegment struct {
+	Position  int64           `json:"position"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Changes   []segmentChange `json:"changes"`
+}
+
+// segmentChange is one logical write (a pendingChange) within a segment
+type segmentChange struct {
+	Description string            `json:"description"`
+	Statements  []changeStatement `json:"statements"`
+}
+
+// changeStatement is a single SQL write with its bound arguments
+type changeStatement struct {
+	SQL  string        `json:"sql"`
+	Args []interface{} `json:"args"`
+}
+
+// recordingChange is the change whose statements execWrite is capturing.
+// It is only set while dbMutex is held for writing.
+var recordingChange *pendingChange
+
+// lastRowID is the last ID handed out by newRowID
+var lastRowID atomic.Int64
+
+// newRowID returns the ID for a new row that clients or later changes refer
+// to. If SQLite assigned it, replaying the change on top of another
+// instance's changes would give the row a different ID, so callers choose it
+// before applyChange and bind it explicitly. IDs are the time in
+// microseconds, which keeps them increasing and makes it unlikely that two
+// instances choose the same one.
+func newRowID() int64 {
+	for {
+		last := lastRowID.Load()
+		id := time.Now().UnixMicro()
+		if id <= last {
+			id = last + 1
+		}
+		if lastRowID.CompareAndSwap(last, id) {
+			return id
+		}
+	}
+}
+
+// changeTxKey is the context key for the transaction a change is applied in
+type changeTxKey struct{}
+
+// dbQueryer is what both *sql.DB and *sql.Tx run statements with
+type dbQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// dbConn returns the transaction of the change being applied in ctx, or the
+// database outside a change. Reads made while applying a change go through it
+// so they see the change's own writes.
+func dbConn(ctx context.Context) dbQueryer {
+	if tx, ok := ctx.Value(changeTxKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return db
+}
+
+// execWrite executes a write against the local database and records the
+// statement in the change being applied so it is shipped to the replica.
+// Callers must hold dbMutex for writing.
+func execWrite(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	result, err := dbConn(ctx).ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if recordingChange != nil {
+		replicaArgs, err := toReplicaArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		recordingChange.statements = append(recordingChange.statements, changeStatement{SQL: query, Args: replicaArgs})
+	}
+
+	return result, nil
+}
+
+// toReplicaArgs converts statement arguments into JSON-friendly values that
+// bind exactly as the originals did
+func toReplicaArgs(args []interface{}) ([]interface{}, error) {
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		v, err := driver.DefaultParameterConverter.ConvertValue(arg)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported statement argument %T: %w", arg, err)
+		}
+		// Times are bound as text by the SQLite driver; store the same text
+		if t, ok := v.(time.Time); ok {
+			v = t.Format(sqlite3.SQLiteTimestampFormats[0])
+		}
+		converted[i] = v
+	}
+	return converted, nil
+}
+
+// fromReplicaArgs restores arguments decoded with json.Decoder.UseNumber
+func fromReplicaArgs(args []interface{}) []interface{} {
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		if n, ok := arg.(json.Number); ok {
+			if v, err := n.Int64(); err == nil {
+				converted[i] = v
+			} else if v, err := n.Float64(); err == nil {
+				converted[i] = v
+			} else {
+				converted[i] = n.String()
+			}
+			continue
+		}
+		converted[i] = arg
+	}
+	return converted
+}
+
+func snapshotKey(position int64) string {
+	return fmt.Sprintf("%s%020d.db", replicaSnapshotPrefix, position)
+}
+
+func segmentKey(position int64) string {
+	return fmt.Sprintf("%s%020d.json", replicaSegmentPrefix, position)
+}
+
+// replicaPosition extracts the position from a snapshot or segment key
+func replicaPosition(key string) (int64, error) {
+	name := path.Base(key)
+	return strconv.ParseInt(strings.TrimSuffix(name, path.Ext(name)), 10, 64)
+}
+
+// listReplica returns the positions of all objects under prefix in ascending order
+func listReplica(ctx context.Context, prefix string) ([]int64, error) {
+	blobs, err := blobStore.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var positions []int64
+	for _, blob := range blobs {
+		position, err := replicaPosition(blob.Key)
+		if err != nil {
+			log.Printf("Warning: ignoring unexpected replica object %s", blob.Key)
+			continue
+		}
+		positions = append(positions, position)
+	}
+	return positions, nil
+}
+
+// shipSegment uploads every pending change as the next segment. The segment
+// may only be created if it does not exist yet; if another instance already
+// wrote this position, or a snapshot has moved past it, the upload fails with
+// ErrBlobPrecondition.
+func shipSegment(ctx context.Context) error {
+	pendingMutex.Lock()
+	changes := append([]pendingChange(nil), pendingChanges...)
+	pendingMutex.Unlock()
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	position := dbGeneration.Load() + 1
+
+	// Compaction deletes the segments a newer snapshot covers, so if this
+	// instance fell behind, its position may be free again even though other
+	// instances have written past it. A segment there would never be restored.
+	snapshots, err := listReplica(ctx, replicaSnapshotPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	if n := len(snapshots); n > 0 && snapshots[n-1] >= position {
+		return fmt.Errorf("%w: snapshot %d is past segment %d", ErrBlobPrecondition, snapshots[n-1], position)
+	}
+
+	segment := replicaSegment{Position: position, CreatedAt: time.Now().UTC()}
+	for _, change := range changes {
+		segment.Changes = append(segment.Changes, segmentChange{Description: change.description, Statements: change.statements})
+	}
+
+	data, err := json.Marshal(segment)
+	if err != nil {
+		return fmt.Errorf("failed to encode segment: %w", err)
+	}
+
+	_, err = blobStore.Put(ctx, segmentKey(position), bytes.NewReader(data), PutOptions{
+		ContentType: "application/json",
+		IfNotExists: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	dbGeneration.Store(position)
+
+	pendingMutex.Lock()
+	pendingChanges = pendingChanges[len(changes):]
+	pendingMutex.Unlock()
+
+	log.Printf("Replicated %d changes as segment %d (%d bytes)", len(changes), position, len(data))
+	return nil
+}
+
+// takeSnapshot uploads a verified copy of the local database as a snapshot at
+// the current position and compacts older replica objects. It is skipped while
+// there are unshipped changes, since those would otherwise be applied twice on
+// restore.
+func takeSnapshot(ctx context.Context) error {
+	snapshotPath := localDBPath + ".snapshot"
+	defer os.Remove(snapshotPath)
+
+	position, ok, err := buildSnapshot(ctx, snapshotPath)
+	if err != nil || !ok {
+		return err
+	}
+
+	// Never publish a snapshot that SQLite itself considers damaged
+	if err := checkIntegrity(ctx, snapshotPath); err != nil {
+		return fmt.Errorf("refusing to upload snapshot %d: %w", position, err)
+	}
+
+	f, err := os.Open(snapshotPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = blobStore.Put(ctx, snapshotKey(position), f, PutOptions{
+		ContentType: "application/x-sqlite3",
+		IfNotExists: true,
+	})
+	if isPreconditionFailed(err) {
+		// Another instance already took a snapshot at this position
+		snapshotPosition.Store(position)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	snapshotPosition.Store(position)
+	log.Printf("Uploaded database snapshot at position %d", position)
+
+	return compactReplica(ctx)
+}
+
+// buildSnapshot writes a self-contained copy of the local database to path
+// using VACUUM INTO, which reads from a single transaction and so includes
+// every commit still in the WAL. It returns the position the copy reflects, or
+// false if there were unshipped changes and no copy was made.
+func buildSnapshot(ctx context.Context, path string) (int64, bool, error) {
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+
+	if pendingChangeCount() > 0 {
+		return 0, false, nil
+	}
+
+	if err := vacuumInto(ctx, db, path); err != nil {
+		return 0, false, err
+	}
+
+	return dbGeneration.Load(), true, nil
+}
+
+// vacuumInto writes a compacted, self-contained copy of conn's database to path
+func vacuumInto(ctx context.Context, conn *sql.DB, path string) error {
+	// VACUUM INTO refuses to overwrite an existing file
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("failed to copy database: %w", err)
+	}
+	return nil
+}
+
+// checkIntegrity runs PRAGMA integrity_check against the database file at path
+func checkIntegrity(ctx context.Context, path string) error {
+	conn, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	rows, err := conn.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("failed to check database integrity: %w", err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return err
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to check database integrity: %w", err)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// compactReplica deletes snapshots older than the previous one and the
+// segments they cover. The previous snapshot is kept so an instance that
+// listed it just before a new snapshot appeared can still restore from it.
+func compactReplica(ctx context.Context) error {
+	snapshots, err := listReplica(ctx, replicaSnapshotPrefix)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) < 2 {
+		return nil
+	}
+	keep := snapshots[len(snapshots)-2]
+
+	for _, position := range snapshots {
+		if position < keep {
+			if err := blobStore.Delete(ctx, snapshotKey(position)); err != nil && !errors.Is(err, ErrBlobNotFound) {
+				return err
+			}
+		}
+	}
+
+	segments, err := listReplica(ctx, replicaSegmentPrefix)
+	if err != nil {
+		return err
+	}
+	for _, position := range segments {
+		if position <= keep {
+			if err := blobStore.Delete(ctx, segmentKey(position)); err != nil && !errors.Is(err, ErrBlobNotFound) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// restoreReplica rebuilds the database at dbPath from the latest snapshot and
+// the segments after it, and returns the position it reflects along with the
+// position of the snapshot it started from. If no snapshot exists yet, a
+// whole-file recipes.db uploaded before replication was introduced is used as
+// the starting point. ErrBlobNotFound is returned if the bucket holds neither.
+func restoreReplica(ctx context.Context, dbPath string) (position, snapshot int64, err error) {
+	snapshots, err := listReplica(ctx, replicaSnapshotPrefix)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	key := dbFileName
+	if len(snapshots) > 0 {
+		snapshot = snapshots[len(snapshots)-1]
+		key = snapshotKey(snapshot)
+	}
+	// A -wal file left by an earlier process would be applied on top of the
+	// downloaded snapshot
+	os.Remove(dbPath + "-wal")
+	os.Remove(dbPath + "-shm")
+
+	if err := downloadBlob(ctx, key, dbPath); err != nil {
+		return 0, 0, err
+	}
+	log.Printf("Downloaded database snapshot %s", key)
+
+	conn, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+
+	// Segments are written against the current schema, so migrate the
+	// snapshot before replaying them
+	if err := runMigrations(ctx, conn); err != nil {
+		return 0, 0, fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	segments, err := listReplica(ctx, replicaSegmentPrefix)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list segments: %w", err)
+	}
+
+	position = snapshot
+	for _, segmentPosition := range segments {
+		if segmentPosition <= position {
+			continue
+		}
+		if segmentPosition != position+1 {
+			return 0, 0, fmt.Errorf("replica segment %d is missing", position+1)
+		}
+		if err := applySegment(ctx, conn, segmentPosition); err != nil {
+			return 0, 0, fmt.Errorf("failed to apply segment %d: %w", segmentPosition, err)
+		}
+		position = segmentPosition
+	}
+
+	log.Printf("Restored database to position %d (snapshot %d + %d segments)", position, snapshot, position-snapshot)
+	return position, snapshot, nil
+}
+
+// applySegment downloads a segment and replays its statements in one transaction
+func applySegment(ctx context.Context, conn *sql.DB, position int64) error {
+	segment, err := fetchSegment(ctx, position)
+	if err != nil {
+		return err
+	}
+	return applySegmentChanges(ctx, conn, segment)
+}
+
+// fetchSegment downloads and decodes the segment at position
+func fetchSegment(ctx context.Context, position int64) (*replicaSegment, error) {
+	rc, _, err := blobStore.Get(ctx, segmentKey(position))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var segment replicaSegment
+	decoder := json.NewDecoder(rc)
+	decoder.UseNumber()
+	if err := decoder.Decode(&segment); err != nil {
+		return nil, fmt.Errorf("failed to decode segment: %w", err)
+	}
+	return &segment, nil
+}
+
+// applySegmentChanges replays a segment's statements in one transaction
+func applySegmentChanges(ctx context.Context, conn *sql.DB, segment *replicaSegment) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, change := range segment.Changes {
+		for _, stmt := range change.Statements {
+			if _, err := tx.ExecContext(ctx, stmt.SQL, fromReplicaArgs(stmt.Args)...); err != nil {
+				return fmt.Errorf("%s: %w", change.Description, err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// downloadBlob copies an object from blob storage to a local file
+func downloadBlob(ctx context.Context, key, dest string) error {
+	rc, _, err := blobStore.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
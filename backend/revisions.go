@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RecipeRevision is an immutable copy of a recipe's content as it was saved
+type RecipeRevision struct {
+	ID               int64     `json:"id"`
+	RecipeID         string    `json:"recipeId"`
+	Revision         int       `json:"revision"` // 1 for the first save, counting up
+	Title            string    `json:"title"`
+	Description      string    `json:"description"`
+	RecipeType       string    `json:"type"`
+	Cuisine          string    `json:"cuisine"`
+	Servings         *int      `json:"servings"`
+	Yield            string    `json:"yield"`
+	PrepTimeMinutes  *int      `json:"prepTimeMinutes"`
+	CookTimeMinutes  *int      `json:"cookTimeMinutes"`
+	TotalTimeMinutes *int      `json:"totalTimeMinutes"`
+	Glass            string    `json:"glass"`
+	Garnish          string    `json:"garnish"`
+	Preparation      string    `json:"preparation"`
+	Ice              string    `json:"ice"`
+	BaseSpirit       string    `json:"baseSpirit"`
+	Ingredients      string    `json:"ingredients"`
+	Method           string    `json:"method"`
+	Notes            string    `json:"notes"`
+	Sources          string    `json:"sources"`
+	IconID           *int64    `json:"iconId"`
+	Tags             []string  `json:"tags"`
+	RestoredFrom     *int      `json:"restoredFrom"`    // Revision this one restored (nullable)
+	CreatedByUserID  *string   `json:"createdByUserId"` // Firebase UID of whoever saved it (nullable)
+	CreatedByName    *string   `json:"createdByName"`   // Display name at the time (nullable)
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+// RevisionChange is one field that differs between two revisions
+type RevisionChange struct {
+	Field   string      `json:"field"` // JSON name of the recipe field, e.g. "ingredients"
+	From    interface{} `json:"from"`
+	To      interface{} `json:"to"`
+	Added   []string    `json:"added,omitempty"`   // Tags only
+	Removed []string    `json:"removed,omitempty"` // Tags only
+}
+
+// RevisionDiff lists the fields changed between two revisions of a recipe
+type RevisionDiff struct {
+	RecipeID string           `json:"recipeId"`
+	From     int              `json:"from"`
+	To       int              `json:"to"`
+	Changes  []RevisionChange `json:"changes"`
+}
+
+var errRevisionNotFound = errors.New("revision not found")
+
+// revisionContent copies a recipe's current content, with its tags as a JSON
+// array. It is used with INSERT ... SELECT so the copy is taken, and replayed
+// from a replica segment, entirely in SQL.
+const revisionContent = `
+	r.id, r.title, COALESCE(r.description, ''), COALESCE(r.recipe_type, ''), COALESCE(r.cuisine, ''), r.servings,
+	r.yield, r.prep_time_minutes, r.cook_time_minutes, r.total_time_minutes,
+	r.glass, r.garnish, r.preparation, r.ice, r.base_spirit,
+	COALESCE(r.ingredients, ''), COALESCE(r.method, ''), COALESCE(r.notes, ''), COALESCE(r.sources, ''), r.icon_id,
+	(SELECT json_group_array(name) FROM (
+		SELECT t.name FROM tags t JOIN recipe_tags rt ON t.id = rt.tag_id
+		WHERE rt.recipe_id = r.id ORDER BY t.name
+	))
+`
+
+const revisionColumns = `
+	recipe_id, title, description, recipe_type, cuisine, servings,
+	yield, prep_time_minutes, cook_time_minutes, total_time_minutes,
+	glass, garnish, preparation, ice, base_spirit,
+	ingredients, method, notes, sources, icon_id, tags,
+	revision, restored_from, created_by_user_id, created_by_name, created_at
+`
+
+// recordRevision stores the recipe's current content as its next revision.
+// The caller must hold dbMutex and be inside applyChange.
+func recordRevision(ctx context.Context, recipeID string, userID, userName *string, at time.Time, restoredFrom *int) error {
+	query := `
+		INSERT INTO recipe_revisions (` + revisionColumns + `)
+		SELECT ` + revisionContent + `,
+			(SELECT COALESCE(MAX(revision), 0) + 1 FROM recipe_revisions WHERE recipe_id = r.id),
+			?, ?, ?, ?
+		FROM recipes r
+		WHERE r.id = ?
+	`
+	_, err := execWrite(ctx, query, restoredFrom, userID, userName, at, recipeID)
+	return err
+}
+
+// recordBaselineRevision stores a recipe's current content as revision 1 if
+// it has no revisions yet, crediting its creator. Recipes saved before
+// revisions existed get one on their first update, so the old text is kept.
+func recordBaselineRevision(ctx context.Context, recipeID string) error {
+	query := `
+		INSERT INTO recipe_revisions (` + revisionColumns + `)
+		SELECT ` + revisionContent + `,
+			1, NULL, r.created_by_user_id, r.created_by_name, r.updated_at
+		FROM recipes r
+		WHERE r.id = ?
+		  AND NOT EXISTS (SELECT 1 FROM recipe_revisions WHERE recipe_id = r.id)
+	`
+	_, err := execWrite(ctx, query, recipeID)
+	return err
+}
+
+const selectRevision = `
+	SELECT id, recipe_id, revision, title, description, recipe_type, cuisine, servings,
+	       yield, prep_time_minutes, cook_time_minutes, total_time_minutes,
+	       glass, garnish, preparation, ice, base_spirit,
+	       ingredients, method, notes, sources, icon_id, tags,
+	       restored_from, created_by_user_id, created_by_name, created_at
+	FROM recipe_revisions
+`
+
+func scanRevision(row interface{ Scan(...interface{}) error }) (*RecipeRevision, error) {
+	var rev RecipeRevision
+	var tags string
+	err := row.Scan(
+		&rev.ID, &rev.RecipeID, &rev.Revision, &rev.Title, &rev.Description, &rev.RecipeType, &rev.Cuisine, &rev.Servings,
+		&rev.Yield, &rev.PrepTimeMinutes, &rev.CookTimeMinutes, &rev.TotalTimeMinutes,
+		&rev.Glass, &rev.Garnish, &rev.Preparation, &rev.Ice, &rev.BaseSpirit,
+		&rev.Ingredients, &rev.Method, &rev.Notes, &rev.Sources, &rev.IconID, &tags,
+		&rev.RestoredFrom, &rev.CreatedByUserID, &rev.CreatedByName, &rev.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(tags), &rev.Tags); err != nil {
+		return nil, err
+	}
+	return &rev, nil
+}
+
+// GetRecipeRevisions returns every revision of a recipe, newest first
+func GetRecipeRevisions(ctx context.Context, recipeID string) ([]RecipeRevision, error) {
+	rlockDB(ctx)
+	defer dbMutex.RUnlock()
+
+	rows, err := db.QueryContext(ctx, selectRevision+` WHERE recipe_id = ? ORDER BY revision DESC`, recipeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []RecipeRevision{}
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, *rev)
+	}
+
+	return revisions, rows.Err()
+}
+
+// GetRecipeRevision returns one revision of a recipe, or nil if it doesn't exist
+func GetRecipeRevision(ctx context.Context, recipeID string, revision int) (*RecipeRevision, error) {
+	rlockDB(ctx)
+	defer dbMutex.RUnlock()
+
+	return getRecipeRevision(ctx, recipeID, revision)
+}
+
+func getRecipeRevision(ctx context.Context, recipeID string, revision int) (*RecipeRevision, error) {
+	row := db.QueryRowContext(ctx, selectRevision+` WHERE recipe_id = ? AND revision = ?`, recipeID, revision)
+	rev, err := scanRevision(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return rev, err
+}
+
+// getLatestRevisionNumber returns the newest revision number of a recipe, or 0 if it has none
+func getLatestRevisionNumber(ctx context.Context, recipeID string) (int, error) {
+	rlockDB(ctx)
+	defer dbMutex.RUnlock()
+
+	var latest int
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(revision), 0) FROM recipe_revisions WHERE recipe_id = ?`, recipeID).Scan(&latest)
+	return latest, err
+}
+
+// RestoreRecipeRevision saves an old revision's content as the recipe's
+// current content. This adds a new revision; the history is never rewritten.
+func RestoreRecipeRevision(ctx context.Context, recipeID string, revision int, userID string, userName *string) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	rev, err := getRecipeRevision(ctx, recipeID, revision)
+	if err != nil {
+		return err
+	}
+	if rev == nil {
+		return errRevisionNotFound
+	}
+
+	recipe := Recipe{
+		ID:               recipeID,
+		Title:            rev.Title,
+		Description:      rev.Description,
+		RecipeType:       rev.RecipeType,
+		Cuisine:          rev.Cuisine,
+		Servings:         rev.Servings,
+		Yield:            rev.Yield,
+		PrepTimeMinutes:  rev.PrepTimeMinutes,
+		CookTimeMinutes:  rev.CookTimeMinutes,
+		TotalTimeMinutes: rev.TotalTimeMinutes,
+		Glass:            rev.Glass,
+		Garnish:          rev.Garnish,
+		Preparation:      rev.Preparation,
+		Ice:              rev.Ice,
+		BaseSpirit:       rev.BaseSpirit,
+		Ingredients:      rev.Ingredients,
+		Method:           rev.Method,
+		Notes:            rev.Notes,
+		Sources:          rev.Sources,
+		IconID:           rev.IconID,
+		Tags:             rev.Tags,
+		UpdatedAt:        time.Now(),
+	}
+	return updateRecipe(ctx, recipe, &userID, userName, &revision)
+}
+
+// diffRevisions returns the fields that differ between two revisions
+func diffRevisions(from, to *RecipeRevision) []RevisionChange {
+	changes := []RevisionChange{}
+
+	textFields := []struct {
+		name     string
+		from, to string
+	}{
+		{"title", from.Title, to.Title},
+		{"description", from.Description, to.Description},
+		{"type", from.RecipeType, to.RecipeType},
+		{"cuisine", from.Cuisine, to.Cuisine},
+		{"yield", from.Yield, to.Yield},
+		{"glass", from.Glass, to.Glass},
+		{"garnish", from.Garnish, to.Garnish},
+		{"preparation", from.Preparation, to.Preparation},
+		{"ice", from.Ice, to.Ice},
+		{"baseSpirit", from.BaseSpirit, to.BaseSpirit},
+		{"ingredients", from.Ingredients, to.Ingredients},
+		{"method", from.Method, to.Method},
+		{"notes", from.Notes, to.Notes},
+		{"sources", from.Sources, to.Sources},
+	}
+	for _, f := range textFields {
+		if f.from != f.to {
+			changes = append(changes, RevisionChange{Field: f.name, From: f.from, To: f.to})
+		}
+	}
+
+	if (from.IconID == nil) != (to.IconID == nil) || (from.IconID != nil && *from.IconID != *to.IconID) {
+		changes = append(changes, RevisionChange{Field: "iconId", From: from.IconID, To: to.IconID})
+	}
+
+	numberFields := []struct {
+		name     string
+		from, to *int
+	}{
+		{"servings", from.Servings, to.Servings},
+		{"prepTimeMinutes", from.PrepTimeMinutes, to.PrepTimeMinutes},
+		{"cookTimeMinutes", from.CookTimeMinutes, to.CookTimeMinutes},
+		{"totalTimeMinutes", from.TotalTimeMinutes, to.TotalTimeMinutes},
+	}
+	for _, f := range numberFields {
+		if (f.from == nil) != (f.to == nil) || (f.from != nil && *f.from != *f.to) {
+			changes = append(changes, RevisionChange{Field: f.name, From: f.from, To: f.to})
+		}
+	}
+
+	added := tagsMissingFrom(to.Tags, from.Tags)
+	removed := tagsMissingFrom(from.Tags, to.Tags)
+	if len(added) > 0 || len(removed) > 0 {
+		changes = append(changes, RevisionChange{Field: "tags", From: from.Tags, To: to.Tags, Added: added, Removed: removed})
+	}
+
+	return changes
+}
+
+// tagsMissingFrom returns the tags in tags that aren't in other
+func tagsMissingFrom(tags, other []string) []string {
+	seen := make(map[string]bool, len(other))
+	for _, tag := range other {
+		seen[tag] = true
+	}
+
+	var missing []string
+	for _, tag := range tags {
+		if !seen[tag] {
+			missing = append(missing, tag)
+		}
+	}
+	return missing
+}
+
+// recipeRevisionsHandler serves /recipes/{id}/revisions and the paths below it:
+//
+//	GET  /recipes/{id}/revisions                   list revisions, newest first
+//	GET  /recipes/{id}/revisions/{n}               get one revision
+//	GET  /recipes/{id}/revisions/diff?from=&to=    field-level diff (to defaults to the latest)
+//	POST /recipes/{id}/revisions/{n}/restore       save revision n as a new revision
+func recipeRevisionsHandler(w http.ResponseWriter, r *http.Request, recipeID, subPath string) {
+	switch {
+	case subPath == "":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Public read - no auth required
+		revisions, err := GetRecipeRevisions(r.Context(), recipeID)
+		if err != nil {
+			log.Printf("Error getting recipe revisions: %v", err)
+			http.Error(w, "Failed to get recipe revisions", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(revisions)
+
+	case subPath == "diff":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		revisionDiffHandler(w, r, recipeID)
+
+	case strings.HasSuffix(subPath, "/restore"):
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		revision, err := strconv.Atoi(strings.TrimSuffix(subPath, "/restore"))
+		if err != nil {
+			http.Error(w, "Invalid revision number", http.StatusBadRequest)
+			return
+		}
+
+		// Auth required for writes
+		userID, err := authenticateRequest(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		log.Printf("Restoring recipe %s to revision %d - authenticated user: %s", recipeID, revision, userID)
+
+		err = RestoreRecipeRevision(r.Context(), recipeID, revision, userID, userDisplayName(r.Context(), userID))
+		if errors.Is(err, errRevisionNotFound) {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, errRecipeNotFound) {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error restoring recipe revision: %v", err)
+			http.Error(w, "Failed to restore recipe revision", http.StatusInternalServerError)
+			return
+		}
+
+		recipe, err := GetRecipeByID(r.Context(), recipeID)
+		if err != nil || recipe == nil {
+			log.Printf("Error getting restored recipe: %v", err)
+			http.Error(w, "Failed to get restored recipe", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(recipe)
+
+	default:
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		revision, err := strconv.Atoi(subPath)
+		if err != nil {
+			http.Error(w, "Invalid revision number", http.StatusBadRequest)
+			return
+		}
+
+		// Public read - no auth required
+		rev, err := GetRecipeRevision(r.Context(), recipeID, revision)
+		if err != nil {
+			log.Printf("Error getting recipe revision: %v", err)
+			http.Error(w, "Failed to get recipe revision", http.StatusInternalServerError)
+			return
+		}
+		if rev == nil {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(rev)
+	}
+}
+
+// revisionDiffHandler serves GET /recipes/{id}/revisions/diff?from=&to=
+func revisionDiffHandler(w http.ResponseWriter, r *http.Request, recipeID string) {
+	from, err := strconv.Atoi(r.URL.Query().Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid or missing from revision", http.StatusBadRequest)
+		return
+	}
+
+	var to int
+	if toParam := r.URL.Query().Get("to"); toParam != "" {
+		to, err = strconv.Atoi(toParam)
+		if err != nil {
+			http.Error(w, "Invalid to revision", http.StatusBadRequest)
+			return
+		}
+	} else {
+		to, err = getLatestRevisionNumber(r.Context(), recipeID)
+		if err != nil {
+			log.Printf("Error getting latest recipe revision: %v", err)
+			http.Error(w, "Failed to get recipe revisions", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	fromRev, err := GetRecipeRevision(r.Context(), recipeID, from)
+	if err != nil {
+		log.Printf("Error getting recipe revision: %v", err)
+		http.Error(w, "Failed to get recipe revision", http.StatusInternalServerError)
+		return
+	}
+	toRev, err := GetRecipeRevision(r.Context(), recipeID, to)
+	if err != nil {
+		log.Printf("Error getting recipe revision: %v", err)
+		http.Error(w, "Failed to get recipe revision", http.StatusInternalServerError)
+		return
+	}
+	if fromRev == nil || toRev == nil {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(RevisionDiff{
+		RecipeID: recipeID,
+		From:     from,
+		To:       to,
+		Changes:  diffRevisions(fromRev, toRev),
+	})
+}
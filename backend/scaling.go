@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// kitchenFractions are the fractions scaled quantities are rounded to
+var kitchenFractions = []struct {
+	value float64
+	text  string
+}{
+	{0, ""}, {1.0 / 8, "1/8"}, {1.0 / 4, "1/4"}, {1.0 / 3, "1/3"}, {1.0 / 2, "1/2"},
+	{2.0 / 3, "2/3"}, {3.0 / 4, "3/4"}, {1, ""},
+}
+
+// countableUnits are units written as ordinary words, which take a plural
+// when there is more than one ("2 cloves", "3 cans")
+var countableUnits = map[string]bool{
+	"cup": true, "part": true, "pinch": true, "dash": true, "splash": true, "drop": true,
+	"clove": true, "can": true, "tin": true, "bunch": true, "handful": true, "slice": true,
+	"sprig": true, "stalk": true, "piece": true, "cube": true, "head": true, "sheet": true, "packet": true,
+}
+
+// scaleFactor reads the scale a recipe was asked for: ?scale=2 (or 1/2), or
+// ?servings=6 for recipes that say how many servings they make. It returns 1
+// if neither is given.
+func scaleFactor(query url.Values, recipe *Recipe) (float64, error) {
+	scale, servings := query.Get("scale"), query.Get("servings")
+
+	switch {
+	case scale != "" && servings != "":
+		return 0, errors.New("use either scale or servings, not both")
+
+	case scale != "":
+		factor, ok := parseNumber(scale)
+		if !ok || math.IsNaN(factor) || factor <= 0 || math.IsInf(factor, 0) {
+			return 0, errors.New("scale must be a positive number")
+		}
+		return factor, nil
+
+	case servings != "":
+		target, err := strconv.Atoi(servings)
+		if err != nil || target <= 0 {
+			return 0, errors.New("servings must be a positive whole number")
+		}
+		if recipe.Servings == nil || *recipe.Servings <= 0 {
+			return 0, errors.New("recipe doesn't say how many servings it makes; use scale instead")
+		}
+		return float64(target) / float64(*recipe.Servings), nil
+	}
+
+	return 1, nil
+}
+
+// scaleRecipe multiplies the quantities in a recipe's ingredients by factor.
+// Both the markdown and the structured list are rewritten; lines without a
+// quantity, such as "salt to taste", are left as they are.
+func scaleRecipe(recipe *Recipe, factor float64) {
+	if factor == 1 {
+		return
+	}
+
+	lines := strings.Split(recipe.Ingredients, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		text := stripListMarker(trimmed)
+		start := strings.Index(line, text)
+		lines[i] = line[:start] + scaleIngredientLine(text, factor) + line[start+len(text):]
+	}
+	recipe.Ingredients = strings.Join(lines, "\n")
+	recipe.IngredientList = parseIngredients(recipe.Ingredients)
+
+	if recipe.Servings != nil {
+		servings := int(math.Max(1, math.Round(float64(*recipe.Servings)*factor)))
+		recipe.Servings = &servings
+	}
+}
+
+// scaleIngredientLine multiplies the quantity of a single ingredient, along
+// with any alternative measure ("1 cup / 250 ml milk")
+func scaleIngredientLine(text string, factor float64) string {
+	ingredient := parseIngredientLine(text)
+	if !ingredient.Parsed || ingredient.Quantity == nil {
+		return text
+	}
+
+	quantity, rest := formatScaled(*ingredient.Quantity, ingredient.QuantityMax, factor, ingredient.Unit), ""
+	if _, _, afterQuantity, ok := parseQuantity(text); ok {
+		rest = afterQuantity
+	} else if _, afterArticle, found := strings.Cut(text, " "); found {
+		// "a pinch of salt"
+		rest = " " + afterArticle
+	} else {
+		return text
+	}
+
+	// Skip "heaped" in "2 heaped tsp cumin"
+	lead := ""
+	if word, afterWord, found := strings.Cut(strings.TrimLeft(rest, " "), " "); found && unitModifiers[strings.ToLower(word)] {
+		lead = rest[:len(rest)-len(afterWord)]
+		rest = afterWord
+	}
+
+	if unit, afterUnit := parseUnit(rest); unit != "" && unit == ingredient.Unit {
+		written := rest[:len(rest)-len(afterUnit)]
+		word := strings.TrimLeft(written, " ")
+		if countableUnits[unit] && (strings.EqualFold(word, unit) || strings.EqualFold(word, unitWord(unit, 2))) {
+			largest, _ := parseNumber(quantity[strings.LastIndex(quantity, "-")+1:])
+			written = written[:len(written)-len(word)] + unitWord(unit, largest)
+		}
+		lead += written
+		rest = afterUnit
+
+		if alternative, _, ok := cutAlternativeMeasure(rest); ok {
+			slash := strings.Index(rest, alternative)
+			if altQuantity, altMax, altUnit, ok := parseQuantity(alternative); ok {
+				unit, _ := parseUnit(altUnit)
+				lead += rest[:slash] + formatScaled(altQuantity, altMax, factor, unit)
+				rest = rest[slash+len(alternative)-len(altUnit):]
+			}
+		}
+	}
+
+	return quantity + lead + rest
+}
+
+// formatScaled formats a scaled quantity or range for the given unit
+func formatScaled(quantity float64, quantityMax *float64, factor float64, unit string) string {
+	text := formatQuantity(quantity*factor, unit)
+	if quantityMax != nil {
+		text += "-" + formatQuantity(*quantityMax*factor, unit)
+	}
+	return text
+}
+
+// formatQuantity rounds a quantity to something you can measure: whole grams
+// and millilitres (tenths below 10), two decimals of kilos and litres, whole
+// pinches, and otherwise the nearest kitchen fraction ("1 1/2", "2/3")
+func formatQuantity(quantity float64, unit string) string {
+	switch unit {
+	case "g", "ml":
+		if quantity >= 10 {
+			return strconv.FormatFloat(math.Round(quantity), 'f', -1, 64)
+		}
+		return strconv.FormatFloat(math.Round(quantity*10)/10, 'f', -1, 64)
+	case "kg", "l":
+		return strconv.FormatFloat(math.Round(quantity*100)/100, 'f', -1, 64)
+	case "pinch", "dash", "splash", "drop":
+		// Nobody measures half a pinch
+		return strconv.FormatFloat(math.Max(1, math.Round(quantity)), 'f', -1, 64)
+	}
+
+	if quantity >= 10 {
+		return strconv.FormatFloat(math.Round(quantity), 'f', -1, 64)
+	}
+
+	whole := math.Floor(quantity)
+	nearest := kitchenFractions[0]
+	for _, fraction := range kitchenFractions {
+		if math.Abs(quantity-whole-fraction.value) < math.Abs(quantity-whole-nearest.value) {
+			nearest = fraction
+		}
+	}
+	whole += math.Floor(nearest.value)
+
+	switch {
+	case whole == 0 && nearest.text == "":
+		// Never round an ingredient away entirely
+		return kitchenFractions[1].text
+	case whole == 0:
+		return nearest.text
+	case nearest.text == "":
+		return fmt.Sprint(whole)
+	default:
+		return fmt.Sprintf("%v %s", whole, nearest.text)
+	}
+}
+
+// unitWord returns a countable unit in the singular or plural to suit quantity
+func unitWord(unit string, quantity float64) string {
+	if quantity <= 1 {
+		return unit
+	}
+	if strings.HasSuffix(unit, "sh") || strings.HasSuffix(unit, "ch") {
+		return unit + "es"
+	}
+	return unit + "s"
+}
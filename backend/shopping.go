@@ -0,0 +1,916 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// ShoppingList is what to buy for a set of recipes, with like ingredients
+// merged. It belongs to the user who made it and the users it is shared with.
+type ShoppingList struct {
+	ID              string               `json:"id"` // UUID
+	Name            string               `json:"name"`
+	Recipes         []ShoppingListRecipe `json:"recipes"`    // Recipes the list was made from
+	Items           []ShoppingListItem   `json:"items"`      // Grouped by store section
+	SharedWith      []string             `json:"sharedWith"` // Firebase UIDs of the users it is shared with
+	CreatedByUserID string               `json:"createdByUserId"`
+	CreatedAt       time.Time            `json:"createdAt"`
+	UpdatedAt       time.Time            `json:"updatedAt"`
+}
+
+// ShoppingListRecipe is a recipe a shopping list was made from
+type ShoppingListRecipe struct {
+	RecipeID string  `json:"recipeId"`
+	Title    string  `json:"title"` // Title when the list was made
+	Scale    float64 `json:"scale"`
+}
+
+// ShoppingListItem is one thing to buy
+type ShoppingListItem struct {
+	ID       int64    `json:"id"`
+	Section  string   `json:"section"` // Store section, e.g. "Produce"
+	Name     string   `json:"name"`
+	Quantity *float64 `json:"quantity"` // Nullable: "salt" has none
+	Unit     string   `json:"unit"`
+	Text     string   `json:"text"` // e.g. "375 g flour"
+	Checked  bool     `json:"checked"`
+}
+
+// shoppingListRequest is the body of POST /shopping-lists
+type shoppingListRequest struct {
+	Name    string `json:"name"`
+	Recipes []struct {
+		RecipeID string  `json:"recipeId"`
+		Scale    float64 `json:"scale"` // Defaults to 1
+	} `json:"recipes"`
+}
+
+var (
+	errShoppingListNotFound     = errors.New("shopping list not found")
+	errShoppingListItemNotFound = errors.New("shopping list item not found")
+	errShoppingListRecipe       = errors.New("recipe not found")
+)
+
+// shoppingSections are the store sections, in the order a shop is usually walked
+var shoppingSections = []string{
+	"Produce", "Meat & Seafood", "Dairy & Eggs", "Bakery", "Pantry", "Spices & Seasonings", "Frozen", "Drinks", "Other",
+}
+
+// sectionKeywords map words in an item's (singular) name to its store
+// section. The longest match wins, so "coconut milk" is Pantry, not Dairy.
+var sectionKeywords = map[string]string{
+	"onion": "Produce", "spring onion": "Produce", "shallot": "Produce", "leek": "Produce", "garlic": "Produce",
+	"ginger": "Produce", "chilli": "Produce", "chili": "Produce", "tomato": "Produce", "potato": "Produce",
+	"sweet potato": "Produce", "carrot": "Produce", "celery": "Produce", "lettuce": "Produce", "spinach": "Produce",
+	"kale": "Produce", "cabbage": "Produce", "broccoli": "Produce", "cauliflower": "Produce", "zucchini": "Produce",
+	"eggplant": "Produce", "cucumber": "Produce", "mushroom": "Produce", "capsicum": "Produce", "bell pepper": "Produce",
+	"pumpkin": "Produce", "corn": "Produce", "avocado": "Produce", "lemon": "Produce", "lime": "Produce",
+	"lemon juice": "Produce", "lime juice": "Produce", "orange": "Produce", "apple": "Produce", "banana": "Produce",
+	"berry": "Produce", "mango": "Produce", "green bean": "Produce", "basil": "Produce", "parsley": "Produce",
+	"coriander": "Produce", "cilantro": "Produce", "mint": "Produce", "dill": "Produce", "rosemary": "Produce", "thyme": "Produce",
+	"chicken": "Meat & Seafood", "beef": "Meat & Seafood", "pork": "Meat & Seafood", "lamb": "Meat & Seafood",
+	"mince": "Meat & Seafood", "steak": "Meat & Seafood", "bacon": "Meat & Seafood", "ham": "Meat & Seafood",
+	"sausage": "Meat & Seafood", "chorizo": "Meat & Seafood", "turkey": "Meat & Seafood", "fish": "Meat & Seafood",
+	"salmon": "Meat & Seafood", "prawn": "Meat & Seafood", "shrimp": "Meat & Seafood",
+	"milk": "Dairy & Eggs", "butter": "Dairy & Eggs", "cream": "Dairy & Eggs", "sour cream": "Dairy & Eggs",
+	"cheese": "Dairy & Eggs", "cream cheese": "Dairy & Eggs", "parmesan": "Dairy & Eggs", "mozzarella": "Dairy & Eggs",
+	"feta": "Dairy & Eggs", "ricotta": "Dairy & Eggs", "yoghurt": "Dairy & Eggs", "yogurt": "Dairy & Eggs",
+	"buttermilk": "Dairy & Eggs", "egg": "Dairy & Eggs",
+	"bread": "Bakery", "tortilla": "Bakery", "pita": "Bakery", "baguette": "Bakery", "bun": "Bakery", "wrap": "Bakery",
+	"flour": "Pantry", "sugar": "Pantry", "rice": "Pantry", "pasta": "Pantry", "spaghetti": "Pantry", "noodle": "Pantry",
+	"oil": "Pantry", "vinegar": "Pantry", "soy sauce": "Pantry", "fish sauce": "Pantry", "sauce": "Pantry",
+	"tomato sauce": "Pantry", "stock": "Pantry", "chicken stock": "Pantry", "beef stock": "Pantry",
+	"vegetable stock": "Pantry", "broth": "Pantry", "chicken broth": "Pantry", "beef broth": "Pantry",
+	"honey": "Pantry", "maple syrup": "Pantry", "oat": "Pantry",
+	"tinned tomato": "Pantry", "canned tomato": "Pantry", "tomato paste": "Pantry", "passata": "Pantry",
+	"coconut milk": "Pantry", "coconut cream": "Pantry", "peanut butter": "Pantry", "baking powder": "Pantry",
+	"baking soda": "Pantry", "bicarbonate": "Pantry", "cornflour": "Pantry", "cornstarch": "Pantry", "yeast": "Pantry",
+	"breadcrumb": "Pantry", "panko": "Pantry", "lentil": "Pantry", "chickpea": "Pantry", "bean": "Pantry",
+	"almond": "Pantry", "walnut": "Pantry", "cashew": "Pantry", "peanut": "Pantry", "cocoa": "Pantry",
+	"chocolate": "Pantry", "vanilla": "Pantry", "mustard": "Pantry", "mayonnaise": "Pantry", "ketchup": "Pantry",
+	"salt": "Spices & Seasonings", "pepper": "Spices & Seasonings", "black pepper": "Spices & Seasonings",
+	"cumin": "Spices & Seasonings", "paprika": "Spices & Seasonings", "cinnamon": "Spices & Seasonings",
+	"nutmeg": "Spices & Seasonings", "oregano": "Spices & Seasonings", "turmeric": "Spices & Seasonings",
+	"garam masala": "Spices & Seasonings", "curry powder": "Spices & Seasonings", "chilli flake": "Spices & Seasonings",
+	"chilli powder": "Spices & Seasonings", "cardamom": "Spices & Seasonings", "bay leaf": "Spices & Seasonings",
+	"clove": "Spices & Seasonings", "garlic powder": "Spices & Seasonings", "onion powder": "Spices & Seasonings",
+	"frozen": "Frozen", "ice cream": "Frozen", "ice": "Frozen",
+	"gin": "Drinks", "vodka": "Drinks", "rum": "Drinks", "whisky": "Drinks", "whiskey": "Drinks", "tequila": "Drinks",
+	"vermouth": "Drinks", "bitter": "Drinks", "campari": "Drinks", "liqueur": "Drinks", "wine": "Drinks",
+	"beer": "Drinks", "tonic": "Drinks", "soda water": "Drinks",
+}
+
+// spoonMillilitres are the volumes of the units that are the same in every
+// system, so they can be added to cups and millilitres
+var spoonMillilitres = map[string]float64{"tsp": 5, "tbsp": 15}
+
+// shoppingNameSuffixes say how an ingredient is used, not what to buy
+var shoppingNameSuffixes = []string{"to taste", "to serve", "for serving", "to garnish", "for garnish", "for frying", "optional"}
+
+// shoppingTotal adds up one item across recipes
+type shoppingTotal struct {
+	item      ShoppingListItem
+	key       string  // Normalised name
+	dimension string  // "weight", "volume", a unit such as "clove", "" for a count, or "none"
+	amount    float64 // Grams for weight, millilitres for volume, otherwise in the unit
+	size      string  // Size of each can or packet, e.g. "400 g"
+	metric    bool    // Show weights and volumes in metric
+	weighed   bool    // Some recipe gave a weight rather than cups
+	poured    bool    // Some recipe gave fluid ounces, e.g. "2 oz gin"
+}
+
+// mergeIngredients turns the ingredients of several recipes into a shopping
+// list, adding up like ingredients in compatible units
+func mergeIngredients(ingredients []Ingredient) []ShoppingListItem {
+	totals := make(map[string]*shoppingTotal)
+	var order []*shoppingTotal
+	quantified := make(map[string]bool)
+
+	for _, ing := range ingredients {
+		if !ing.Parsed {
+			ing = Ingredient{Name: ing.Text}
+		}
+		ing.Name = shoppingName(ing.Name)
+
+		// "2 garlic cloves" is 2 cloves of garlic
+		if i := strings.LastIndex(ing.Name, " "); ing.Unit == "" && ing.Quantity != nil && i > 0 {
+			if unit, rest := parseUnit(ing.Name[i+1:]); countableUnits[unit] && rest == "" {
+				ing.Unit, ing.Name = unit, ing.Name[:i]
+			}
+		}
+
+		// "1 (400g) can tomatoes" is one 400 g can of tomatoes
+		size := packSize(ing.Preparation)
+		if unit, rest := parseUnit(ing.Name); size != "" && ing.Unit == "" && countableUnits[unit] && strings.TrimSpace(rest) != "" {
+			ing.Unit, ing.Name = unit, strings.TrimSpace(rest)
+		}
+		if !countableUnits[ing.Unit] {
+			size = ""
+		}
+
+		key := shoppingItemKey(ing.Name)
+		if key == "" {
+			continue
+		}
+
+		dimension, amount, metric := shoppingMeasure(ing)
+		if dimension != "none" {
+			quantified[key] = true
+		}
+
+		total, ok := totals[key+"|"+dimension+"|"+size]
+		if !ok {
+			section := shoppingSection(key)
+			if ing.Unit == "can" || ing.Unit == "tin" {
+				// "(400g) cans tomatoes" are tinned, not fresh
+				section = "Pantry"
+			}
+			total = &shoppingTotal{
+				item:      ShoppingListItem{Section: section, Name: ing.Name},
+				key:       key,
+				dimension: dimension,
+				size:      size,
+			}
+			totals[key+"|"+dimension+"|"+size] = total
+			order = append(order, total)
+		}
+		total.amount += amount
+		// Cups added to grams are shown in grams
+		total.metric = total.metric || metric
+		if measure, ok := ingredientMeasure(ing.Unit, ing.Name, false); ok && measure.weight {
+			total.weighed = true
+		}
+		if ing.Unit == "fl oz" || (ing.Unit == "oz" && dimension == "volume") {
+			total.poured = true
+		}
+	}
+
+	items := []ShoppingListItem{}
+	for _, total := range order {
+		// "salt to taste" adds nothing to another recipe's "1 tsp salt"
+		if total.dimension == "none" && quantified[total.key] {
+			continue
+		}
+		items = append(items, total.finish())
+	}
+
+	sortShoppingItems(items)
+	return items
+}
+
+// shoppingMeasure works out what an ingredient's quantity measures and how
+// much of it there is, in grams, millilitres or its own unit
+func shoppingMeasure(ing Ingredient) (string, float64, bool) {
+	if !ing.Parsed || ing.Quantity == nil {
+		return "none", 0, false
+	}
+
+	// Buy enough for the top of a range
+	quantity := *ing.Quantity
+	if ing.QuantityMax != nil {
+		quantity = *ing.QuantityMax
+	}
+
+	// "2 oz gin" and "30 ml gin" are both volumes
+	measure, ok := ingredientMeasure(ing.Unit, ing.Name, false)
+	if millilitres, spoon := spoonMillilitres[ing.Unit]; spoon {
+		measure, ok = unitMeasure{base: millilitres}, true
+	}
+	if !ok {
+		return ing.Unit, quantity, false
+	}
+
+	base := quantity * measure.base
+	if measure.weight {
+		return "weight", base, measure.metric
+	}
+	// "2 cups flour" and "100 g flour" are the same thing. Spoonfuls are
+	// left as volumes, since "1/4 oz salt" is no help in a shop.
+	if density, ok := ingredientDensity(ing.Name); ok && ing.Unit != "tsp" && ing.Unit != "tbsp" {
+		return "weight", base / cupMillilitres * density, measure.metric
+	}
+	return "volume", base, measure.metric
+}
+
+// finish formats the total as an item. Weights and volumes are in metric if
+// any recipe measured the item in metric units, otherwise in imperial, with
+// liquids poured in fluid ounces kept in fluid ounces.
+func (t *shoppingTotal) finish() ShoppingListItem {
+	item := t.item
+	if t.dimension == "none" {
+		item.Text = item.Name
+		return item
+	}
+
+	// Flour that every recipe measured in cups stays in cups
+	amount, dimension := t.amount, t.dimension
+	if density, ok := ingredientDensity(t.item.Name); ok && dimension == "weight" && !t.weighed {
+		amount, dimension = amount/density*cupMillilitres, "volume"
+	}
+
+	var value float64
+	var unit string
+	switch {
+	case dimension == "weight" && t.metric:
+		value, unit = metricWeight(amount)
+	case dimension == "weight":
+		value, unit = imperialWeight(amount)
+	case dimension == "volume" && t.metric:
+		value, unit = metricVolume(amount)
+	case dimension == "volume" && t.poured:
+		value, unit = amount/fluidOunceMillilitres, "fl oz"
+	case dimension == "volume":
+		value, unit = imperialVolume(amount)
+	default:
+		value, unit = amount, dimension
+	}
+
+	quantityText := formatConverted(value, unit)
+	quantity, _ := parseNumber(quantityText)
+	item.Quantity = &quantity
+	item.Unit = unit
+
+	unitText := unit
+	if countableUnits[unit] {
+		unitText = unitWord(unit, quantity)
+	}
+	if t.size != "" {
+		unitText = "(" + t.size + ") " + unitText
+	}
+	item.Text = strings.Join(strings.Fields(quantityText+" "+unitText+" "+item.Name), " ")
+	return item
+}
+
+// packSize reads the size of each can or packet from an ingredient's notes,
+// e.g. "400g" in "1 (400g) can tomatoes", and returns it as "400 g"
+func packSize(preparation string) string {
+	for _, note := range strings.Split(preparation, ";") {
+		note = strings.TrimSpace(note)
+		end := strings.IndexFunc(note, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' })
+		if end <= 0 {
+			continue
+		}
+		amount, ok := parseNumber(note[:end])
+		unit, rest := parseUnit(note[end:])
+		if _, convertible := convertibleUnits[unit]; ok && convertible && strings.TrimSpace(rest) == "" {
+			return formatQuantity(amount, unit) + " " + unit
+		}
+	}
+	return ""
+}
+
+// shoppingName drops words such as "to taste" from an ingredient's name
+func shoppingName(name string) string {
+	name = strings.TrimSpace(name)
+	for _, suffix := range shoppingNameSuffixes {
+		if len(name) > len(suffix) && strings.EqualFold(name[len(name)-len(suffix):], suffix) {
+			name = strings.TrimSpace(name[:len(name)-len(suffix)])
+		}
+	}
+	return name
+}
+
+// shoppingItemKey normalises a name so "Eggs" and "egg" are the same item
+func shoppingItemKey(name string) string {
+	words := strings.Fields(strings.ToLower(name))
+	for i, word := range words {
+		words[i] = singular(word)
+	}
+	return strings.Join(words, " ")
+}
+
+// singular makes a rough singular of an English word
+func singular(word string) string {
+	switch {
+	case len(word) <= 3:
+		return word
+	case strings.HasSuffix(word, "ies"):
+		return strings.TrimSuffix(word, "ies") + "y"
+	case strings.HasSuffix(word, "oes"), strings.HasSuffix(word, "ches"), strings.HasSuffix(word, "shes"):
+		return strings.TrimSuffix(word, "es")
+	case strings.HasSuffix(word, "ss"), strings.HasSuffix(word, "us"), strings.HasSuffix(word, "is"):
+		return word
+	}
+	return strings.TrimSuffix(word, "s")
+}
+
+// shoppingSection returns the store section for a normalised item name
+func shoppingSection(key string) string {
+	words := " " + strings.Join(strings.FieldsFunc(key, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '(' || r == ')'
+	}), " ") + " "
+
+	match := ""
+	for keyword := range sectionKeywords {
+		if len(keyword) > len(match) && strings.Contains(words, " "+keyword+" ") {
+			match = keyword
+		}
+	}
+	if match == "" {
+		return "Other"
+	}
+	return sectionKeywords[match]
+}
+
+// sortShoppingItems orders items by store section, keeping their order
+// within a section
+func sortShoppingItems(items []ShoppingListItem) {
+	rank := make(map[string]int, len(shoppingSections))
+	for i, section := range shoppingSections {
+		rank[section] = i
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return rank[items[i].Section] < rank[items[j].Section]
+	})
+}
+
+// BuildShoppingList makes a shopping list from the recipes in req, each
+// scaled by its factor. Nothing is saved.
+func BuildShoppingList(ctx context.Context, req shoppingListRequest) (*ShoppingList, error) {
+	list := &ShoppingList{Name: req.Name, SharedWith: []string{}}
+	var ingredients []Ingredient
+
+	scales := make(map[string]int)
+	for _, entry := range req.Recipes {
+		scale := entry.Scale
+		if scale == 0 {
+			scale = 1
+		}
+
+		// The same recipe twice is one recipe at twice the scale
+		if i, ok := scales[entry.RecipeID]; ok {
+			list.Recipes[i].Scale += scale
+		} else {
+			scales[entry.RecipeID] = len(list.Recipes)
+			list.Recipes = append(list.Recipes, ShoppingListRecipe{RecipeID: entry.RecipeID, Scale: scale})
+		}
+	}
+
+	for i, entry := range list.Recipes {
+		recipe, err := GetRecipeByID(ctx, entry.RecipeID)
+		if err != nil {
+			return nil, err
+		}
+		if recipe == nil {
+			return nil, fmt.Errorf("%w: %s", errShoppingListRecipe, entry.RecipeID)
+		}
+
+		scaleRecipe(recipe, entry.Scale)
+		list.Recipes[i].Title = recipe.Title
+		ingredients = append(ingredients, recipe.IngredientList...)
+	}
+
+	list.Items = mergeIngredients(ingredients)
+	if list.Name == "" {
+		list.Name = "Shopping list " + time.Now().Format("2 Jan")
+	}
+	return list, nil
+}
+
+// CreateShoppingList saves a shopping list made by userID and syncs to blob
+// storage. The list is updated with its ID and item IDs.
+func CreateShoppingList(ctx context.Context, list *ShoppingList, userID string) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	list.ID = uuid.New().String()
+	list.CreatedByUserID = userID
+	list.CreatedAt = time.Now()
+	list.UpdatedAt = list.CreatedAt
+
+	l := *list
+	itemIDs := make([]int64, len(l.Items))
+	for i := range l.Items {
+		itemIDs[i] = newRowID()
+	}
+
+	err := applyChange(ctx, "create shopping list "+l.ID, func(ctx context.Context) error {
+		query := `INSERT INTO shopping_lists (id, name, created_by_user_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?)`
+		if _, err := execWrite(ctx, query, l.ID, l.Name, l.CreatedByUserID, l.CreatedAt, l.UpdatedAt); err != nil {
+			return err
+		}
+
+		for i, recipe := range l.Recipes {
+			query := `INSERT INTO shopping_list_recipes (list_id, recipe_id, position, title, scale) VALUES (?, ?, ?, ?, ?)`
+			if _, err := execWrite(ctx, query, l.ID, recipe.RecipeID, i, recipe.Title, recipe.Scale); err != nil {
+				return err
+			}
+		}
+
+		for i, item := range l.Items {
+			query := `
+				INSERT INTO shopping_list_items (id, list_id, position, section, name, quantity, unit, text, checked)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`
+			if _, err := execWrite(ctx, query, itemIDs[i], l.ID, i, item.Section, item.Name, item.Quantity, item.Unit, item.Text, item.Checked); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	saved, err := getShoppingList(ctx, l.ID)
+	if err != nil {
+		return err
+	}
+	*list = *saved
+	return nil
+}
+
+// GetShoppingLists returns the shopping lists a user made or has been shared,
+// most recently changed first
+func GetShoppingLists(ctx context.Context, userID string) ([]ShoppingList, error) {
+	rlockDB(ctx)
+	defer dbMutex.RUnlock()
+
+	query := `
+		SELECT id FROM shopping_lists
+		WHERE created_by_user_id = ?
+		   OR id IN (SELECT list_id FROM shopping_list_shares WHERE user_id = ?)
+		ORDER BY updated_at DESC
+	`
+	rows, err := db.QueryContext(ctx, query, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	lists := []ShoppingList{}
+	for _, id := range ids {
+		list, err := getShoppingList(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, *list)
+	}
+	return lists, nil
+}
+
+// GetShoppingList returns a shopping list, or nil if it doesn't exist
+func GetShoppingList(ctx context.Context, listID string) (*ShoppingList, error) {
+	rlockDB(ctx)
+	defer dbMutex.RUnlock()
+
+	list, err := getShoppingList(ctx, listID)
+	if errors.Is(err, errShoppingListNotFound) {
+		return nil, nil
+	}
+	return list, err
+}
+
+func getShoppingList(ctx context.Context, listID string) (*ShoppingList, error) {
+	var list ShoppingList
+	query := `SELECT id, name, created_by_user_id, created_at, updated_at FROM shopping_lists WHERE id = ?`
+	err := db.QueryRowContext(ctx, query, listID).Scan(&list.ID, &list.Name, &list.CreatedByUserID, &list.CreatedAt, &list.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, errShoppingListNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT recipe_id, title, scale FROM shopping_list_recipes WHERE list_id = ? ORDER BY position`, listID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list.Recipes = []ShoppingListRecipe{}
+	for rows.Next() {
+		var recipe ShoppingListRecipe
+		if err := rows.Scan(&recipe.RecipeID, &recipe.Title, &recipe.Scale); err != nil {
+			return nil, err
+		}
+		list.Recipes = append(list.Recipes, recipe)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `SELECT id, section, name, quantity, unit, text, checked FROM shopping_list_items WHERE list_id = ? ORDER BY position`
+	itemRows, err := db.QueryContext(ctx, query, listID)
+	if err != nil {
+		return nil, err
+	}
+	defer itemRows.Close()
+	list.Items = []ShoppingListItem{}
+	for itemRows.Next() {
+		var item ShoppingListItem
+		if err := itemRows.Scan(&item.ID, &item.Section, &item.Name, &item.Quantity, &item.Unit, &item.Text, &item.Checked); err != nil {
+			return nil, err
+		}
+		list.Items = append(list.Items, item)
+	}
+	if err := itemRows.Err(); err != nil {
+		return nil, err
+	}
+	// Items added by hand go into their section rather than at the end
+	sortShoppingItems(list.Items)
+
+	shareRows, err := db.QueryContext(ctx, `SELECT user_id FROM shopping_list_shares WHERE list_id = ? ORDER BY created_at`, listID)
+	if err != nil {
+		return nil, err
+	}
+	defer shareRows.Close()
+	list.SharedWith = []string{}
+	for shareRows.Next() {
+		var userID string
+		if err := shareRows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		list.SharedWith = append(list.SharedWith, userID)
+	}
+
+	return &list, shareRows.Err()
+}
+
+// canAccess reports whether a user made the list or has been shared it
+func (l *ShoppingList) canAccess(userID string) bool {
+	if l.CreatedByUserID == userID {
+		return true
+	}
+	for _, shared := range l.SharedWith {
+		if shared == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// changeShoppingList runs change on a list inside applyChange and marks the
+// list as updated
+func changeShoppingList(ctx context.Context, description, listID string, change func(ctx context.Context) error) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	now := time.Now()
+	return applyChange(ctx, description, func(ctx context.Context) error {
+		if err := change(ctx); err != nil {
+			return err
+		}
+		_, err := execWrite(ctx, `UPDATE shopping_lists SET updated_at = ? WHERE id = ?`, now, listID)
+		return err
+	})
+}
+
+// AddShoppingListItem adds an item written by hand, e.g. "2 lemons", to a list
+func AddShoppingListItem(ctx context.Context, listID, text string) error {
+	ing := parseIngredientLine(text)
+	name := ing.Name
+	if !ing.Parsed {
+		name = text
+	}
+	section := shoppingSection(shoppingItemKey(name))
+	itemID := newRowID()
+
+	return changeShoppingList(ctx, "add shopping list item to "+listID, listID, func(ctx context.Context) error {
+		query := `
+			INSERT INTO shopping_list_items (id, list_id, position, section, name, quantity, unit, text)
+			SELECT ?, ?, COALESCE(MAX(position), -1) + 1, ?, ?, ?, ?, ?
+			FROM shopping_list_items WHERE list_id = ?
+		`
+		_, err := execWrite(ctx, query, itemID, listID, section, name, ing.Quantity, ing.Unit, text, listID)
+		return err
+	})
+}
+
+// SetShoppingListItemChecked checks an item off a list, or unchecks it
+func SetShoppingListItemChecked(ctx context.Context, listID string, itemID int64, checked bool) error {
+	return changeShoppingList(ctx, fmt.Sprintf("check shopping list item %d", itemID), listID, func(ctx context.Context) error {
+		result, err := execWrite(ctx, `UPDATE shopping_list_items SET checked = ? WHERE id = ? AND list_id = ?`, checked, itemID, listID)
+		if err != nil {
+			return err
+		}
+		return requireRowAffected(result, errShoppingListItemNotFound)
+	})
+}
+
+// DeleteShoppingListItem removes an item from a list
+func DeleteShoppingListItem(ctx context.Context, listID string, itemID int64) error {
+	return changeShoppingList(ctx, fmt.Sprintf("delete shopping list item %d", itemID), listID, func(ctx context.Context) error {
+		result, err := execWrite(ctx, `DELETE FROM shopping_list_items WHERE id = ? AND list_id = ?`, itemID, listID)
+		if err != nil {
+			return err
+		}
+		return requireRowAffected(result, errShoppingListItemNotFound)
+	})
+}
+
+// ShareShoppingList lets another user see and check off a list
+func ShareShoppingList(ctx context.Context, listID, userID string) error {
+	sharedAt := time.Now()
+	return changeShoppingList(ctx, "share shopping list "+listID, listID, func(ctx context.Context) error {
+		_, err := execWrite(ctx, `INSERT OR IGNORE INTO shopping_list_shares (list_id, user_id, created_at) VALUES (?, ?, ?)`, listID, userID, sharedAt)
+		return err
+	})
+}
+
+// UnshareShoppingList stops sharing a list with a user
+func UnshareShoppingList(ctx context.Context, listID, userID string) error {
+	return changeShoppingList(ctx, "unshare shopping list "+listID, listID, func(ctx context.Context) error {
+		_, err := execWrite(ctx, `DELETE FROM shopping_list_shares WHERE list_id = ? AND user_id = ?`, listID, userID)
+		return err
+	})
+}
+
+// DeleteShoppingList deletes a list and everything in it
+func DeleteShoppingList(ctx context.Context, listID string) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	return applyChange(ctx, "delete shopping list "+listID, func(ctx context.Context) error {
+		queries := []string{
+			`DELETE FROM shopping_list_items WHERE list_id = ?`,
+			`DELETE FROM shopping_list_recipes WHERE list_id = ?`,
+			`DELETE FROM shopping_list_shares WHERE list_id = ?`,
+			`DELETE FROM shopping_lists WHERE id = ?`,
+		}
+		for _, query := range queries {
+			if _, err := execWrite(ctx, query, listID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// shoppingListsHandler serves shopping lists. Any signed-in user can make
+// them; a list is only visible to its creator and the users it is shared with.
+//
+//	GET    /shopping-lists                          lists the user made or was shared
+//	POST   /shopping-lists                          make a list from recipes
+//	GET    /shopping-lists/{id}                     get a list
+//	DELETE /shopping-lists/{id}                     delete a list (creator only)
+//	POST   /shopping-lists/{id}/items               add an item by hand
+//	PUT    /shopping-lists/{id}/items/{itemId}      check or uncheck an item
+//	DELETE /shopping-lists/{id}/items/{itemId}      remove an item
+//	POST   /shopping-lists/{id}/shares              share with a user by email (creator only)
+//	DELETE /shopping-lists/{id}/shares/{userId}     stop sharing (creator, or the user themselves)
+func shoppingListsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticateRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/shopping-lists"), "/")
+	if path == "" {
+		switch r.Method {
+		case http.MethodGet:
+			lists, err := GetShoppingLists(r.Context(), userID)
+			if err != nil {
+				log.Printf("Error getting shopping lists: %v", err)
+				http.Error(w, "Failed to get shopping lists", http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(lists)
+
+		case http.MethodPost:
+			createShoppingListHandler(w, r, userID)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	listID, subPath, _ := strings.Cut(path, "/")
+	list, err := GetShoppingList(r.Context(), listID)
+	if err != nil {
+		log.Printf("Error getting shopping list: %v", err)
+		http.Error(w, "Failed to get shopping list", http.StatusInternalServerError)
+		return
+	}
+	// Other users' lists are reported as missing rather than forbidden
+	if list == nil || !list.canAccess(userID) {
+		http.Error(w, "Shopping list not found", http.StatusNotFound)
+		return
+	}
+
+	resource, resourceID, _ := strings.Cut(subPath, "/")
+	switch {
+	case subPath == "" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(list)
+		return
+
+	case subPath == "" && r.Method == http.MethodDelete:
+		if list.CreatedByUserID != userID {
+			http.Error(w, "Only the creator can delete a shopping list", http.StatusForbidden)
+			return
+		}
+		log.Printf("Deleting shopping list %s - authenticated user: %s", listID, userID)
+
+		if err := DeleteShoppingList(r.Context(), listID); err != nil {
+			log.Printf("Error deleting shopping list: %v", err)
+			http.Error(w, "Failed to delete shopping list", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+
+	case resource == "items" && resourceID == "" && r.Method == http.MethodPost:
+		var body struct {
+			Text string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || strings.TrimSpace(body.Text) == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		err = AddShoppingListItem(r.Context(), listID, strings.TrimSpace(body.Text))
+
+	case resource == "items" && resourceID != "" && (r.Method == http.MethodPut || r.Method == http.MethodDelete):
+		itemID, parseErr := strconv.ParseInt(resourceID, 10, 64)
+		if parseErr != nil {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			err = DeleteShoppingListItem(r.Context(), listID, itemID)
+			break
+		}
+
+		var body struct {
+			Checked bool `json:"checked"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		err = SetShoppingListItemChecked(r.Context(), listID, itemID, body.Checked)
+
+	case resource == "shares" && resourceID == "" && r.Method == http.MethodPost:
+		if list.CreatedByUserID != userID {
+			http.Error(w, "Only the creator can share a shopping list", http.StatusForbidden)
+			return
+		}
+
+		var body struct {
+			Email string `json:"email"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Email == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		user, lookupErr := GetUserByEmail(r.Context(), body.Email)
+		if lookupErr != nil {
+			log.Printf("Error looking up user: %v", lookupErr)
+			http.Error(w, "Failed to share shopping list", http.StatusInternalServerError)
+			return
+		}
+		if user == nil {
+			http.Error(w, "No user with that email", http.StatusNotFound)
+			return
+		}
+		if user.FirebaseUID == userID {
+			http.Error(w, "Can't share a shopping list with yourself", http.StatusBadRequest)
+			return
+		}
+		log.Printf("Sharing shopping list %s with %s - authenticated user: %s", listID, user.FirebaseUID, userID)
+		err = ShareShoppingList(r.Context(), listID, user.FirebaseUID)
+
+	case resource == "shares" && resourceID != "" && r.Method == http.MethodDelete:
+		if list.CreatedByUserID != userID && resourceID != userID {
+			http.Error(w, "Only the creator can stop sharing a shopping list", http.StatusForbidden)
+			return
+		}
+		err = UnshareShoppingList(r.Context(), listID, resourceID)
+
+	case subPath == "" || resource == "items" || resource == "shares":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	if errors.Is(err, errShoppingListItemNotFound) {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating shopping list: %v", err)
+		http.Error(w, "Failed to update shopping list", http.StatusInternalServerError)
+		return
+	}
+
+	// Return the whole list so a phone showing it stays in step with other users
+	updated, err := GetShoppingList(r.Context(), listID)
+	if err != nil || updated == nil {
+		log.Printf("Error getting shopping list: %v", err)
+		http.Error(w, "Failed to get shopping list", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(updated)
+}
+
+// createShoppingListHandler handles POST /shopping-lists
+func createShoppingListHandler(w http.ResponseWriter, r *http.Request, userID string) {
+	var req shoppingListRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Recipes) == 0 {
+		http.Error(w, "At least one recipe is required", http.StatusBadRequest)
+		return
+	}
+	for _, recipe := range req.Recipes {
+		if recipe.RecipeID == "" || recipe.Scale < 0 {
+			http.Error(w, "Each recipe needs a recipeId, and scale can't be negative", http.StatusBadRequest)
+			return
+		}
+	}
+	log.Printf("Creating shopping list from %d recipes - authenticated user: %s", len(req.Recipes), userID)
+
+	list, err := BuildShoppingList(r.Context(), req)
+	if errors.Is(err, errShoppingListRecipe) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error building shopping list: %v", err)
+		http.Error(w, "Failed to build shopping list", http.StatusInternalServerError)
+		return
+	}
+
+	if err := CreateShoppingList(r.Context(), list, userID); err != nil {
+		log.Printf("Error creating shopping list: %v", err)
+		http.Error(w, "Failed to create shopping list", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxUploadAttempts bounds how many times an upload is retried after losing
+	// a race with another instance before giving up
+	maxUploadAttempts = 5
+
+	// defaultSyncInterval is how long the sync worker waits after a write before
+	// uploading, so a burst of edits ships as one segment (override with
+	// DB_SYNC_INTERVAL)
+	defaultSyncInterval = 2 * time.Second
+)
+
+// pendingChange is a write that has been applied to the local database but not
+// yet shipped to blob storage. statements holds the SQL it executed, which is
+// what gets replicated; apply re-runs the write so it can be replayed on top of
+// a newer copy of the database after an upload conflict.
+type pendingChange struct {
+	description string
+	apply       func(ctx context.Context) error
+	statements  []changeStatement
+}
+
+// SyncStatus reports how the local database relates to the replica in blob storage
+type SyncStatus struct {
+	Generation       int64      `json:"generation"`       // Replica position the local copy reflects
+	SnapshotPosition int64      `json:"snapshotPosition"` // Position of the latest known snapshot
+	PendingChanges   int        `json:"pendingChanges"`   // Local writes not yet uploaded
+	UploadConflicts  int64      `json:"uploadConflicts"`  // Uploads rejected because another instance uploaded first
+	UploadRetries    int64      `json:"uploadRetries"`    // Uploads retried after replaying local changes
+	LastSyncAt       *time.Time `json:"lastSyncAt"`       // When the replica was last confirmed up to date (nil if never)
+}
+
+var (
+	// dbGeneration is the replica position the local database reflects: the
+	// last segment applied or shipped, or 0 for a freshly created database
+	dbGeneration atomic.Int64
+
+	// snapshotPosition is the position of the latest snapshot in the replica
+	snapshotPosition atomic.Int64
+
+	pendingChanges []pendingChange
+	pendingMutex   sync.Mutex
+
+	// syncMutex ensures only one upload runs at a time
+	syncMutex sync.Mutex
+
+	uploadConflicts atomic.Int64
+	uploadRetries   atomic.Int64
+
+	// lastSyncAt is the Unix time in nanoseconds of the last successful upload
+	lastSyncAt atomic.Int64
+
+	// syncDirty wakes the sync worker when there are changes to upload
+	syncDirty = make(chan struct{}, 1)
+	syncStop  = make(chan struct{})
+	syncDone  = make(chan struct{})
+)
+
+// applyChange runs a write against the local database in a transaction and
+// records the statements it executed so they can be shipped to the replica,
+// and replayed if the next upload conflicts. A write that fails is rolled back
+// and nothing is recorded, so the replica never sees part of it.
+// Callers must hold dbMutex for writing.
+func applyChange(ctx context.Context, description string, apply func(ctx context.Context) error) error {
+	change := pendingChange{description: description, apply: apply}
+	if err := recordChange(ctx, &change); err != nil {
+		return err
+	}
+
+	pendingMutex.Lock()
+	pendingChanges = append(pendingChanges, change)
+	pendingMutex.Unlock()
+
+	markDBDirty()
+	return nil
+}
+
+// recordChange runs change.apply in a transaction, capturing every statement
+// it executes. The statements are only kept if the transaction commits.
+func recordChange(ctx context.Context, change *pendingChange) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	change.statements = nil
+	recordingChange = change
+	defer func() { recordingChange = nil }()
+
+	if err := change.apply(context.WithValue(ctx, changeTxKey{}, tx)); err != nil {
+		change.statements = nil
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		change.statements = nil
+		return fmt.Errorf("failed to commit %s: %w", change.description, err)
+	}
+	return nil
+}
+
+// pendingChangeCount returns the number of local changes not yet uploaded
+func pendingChangeCount() int {
+	pendingMutex.Lock()
+	defer pendingMutex.Unlock()
+	return len(pendingChanges)
+}
+
+// getSyncStatus returns the current synchronisation state for health reporting
+func getSyncStatus() SyncStatus {
+	status := SyncStatus{
+		Generation:       dbGeneration.Load(),
+		SnapshotPosition: snapshotPosition.Load(),
+		PendingChanges:   pendingChangeCount(),
+		UploadConflicts:  uploadConflicts.Load(),
+		UploadRetries:    uploadRetries.Load(),
+	}
+	if nanos := lastSyncAt.Load(); nanos != 0 {
+		t := time.Unix(0, nanos).UTC()
+		status.LastSyncAt = &t
+	}
+	return status
+}
+
+// markDBDirty tells the sync worker there are local changes to upload
+func markDBDirty() {
+	select {
+	case syncDirty <- struct{}{}:
+	default:
+		// An upload is already scheduled and will pick these changes up
+	}
+}
+
+// syncInterval returns how long the sync worker coalesces writes before uploading
+func syncInterval() time.Duration {
+	if v := os.Getenv("DB_SYNC_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d
+		}
+		log.Printf("Warning: invalid DB_SYNC_INTERVAL %q, using %s", v, defaultSyncInterval)
+	}
+	return defaultSyncInterval
+}
+
+// startSyncWorker starts the background loop that uploads local changes. Once
+// marked dirty it waits interval before uploading, so every write made in the
+// meantime goes out in the same upload, and uploads never overlap.
+func startSyncWorker(interval time.Duration) {
+	go func() {
+		defer close(syncDone)
+		for {
+			select {
+			case <-syncStop:
+				return
+			case <-syncDirty:
+			}
+
+			select {
+			case <-syncStop:
+				return
+			case <-time.After(interval):
+			}
+
+			if err := replicateChanges(context.Background()); err != nil {
+				log.Printf("Failed to upload database changes: %v", err)
+				// Leave the changes pending and try again after the next interval
+				markDBDirty()
+			}
+		}
+	}()
+}
+
+// flushSyncWorker stops the sync worker, waiting for an upload in progress,
+// and then uploads any changes that are still pending. Called on shutdown.
+func flushSyncWorker(ctx context.Context) error {
+	close(syncStop)
+	select {
+	case <-syncDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return replicateChanges(ctx)
+}
+
+// snapshotEvery returns how many segments are shipped between snapshots
+func snapshotEvery() int64 {
+	if v := os.Getenv("DB_SNAPSHOT_EVERY"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+		log.Printf("Warning: invalid DB_SNAPSHOT_EVERY %q, using %d", v, defaultSnapshotEvery)
+	}
+	return defaultSnapshotEvery
+}
+
+// replicateChanges ships pending local changes to blob storage as the next
+// segment. A segment can only be written if no other instance has written that
+// position yet, so another instance's changes are never silently overwritten.
+// On conflict the newer replica is restored, local pending changes are replayed
+// on top of it and the upload is retried. Once enough segments have
+// accumulated a new snapshot is taken.
+func replicateChanges(ctx context.Context) error {
+	syncMutex.Lock()
+	defer syncMutex.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		err := shipSegment(ctx)
+		if err == nil {
+			break
+		}
+		if !isPreconditionFailed(err) {
+			return err
+		}
+
+		conflicts := uploadConflicts.Add(1)
+		log.Printf("Database upload conflict: %v (attempt %d, %d conflicts total)", err, attempt, conflicts)
+
+		if attempt >= maxUploadAttempts {
+			return fmt.Errorf("giving up after %d conflicting uploads: %w", attempt, err)
+		}
+
+		if err := rebaseOnReplica(ctx); err != nil {
+			return fmt.Errorf("failed to rebase on newer database: %w", err)
+		}
+
+		retries := uploadRetries.Add(1)
+		log.Printf("Retrying database upload at position %d (%d retries total)", dbGeneration.Load(), retries)
+	}
+
+	lastSyncAt.Store(time.Now().UnixNano())
+
+	if dbGeneration.Load()-snapshotPosition.Load() >= snapshotEvery() {
+		if err := takeSnapshot(ctx); err != nil {
+			log.Printf("Warning: failed to take database snapshot: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// rebaseOnReplica replaces the local database with one restored from the
+// replica and replays pending local changes on top of it. Changes that no
+// longer apply, such as an update to a recipe deleted by another instance, are
+// logged and dropped.
+func rebaseOnReplica(ctx context.Context) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	remotePath := localDBPath + ".remote"
+	position, snapshot, err := restoreReplica(ctx, remotePath)
+	if err != nil {
+		os.Remove(remotePath)
+		return err
+	}
+
+	if err := replaceLocalDB(ctx, remotePath); err != nil {
+		return err
+	}
+	dbGeneration.Store(position)
+	snapshotPosition.Store(snapshot)
+
+	pendingMutex.Lock()
+	changes := pendingChanges
+	pendingChanges = nil
+	pendingMutex.Unlock()
+
+	var replayed []pendingChange
+	for _, change := range changes {
+		if err := recordChange(ctx, &change); err != nil {
+			log.Printf("Dropping local change %q that no longer applies: %v", change.description, err)
+			continue
+		}
+		replayed = append(replayed, change)
+	}
+
+	pendingMutex.Lock()
+	pendingChanges = replayed
+	pendingMutex.Unlock()
+
+	log.Printf("Rebased on position %d, replayed %d of %d local changes", position, len(replayed), len(changes))
+	return nil
+}
+
+// replaceLocalDB swaps the database file at path in for the local database.
+// The new file is checked before the local one is closed, and if it can't be
+// opened the local one is put back, so db is never left closed.
+// Callers must hold dbMutex for writing.
+func replaceLocalDB(ctx context.Context, path string) error {
+	if err := checkIntegrity(ctx, path); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("refusing to replace local database: %w", err)
+	}
+
+	if err := db.Close(); err != nil {
+		log.Printf("Warning: failed to close database before replacing it: %v", err)
+	}
+
+	// Keep the local files until the new one has opened
+	previousPath := localDBPath + ".previous"
+	removeDatabaseFiles(previousPath)
+	if err := moveDatabaseFiles(localDBPath, previousPath); err != nil {
+		restoreLocalDB(ctx, previousPath)
+		return fmt.Errorf("failed to replace local database: %w", err)
+	}
+
+	err := os.Rename(path, localDBPath)
+	if err == nil {
+		err = openDatabase(ctx)
+	}
+	if err != nil {
+		db.Close()
+		removeDatabaseFiles(localDBPath)
+		restoreLocalDB(ctx, previousPath)
+		return fmt.Errorf("failed to replace local database: %w", err)
+	}
+
+	removeDatabaseFiles(previousPath)
+	return nil
+}
+
+// restoreLocalDB moves the local database files set aside at previousPath
+// back and reopens them
+func restoreLocalDB(ctx context.Context, previousPath string) {
+	if err := moveDatabaseFiles(previousPath, localDBPath); err != nil {
+		log.Printf("Failed to put back the local database: %v", err)
+	}
+	if err := openDatabase(ctx); err != nil {
+		log.Printf("Failed to reopen the local database: %v", err)
+	}
+}
+
+// moveDatabaseFiles renames a database file along with its -wal and -shm files
+func moveDatabaseFiles(from, to string) error {
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if err := os.Rename(from+suffix, to+suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeDatabaseFiles deletes a database file along with its -wal and -shm files
+func removeDatabaseFiles(path string) {
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		os.Remove(path + suffix)
+	}
+}
+
+// isPreconditionFailed reports whether err is blob storage rejecting a
+// conditional write because the object already exists or has changed
+func isPreconditionFailed(err error) bool {
+	return errors.Is(err, ErrBlobPrecondition)
+}
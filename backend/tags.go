@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TagUsage is a tag and how many recipes use it
+type TagUsage struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"` // Recipes using it, not counting the trash
+}
+
+var (
+	errTagNotFound = errors.New("tag not found")
+	errTagExists   = errors.New("tag already exists")
+)
+
+// normalizeTagName returns a tag name the way tags are stored
+func normalizeTagName(name string) string {
+	return strings.TrimSpace(strings.ToLower(name))
+}
+
+// getTagID returns the ID of a tag by name, or errTagNotFound
+func getTagID(ctx context.Context, name string) (int64, error) {
+	var tagID int64
+	err := dbConn(ctx).QueryRowContext(ctx, `SELECT id FROM tags WHERE name = ?`, name).Scan(&tagID)
+	if err == sql.ErrNoRows {
+		return 0, errTagNotFound
+	}
+	return tagID, err
+}
+
+// getTagUsage returns a tag by name with its usage count
+func getTagUsage(ctx context.Context, name string) (*TagUsage, error) {
+	query := `
+		SELECT t.id, t.name, COUNT(r.id)
+		FROM tags t
+		LEFT JOIN recipe_tags rt ON rt.tag_id = t.id
+		LEFT JOIN recipes r ON r.id = rt.recipe_id AND r.deleted_at IS NULL
+		WHERE t.name = ?
+		GROUP BY t.id
+	`
+	var tag TagUsage
+	err := db.QueryRowContext(ctx, query, name).Scan(&tag.ID, &tag.Name, &tag.Count)
+	if err == sql.ErrNoRows {
+		return nil, errTagNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+// taggedRecipeIDs returns the recipes, including trashed ones, that have any
+// of the tags
+func taggedRecipeIDs(ctx context.Context, tagIDs []int64) ([]string, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(tagIDs)), ", ")
+	args := make([]interface{}, len(tagIDs))
+	for i, id := range tagIDs {
+		args[i] = id
+	}
+
+	rows, err := dbConn(ctx).QueryContext(ctx, `SELECT DISTINCT recipe_id FROM recipe_tags WHERE tag_id IN (`+placeholders+`) ORDER BY recipe_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipeIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		recipeIDs = append(recipeIDs, id)
+	}
+	return recipeIDs, rows.Err()
+}
+
+// recordTagChange records a new revision of each recipe whose tags an editor
+// changed, so its history shows the change. The caller must be inside
+// applyChange.
+func recordTagChange(ctx context.Context, recipeIDs []string, userID string, userName *string, at time.Time) error {
+	for _, recipeID := range recipeIDs {
+		if err := recordBaselineRevision(ctx, recipeID); err != nil {
+			return err
+		}
+		if _, err := execWrite(ctx, `UPDATE recipes SET updated_at = ? WHERE id = ?`, at, recipeID); err != nil {
+			return err
+		}
+		if err := recordRevision(ctx, recipeID, &userID, userName, at, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenameTag renames a tag on every recipe that uses it. Renaming it to the
+// name of another tag fails with errTagExists; merge them instead.
+func RenameTag(ctx context.Context, name, newName, userID string, userName *string) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	now := time.Now()
+	return applyChange(ctx, fmt.Sprintf("rename tag %q to %q", name, newName), func(ctx context.Context) error {
+		tagID, err := getTagID(ctx, name)
+		if err != nil {
+			return err
+		}
+		if newName == name {
+			return nil
+		}
+		if _, err := getTagID(ctx, newName); err == nil {
+			return errTagExists
+		} else if !errors.Is(err, errTagNotFound) {
+			return err
+		}
+
+		recipeIDs, err := taggedRecipeIDs(ctx, []int64{tagID})
+		if err != nil {
+			return err
+		}
+
+		if _, err := execWrite(ctx, `UPDATE tags SET name = ? WHERE id = ?`, newName, tagID); err != nil {
+			return err
+		}
+		return recordTagChange(ctx, recipeIDs, userID, userName, now)
+	})
+}
+
+// MergeTags moves every recipe tagged with any of names to the tag into,
+// which is created if it doesn't exist, and deletes the other tags
+func MergeTags(ctx context.Context, names []string, into, userID string, userName *string) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	now := time.Now()
+	return applyChange(ctx, fmt.Sprintf("merge tags %q into %q", names, into), func(ctx context.Context) error {
+		var tagIDs []int64
+		for _, name := range names {
+			if name == into {
+				continue
+			}
+			tagID, err := getTagID(ctx, name)
+			if err != nil {
+				return err
+			}
+			tagIDs = append(tagIDs, tagID)
+		}
+		if len(tagIDs) == 0 {
+			return nil
+		}
+
+		recipeIDs, err := taggedRecipeIDs(ctx, tagIDs)
+		if err != nil {
+			return err
+		}
+
+		intoID, err := getOrCreateTag(ctx, into)
+		if err != nil {
+			return err
+		}
+
+		for _, tagID := range tagIDs {
+			query := `INSERT OR IGNORE INTO recipe_tags (recipe_id, tag_id) SELECT recipe_id, ? FROM recipe_tags WHERE tag_id = ?`
+			if _, err := execWrite(ctx, query, intoID, tagID); err != nil {
+				return err
+			}
+			if _, err := execWrite(ctx, `DELETE FROM recipe_tags WHERE tag_id = ?`, tagID); err != nil {
+				return err
+			}
+			if _, err := execWrite(ctx, `DELETE FROM tags WHERE id = ?`, tagID); err != nil {
+				return err
+			}
+		}
+		return recordTagChange(ctx, recipeIDs, userID, userName, now)
+	})
+}
+
+// DeleteTag removes a tag from every recipe and deletes it
+func DeleteTag(ctx context.Context, name, userID string, userName *string) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	now := time.Now()
+	return applyChange(ctx, fmt.Sprintf("delete tag %q", name), func(ctx context.Context) error {
+		tagID, err := getTagID(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		recipeIDs, err := taggedRecipeIDs(ctx, []int64{tagID})
+		if err != nil {
+			return err
+		}
+
+		if _, err := execWrite(ctx, `DELETE FROM recipe_tags WHERE tag_id = ?`, tagID); err != nil {
+			return err
+		}
+		if _, err := execWrite(ctx, `DELETE FROM tags WHERE id = ?`, tagID); err != nil {
+			return err
+		}
+		return recordTagChange(ctx, recipeIDs, userID, userName, now)
+	})
+}
+
+// PruneTags deletes the tags no recipe uses and returns their names. Tags
+// on recipes in the trash are kept, so restoring a recipe keeps its tags.
+func PruneTags(ctx context.Context) ([]string, error) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	rows, err := db.QueryContext(ctx, `SELECT name FROM tags WHERE id NOT IN (SELECT tag_id FROM recipe_tags) ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	removed := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		removed = append(removed, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(removed) == 0 {
+		return removed, nil
+	}
+
+	err = applyChange(ctx, "prune unused tags", func(ctx context.Context) error {
+		_, err := execWrite(ctx, `DELETE FROM tags WHERE id NOT IN (SELECT tag_id FROM recipe_tags)`)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// tagsHandler serves /tags and the paths below it. Reads are public; changes
+// are for editors and admins only.
+//
+//	GET    /tags                list tags with how many recipes use them
+//	PUT    /tags/{name}         rename a tag
+//	DELETE /tags/{name}         remove a tag from every recipe and delete it
+//	POST   /tags/_merge         merge tags into one
+//	POST   /tags/_prune         delete tags no recipe uses
+func tagsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/tags"), "/")
+
+	if path == "" {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Public read - no auth required
+		// ?type=drink counts only drinks, ?prefix=ve autocompletes, ?sort=count puts the most used first
+		query := r.URL.Query()
+		sortBy := query.Get("sort")
+		if sortBy != "" && sortBy != "name" && sortBy != "count" {
+			http.Error(w, "sort must be name or count", http.StatusBadRequest)
+			return
+		}
+
+		tags, err := GetAllTags(r.Context(), query.Get("type"), normalizeTagName(query.Get("prefix")), sortBy)
+		if err != nil {
+			log.Printf("Error getting tags: %v", err)
+			http.Error(w, "Failed to get tags", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(tags)
+		return
+	}
+
+	// Auth required for writes
+	userID, err := authenticateRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !userHasRole(r.Context(), userID, "editor", "admin") {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	userName := userDisplayName(r.Context(), userID)
+
+	// Renames and deletes come first, so a tag named anything can be changed.
+	// The bulk operations start with "_" to keep them apart from tag names.
+	switch {
+	case r.Method == http.MethodPut:
+		// {"name": "vegetarian"}
+		var body struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		name, newName := normalizeTagName(path), normalizeTagName(body.Name)
+		if newName == "" {
+			http.Error(w, "Name is required", http.StatusBadRequest)
+			return
+		}
+		log.Printf("Renaming tag %q to %q - authenticated user: %s", name, newName, userID)
+
+		err := RenameTag(r.Context(), name, newName, userID, userName)
+		if errors.Is(err, errTagNotFound) {
+			http.Error(w, "Tag not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, errTagExists) {
+			http.Error(w, "A tag with that name already exists; merge the tags instead", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("Error renaming tag: %v", err)
+			http.Error(w, "Failed to rename tag", http.StatusInternalServerError)
+			return
+		}
+		writeTag(w, r, newName)
+
+	case r.Method == http.MethodDelete:
+		name := normalizeTagName(path)
+		log.Printf("Deleting tag %q - authenticated user: %s", name, userID)
+
+		err := DeleteTag(r.Context(), name, userID, userName)
+		if errors.Is(err, errTagNotFound) {
+			http.Error(w, "Tag not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error deleting tag: %v", err)
+			http.Error(w, "Failed to delete tag", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case path == "_merge" && r.Method == http.MethodPost:
+		// {"tags": ["cocktails", "drinks"], "into": "cocktail"}
+		var body struct {
+			Tags []string `json:"tags"`
+			Into string   `json:"into"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		into := normalizeTagName(body.Into)
+		var names []string
+		for _, name := range body.Tags {
+			if name = normalizeTagName(name); name != "" {
+				names = append(names, name)
+			}
+		}
+		if into == "" || len(names) == 0 {
+			http.Error(w, "Tags to merge and a tag to merge them into are required", http.StatusBadRequest)
+			return
+		}
+		log.Printf("Merging tags %q into %q - authenticated user: %s", names, into, userID)
+
+		err := MergeTags(r.Context(), names, into, userID, userName)
+		if errors.Is(err, errTagNotFound) {
+			http.Error(w, "Tag not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error merging tags: %v", err)
+			http.Error(w, "Failed to merge tags", http.StatusInternalServerError)
+			return
+		}
+		writeTag(w, r, into)
+
+	case path == "_prune" && r.Method == http.MethodPost:
+		log.Printf("Pruning unused tags - authenticated user: %s", userID)
+
+		removed, err := PruneTags(r.Context())
+		if err != nil {
+			log.Printf("Error pruning tags: %v", err)
+			http.Error(w, "Failed to prune tags", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string][]string{"removed": removed})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// writeTag responds with a tag and its usage count
+func writeTag(w http.ResponseWriter, r *http.Request, name string) {
+	rlockDB(r.Context())
+	tag, err := getTagUsage(r.Context(), name)
+	dbMutex.RUnlock()
+	if err != nil {
+		log.Printf("Error getting tag: %v", err)
+		http.Error(w, "Failed to get tag", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(tag)
+}
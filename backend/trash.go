@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultTrashRetention is how long a deleted recipe stays in the trash
+	// before it is purged (override with TRASH_RETENTION, 0 disables purging)
+	defaultTrashRetention = 30 * 24 * time.Hour
+
+	// trashPurgeInterval is how often the purge job looks for expired recipes
+	trashPurgeInterval = time.Hour
+)
+
+var errRecipeNotInTrash = errors.New("recipe not in trash")
+
+// TrashedRecipe is a deleted recipe waiting in the trash
+type TrashedRecipe struct {
+	Recipe
+	DeletedAt       time.Time  `json:"deletedAt"`
+	DeletedByUserID *string    `json:"deletedByUserId"` // Firebase UID of whoever deleted it (nullable)
+	PurgeAt         *time.Time `json:"purgeAt"`         // When it will be removed for good (nil if purging is disabled)
+}
+
+// trashRetention returns how long deleted recipes are kept before purging
+func trashRetention() time.Duration {
+	if v := os.Getenv("TRASH_RETENTION"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d
+		}
+		log.Printf("Warning: invalid TRASH_RETENTION %q, using %s", v, defaultTrashRetention)
+	}
+	return defaultTrashRetention
+}
+
+// GetTrashedRecipes returns the recipes in the trash, most recently deleted first
+func GetTrashedRecipes(ctx context.Context, retention time.Duration) ([]TrashedRecipe, error) {
+	rlockDB(ctx)
+	defer dbMutex.RUnlock()
+
+	query := `
+		SELECT id, title, description, recipe_type, cuisine, servings, yield, prep_time_minutes, cook_time_minutes, total_time_minutes, glass, garnish, preparation, ice, base_spirit, ingredients, method, notes, sources, icon_id, created_by_user_id, created_by_name, created_at, updated_at, deleted_at, deleted_by_user_id
+		FROM recipes
+		WHERE deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC
+	`
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recipes := []TrashedRecipe{}
+	for rows.Next() {
+		var t TrashedRecipe
+		r := &t.Recipe
+		err := rows.Scan(&r.ID, &r.Title, &r.Description, &r.RecipeType, &r.Cuisine, &r.Servings, &r.Yield, &r.PrepTimeMinutes, &r.CookTimeMinutes, &r.TotalTimeMinutes, &r.Glass, &r.Garnish, &r.Preparation, &r.Ice, &r.BaseSpirit, &r.Ingredients, &r.Method, &r.Notes, &r.Sources, &r.IconID, &r.CreatedByUserID, &r.CreatedByName, &r.CreatedAt, &r.UpdatedAt, &t.DeletedAt, &t.DeletedByUserID)
+		if err != nil {
+			return nil, err
+		}
+
+		if retention > 0 {
+			purgeAt := t.DeletedAt.Add(retention)
+			t.PurgeAt = &purgeAt
+		}
+
+		recipes = append(recipes, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Load tags and images so the trash can show what each recipe was
+	ids := make([]string, len(recipes))
+	for i, t := range recipes {
+		ids[i] = t.Recipe.ID
+	}
+	tags, err := getTagsByRecipe(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	images, err := getImagesByRecipe(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range recipes {
+		r := &recipes[i].Recipe
+		r.Tags = tags[r.ID]
+		r.Images = images[r.ID]
+	}
+
+	return recipes, nil
+}
+
+// RestoreRecipe takes a recipe out of the trash and syncs to blob storage
+func RestoreRecipe(ctx context.Context, recipeID string) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	return applyChange(ctx, "restore recipe "+recipeID, func(ctx context.Context) error {
+		query := `UPDATE recipes SET deleted_at = NULL, deleted_by_user_id = NULL WHERE id = ? AND deleted_at IS NOT NULL`
+		result, err := execWrite(ctx, query, recipeID)
+		if err != nil {
+			return err
+		}
+		return requireRowAffected(result, errRecipeNotInTrash)
+	})
+}
+
+// PurgeTrash permanently removes recipes deleted before cutoff, along with
+// everything that belongs to them, then deletes their images from storage.
+// It returns the number of recipes purged.
+func PurgeTrash(ctx context.Context, cutoff time.Time) (int, error) {
+	dbMutex.Lock()
+	recipeIDs, imageURLs, err := expiredTrash(ctx, cutoff)
+	if err == nil && len(recipeIDs) > 0 {
+		err = applyChange(ctx, "purge trash", func(ctx context.Context) error {
+			for _, recipeID := range recipeIDs {
+				if err := purgeRecipe(ctx, recipeID); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err == nil {
+		imageURLs, err = unusedImages(ctx, imageURLs)
+	}
+	dbMutex.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	// Only delete images once nothing references them. A failure leaves an
+	// orphaned object behind but no broken recipe.
+	for _, imageURL := range imageURLs {
+		if err := DeleteImageFromGCS(ctx, imageURL); err != nil && !errors.Is(err, ErrBlobNotFound) {
+			log.Printf("Warning: failed to delete image %s of purged recipe: %v", imageURL, err)
+		}
+	}
+
+	return len(recipeIDs), nil
+}
+
+// expiredTrash returns the recipes deleted before cutoff and their image URLs
+func expiredTrash(ctx context.Context, cutoff time.Time) ([]string, []string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id FROM recipes WHERE deleted_at IS NOT NULL AND deleted_at <= ?`, cutoff)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var recipeIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, nil, err
+		}
+		recipeIDs = append(recipeIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	images, err := getImagesByRecipe(ctx, recipeIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	var imageURLs []string
+	for _, id := range recipeIDs {
+		for _, img := range images[id] {
+			imageURLs = append(imageURLs, img.ImageURL)
+		}
+	}
+
+	return recipeIDs, imageURLs, nil
+}
+
+// unusedImages returns the image URLs that no recipe refers to. Variants share
+// their parent's images, so a purged recipe's images may still be in use.
+func unusedImages(ctx context.Context, imageURLs []string) ([]string, error) {
+	var unused []string
+	for _, imageURL := range imageURLs {
+		var inUse bool
+		err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM recipe_images WHERE image_url = ?)`, imageURL).Scan(&inUse)
+		if err != nil {
+			return nil, err
+		}
+		if !inUse {
+			unused = append(unused, imageURL)
+		}
+	}
+	return unused, nil
+}
+
+// purgeRecipe deletes a trashed recipe and every row that refers to it.
+// Foreign keys are not enforced, so ON DELETE CASCADE never fires and each
+// dependent table has to be cleared here.
+func purgeRecipe(ctx context.Context, recipeID string) error {
+	dependents := []string{
+		`DELETE FROM recipe_tags WHERE recipe_id = ?`,
+		`DELETE FROM recipe_images WHERE recipe_id = ?`,
+		`DELETE FROM make_logs WHERE recipe_id = ?`,
+		`DELETE FROM recipe_revisions WHERE recipe_id = ?`,
+		`DELETE FROM recipe_ingredients WHERE recipe_id = ?`,
+		`DELETE FROM shopping_list_recipes WHERE recipe_id = ?`,
+		`DELETE FROM meal_plans WHERE recipe_id = ?`,
+		`DELETE FROM collection_recipes WHERE recipe_id = ?`,
+		`DELETE FROM recipe_favorites WHERE recipe_id = ?`,
+		`DELETE FROM recipe_views WHERE recipe_id = ?`,
+		`DELETE FROM recipe_links WHERE recipe_id = ?`,
+		`DELETE FROM recipe_nutrition WHERE recipe_id = ?`,
+		// Links to it from other recipes are left waiting for a recipe with its title
+		`UPDATE recipe_links SET linked_recipe_id = NULL WHERE linked_recipe_id = ?`,
+		// Its variants become variants of the recipe it was based on
+		`UPDATE recipes SET parent_recipe_id = (SELECT parent_recipe_id FROM recipes WHERE id = ?1) WHERE parent_recipe_id = ?1`,
+	}
+	for _, query := range dependents {
+		if _, err := execWrite(ctx, query, recipeID); err != nil {
+			return err
+		}
+	}
+
+	_, err := execWrite(ctx, `DELETE FROM recipes WHERE id = ? AND deleted_at IS NOT NULL`, recipeID)
+	return err
+}
+
+// startTrashPurger permanently removes recipes that have been in the trash
+// for longer than retention, checking every trashPurgeInterval
+func startTrashPurger(ctx context.Context, retention time.Duration) {
+	if retention == 0 {
+		log.Println("Trash purging disabled")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+
+		for {
+			purged, err := PurgeTrash(ctx, time.Now().UTC().Add(-retention))
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Trash purge failed: %v", err)
+			}
+			if purged > 0 {
+				log.Printf("Purged %d recipes from the trash", purged)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// trashHandler serves the trash. Editors and admins only.
+//
+//	GET  /trash                   list deleted recipes
+//	POST /trash/{id}/restore      restore a deleted recipe
+func trashHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticateRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !userHasRole(r.Context(), userID, "editor", "admin") {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/trash"), "/")
+
+	switch {
+	case path == "":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		recipes, err := GetTrashedRecipes(r.Context(), trashRetention())
+		if err != nil {
+			log.Printf("Error getting trash: %v", err)
+			http.Error(w, "Failed to get trash", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(recipes)
+
+	case strings.HasSuffix(path, "/restore"):
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		recipeID := strings.TrimSuffix(path, "/restore")
+		log.Printf("Restoring recipe %s from trash - authenticated user: %s", recipeID, userID)
+
+		err := RestoreRecipe(r.Context(), recipeID)
+		if errors.Is(err, errRecipeNotInTrash) {
+			http.Error(w, "Recipe not found in trash", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error restoring recipe: %v", err)
+			http.Error(w, "Failed to restore recipe", http.StatusInternalServerError)
+			return
+		}
+
+		recipe, err := GetRecipeByID(r.Context(), recipeID)
+		if err != nil || recipe == nil {
+			log.Printf("Error getting restored recipe: %v", err)
+			http.Error(w, "Failed to get restored recipe", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(recipe)
+
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	unitsMetric   = "metric"
+	unitsImperial = "imperial"
+
+	// cupMillilitres is the size of a US cup
+	cupMillilitres = 240.0
+
+	// fluidOunceMillilitres is the size of a US fluid ounce
+	fluidOunceMillilitres = 29.57
+)
+
+// unitMeasure describes a unit that can be converted to the other system
+type unitMeasure struct {
+	metric bool
+	weight bool    // Weight if true, volume otherwise
+	base   float64 // Grams or millilitres in one unit
+}
+
+// convertibleUnits are the units conversion understands. Teaspoons and
+// tablespoons are the same in both systems and are left alone.
+var convertibleUnits = map[string]unitMeasure{
+	"g":     {metric: true, weight: true, base: 1},
+	"kg":    {metric: true, weight: true, base: 1000},
+	"ml":    {metric: true, base: 1},
+	"l":     {metric: true, base: 1000},
+	"oz":    {weight: true, base: 28.35},
+	"lb":    {weight: true, base: 453.6},
+	"cup":   {base: cupMillilitres},
+	"fl oz": {base: fluidOunceMillilitres},
+}
+
+// liquidIngredients are poured rather than weighed, so an "oz" of one is a
+// fluid ounce. The longest match wins, and false marks things like cream
+// cheese that contain the name of a liquid but are weighed.
+var liquidIngredients = map[string]bool{
+	"water": true, "milk": true, "buttermilk": true, "cream": true, "stock": true, "broth": true,
+	"juice": true, "oil": true, "vinegar": true, "sauce": true, "syrup": true, "coffee": true, "espresso": true,
+	"wine": true, "beer": true, "cider": true, "soda": true, "tonic": true, "gin": true, "vodka": true,
+	"rum": true, "whisky": true, "whiskey": true, "bourbon": true, "tequila": true, "mezcal": true,
+	"brandy": true, "cognac": true, "vermouth": true, "liqueur": true, "sherry": true, "campari": true,
+	"aperol": true, "bitters": true,
+	"cream cheese": false, "sour cream": false, "ice cream": false,
+}
+
+// ingredientDensities are grams per cup of common pantry items, so that dry
+// ingredients can be weighed in metric and measured by the cup in imperial
+var ingredientDensities = map[string]float64{
+	"flour": 125, "plain flour": 125, "all-purpose flour": 125, "self-raising flour": 125, "self-rising flour": 125,
+	"bread flour": 130, "wholemeal flour": 120, "whole wheat flour": 120, "rye flour": 100,
+	"cornflour": 120, "cornstarch": 120, "semolina": 165,
+	"sugar": 200, "white sugar": 200, "granulated sugar": 200, "caster sugar": 220, "superfine sugar": 220,
+	"brown sugar": 220, "icing sugar": 120, "powdered sugar": 120,
+	"butter": 227, "honey": 340, "golden syrup": 340, "maple syrup": 320, "peanut butter": 260,
+	"cocoa": 85, "cocoa powder": 85, "chocolate chips": 170,
+	"rice": 185, "oats": 90, "rolled oats": 90, "couscous": 180, "quinoa": 170, "lentils": 190,
+	"breadcrumbs": 110, "panko": 60, "ground almonds": 96, "almond meal": 96, "desiccated coconut": 80,
+	"raisins": 150, "parmesan": 100, "grated parmesan": 100, "grated cheese": 113, "yoghurt": 245, "yogurt": 245,
+	"salt": 290,
+}
+
+// measurementRegexp finds amounts in free text such as "2 cups", "8oz" or
+// "1-2 litres"
+var measurementRegexp = regexp.MustCompile(`(` + numberPattern + `)(?:\s*(?:-|–|to)\s*(` + numberPattern + `))?\s?` +
+	`(?i:(fl\.? oz|fluid ounces?|cups?|ounces?|oz|pounds?|lbs?|grams?|g|kilograms?|kilos?|kgs?|millilit(?:re|er)s?|mls?|lit(?:re|er)s?)\b)`)
+
+// temperatureRegexp finds temperatures such as "350°F", "180 C" or
+// "200 degrees Celsius"
+var temperatureRegexp = regexp.MustCompile(`(\d+)(?:\s*(?:-|–|to)\s*(\d+))?\s*(°\s*|º\s*|(?i:degrees?)\s+)?(F|C|(?i:fahrenheit|celsius|centigrade))\b`)
+
+// unitSystem reads ?units=metric|imperial, returning "" if it isn't given
+func unitSystem(query url.Values) (string, error) {
+	switch units := query.Get("units"); units {
+	case "", unitsMetric, unitsImperial:
+		return units, nil
+	default:
+		return "", errors.New("units must be metric or imperial")
+	}
+}
+
+// convertRecipeUnits rewrites the measurements in a recipe's ingredients and
+// method in the given unit system. Nothing happens if system is "".
+func convertRecipeUnits(recipe *Recipe, system string) {
+	if system == "" {
+		return
+	}
+	drink := recipe.RecipeType == "drink"
+
+	lines := strings.Split(recipe.Ingredients, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		text := stripListMarker(trimmed)
+		start := strings.Index(line, text)
+		lines[i] = line[:start] + convertIngredientLine(text, system, drink) + line[start+len(text):]
+	}
+	recipe.Ingredients = strings.Join(lines, "\n")
+	recipe.IngredientList = parseIngredients(recipe.Ingredients)
+
+	recipe.Method = convertText(recipe.Method, system, drink)
+}
+
+// convertIngredientLine converts the measure of a single ingredient. If the
+// line already gives the amount in the other system ("1 cup / 250 ml milk"),
+// the author's own figure is used.
+func convertIngredientLine(text, system string, drink bool) string {
+	ingredient := parseIngredientLine(text)
+	if !ingredient.Parsed || ingredient.Quantity == nil || ingredient.Unit == "" {
+		return text
+	}
+
+	_, _, afterQuantity, ok := parseQuantity(text)
+	if !ok {
+		return text
+	}
+	unit, rest := parseUnit(afterQuantity)
+	if unit != ingredient.Unit {
+		// "2 heaped cups" can't be converted sensibly
+		return text
+	}
+
+	if alternative, afterAlternative, ok := cutAlternativeMeasure(rest); ok {
+		_, _, afterAltQuantity, _ := parseQuantity(alternative)
+		if altUnit, _ := parseUnit(afterAltQuantity); inUnitSystem(altUnit, system) {
+			return alternative + afterAlternative
+		}
+		rest = afterAlternative
+	}
+
+	converted, ok := convertMeasure(*ingredient.Quantity, ingredient.QuantityMax, unit, ingredient.Name, system, drink)
+	if !ok {
+		return text
+	}
+	return converted + rest
+}
+
+// convertText converts the temperatures and measurements in free text such
+// as a recipe method
+func convertText(text, system string, drink bool) string {
+	text = replaceMatches(text, temperatureRegexp, func(match []string) (string, bool) {
+		value, _ := strconv.ParseFloat(match[1], 64)
+		// Without a degree sign, "2 C" is more likely cups than Celsius
+		if match[3] == "" && value < 100 {
+			return "", false
+		}
+
+		celsius := strings.HasPrefix(strings.ToUpper(match[4]), "C")
+		if celsius == (system == unitsMetric) {
+			return "", false
+		}
+
+		converted := convertTemperature(value, celsius)
+		if match[2] != "" {
+			max, _ := strconv.ParseFloat(match[2], 64)
+			converted += "-" + convertTemperature(max, celsius)
+		}
+		if celsius {
+			return converted + "°F", true
+		}
+		return converted + "°C", true
+	})
+
+	return replaceMatches(text, measurementRegexp, func(match []string) (string, bool) {
+		amount, ok := parseNumber(match[1])
+		if !ok {
+			return "", false
+		}
+		var amountMax *float64
+		if match[2] != "" {
+			if max, ok := parseNumber(match[2]); ok {
+				amountMax = &max
+			}
+		}
+
+		unit, _ := parseUnit(match[3])
+		if unit == "" {
+			return "", false
+		}
+
+		// The words after the amount say what is being measured
+		what := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(match[4])), "of ")
+		return convertMeasure(amount, amountMax, unit, what, system, drink)
+	})
+}
+
+// replaceMatches replaces each match of re in text with the result of
+// replace, leaving the match as it is if replace returns false. The last
+// submatch passed to replace is the rest of the sentence after the match.
+func replaceMatches(text string, re *regexp.Regexp, replace func(match []string) (string, bool)) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range re.FindAllStringSubmatchIndex(text, -1) {
+		match := make([]string, 0, len(loc)/2+1)
+		for i := 0; i < len(loc); i += 2 {
+			if loc[i] < 0 {
+				match = append(match, "")
+			} else {
+				match = append(match, text[loc[i]:loc[i+1]])
+			}
+		}
+		sentence := text[loc[1]:]
+		if end := strings.IndexAny(sentence, ".,;:()\n"); end >= 0 {
+			sentence = sentence[:end]
+		}
+		match = append(match, sentence)
+
+		if replacement, ok := replace(match); ok {
+			b.WriteString(text[last:loc[0]])
+			b.WriteString(replacement)
+			last = loc[1]
+		}
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// convertMeasure converts an amount (or range) of unit into the given system,
+// returning it as text such as "250 g" or "1 1/2 cups". Ounces in a drink are
+// fluid ounces.
+func convertMeasure(amount float64, amountMax *float64, unit, name, system string, drink bool) (string, bool) {
+	measure, ok := ingredientMeasure(unit, name, drink)
+	if !ok || measure.metric == (system == unitsMetric) {
+		return "", false
+	}
+
+	// perUnit is the grams or millilitres in one unit, once dry ingredients
+	// have been weighed or measured by the cup
+	perUnit := measure.base
+	density, hasDensity := ingredientDensity(name)
+
+	// Both ends of a range use the unit picked for the lower one, or for the
+	// upper one in a range such as "0-1 cup"
+	sized := amount
+	if amount == 0 && amountMax != nil {
+		sized = *amountMax
+	}
+
+	var target string
+	switch {
+	case system == unitsMetric && !measure.weight && hasDensity:
+		perUnit = perUnit / cupMillilitres * density
+		_, target = metricWeight(sized * perUnit)
+	case system == unitsMetric && !measure.weight:
+		_, target = metricVolume(sized * perUnit)
+	case system == unitsMetric:
+		_, target = metricWeight(sized * perUnit)
+	case measure.weight && hasDensity:
+		perUnit = perUnit / density * cupMillilitres
+		_, target = imperialVolume(sized * perUnit)
+	case measure.weight:
+		_, target = imperialWeight(sized * perUnit)
+	default:
+		_, target = imperialVolume(sized * perUnit)
+	}
+
+	largest := amount * perUnit / unitBase(target)
+	text := formatConverted(largest, target)
+	if amountMax != nil {
+		largest = *amountMax * perUnit / unitBase(target)
+		text += "-" + formatConverted(largest, target)
+	}
+
+	if countableUnits[target] {
+		rounded, _ := parseNumber(formatConverted(largest, target))
+		target = unitWord(target, rounded)
+	}
+	return text + " " + target, true
+}
+
+// ingredientMeasure returns how unit measures an ingredient. Ounces of a
+// liquid, or of anything in a drink, are fluid ounces rather than weight.
+func ingredientMeasure(unit, name string, drink bool) (unitMeasure, bool) {
+	if unit == "oz" && (drink || isLiquid(name)) {
+		unit = "fl oz"
+	}
+	measure, ok := convertibleUnits[unit]
+	return measure, ok
+}
+
+// unitBase returns the grams or millilitres in one of a unit conversion
+// converts to
+func unitBase(unit string) float64 {
+	if millilitres, ok := spoonMillilitres[unit]; ok {
+		return millilitres
+	}
+	return convertibleUnits[unit].base
+}
+
+func metricWeight(grams float64) (float64, string) {
+	if grams >= 1000 {
+		return grams / 1000, "kg"
+	}
+	return grams, "g"
+}
+
+func metricVolume(millilitres float64) (float64, string) {
+	if millilitres >= 1000 {
+		return millilitres / 1000, "l"
+	}
+	return millilitres, "ml"
+}
+
+func imperialWeight(grams float64) (float64, string) {
+	ounces := grams / convertibleUnits["oz"].base
+	if ounces >= 16 {
+		return ounces / 16, "lb"
+	}
+	return ounces, "oz"
+}
+
+func imperialVolume(millilitres float64) (float64, string) {
+	switch {
+	case millilitres < 15:
+		return millilitres / 5, "tsp"
+	case millilitres < cupMillilitres/4:
+		return millilitres / 15, "tbsp"
+	default:
+		return millilitres / cupMillilitres, "cup"
+	}
+}
+
+// formatConverted rounds a converted amount: grams and millilitres to the
+// nearest 5 above 100, everything else as formatQuantity does. The 0 of a
+// range such as "0-1 cup" stays 0.
+func formatConverted(value float64, unit string) string {
+	if value == 0 {
+		return "0"
+	}
+	if (unit == "g" || unit == "ml") && value >= 100 {
+		return strconv.FormatFloat(math.Round(value/5)*5, 'f', -1, 64)
+	}
+	return formatQuantity(value, unit)
+}
+
+// convertTemperature converts to Fahrenheit (from Celsius) or to Celsius,
+// rounded the way oven dials are marked
+func convertTemperature(value float64, celsius bool) string {
+	if celsius {
+		fahrenheit := value*9/5 + 32
+		if fahrenheit >= 250 {
+			return strconv.FormatFloat(math.Round(fahrenheit/25)*25, 'f', -1, 64)
+		}
+		return strconv.FormatFloat(math.Round(fahrenheit/5)*5, 'f', -1, 64)
+	}
+
+	converted := (value - 32) * 5 / 9
+	if converted >= 100 {
+		return strconv.FormatFloat(math.Round(converted/10)*10, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(math.Round(converted), 'f', -1, 64)
+}
+
+// inUnitSystem reports whether unit belongs to the given system
+func inUnitSystem(unit, system string) bool {
+	measure, ok := convertibleUnits[unit]
+	return ok && measure.metric == (system == unitsMetric)
+}
+
+// ingredientDensity returns the grams per cup of the longest pantry item
+// named in name, e.g. "brown sugar" rather than "sugar"
+func ingredientDensity(name string) (float64, bool) {
+	match := longestNamed(name, func(item string) bool {
+		_, ok := ingredientDensities[item]
+		return ok
+	})
+	if match == "" {
+		return 0, false
+	}
+	return ingredientDensities[match], true
+}
+
+// isLiquid reports whether name is a liquid, measured by volume
+func isLiquid(name string) bool {
+	match := longestNamed(name, func(item string) bool {
+		_, ok := liquidIngredients[item]
+		return ok
+	})
+	return liquidIngredients[match]
+}
+
+// longestNamed returns the longest run of words in name, ignoring case, that
+// known accepts, or "" if there is none
+func longestNamed(name string, known func(item string) bool) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return r == ' ' || r == ',' || r == '(' || r == ')'
+	})
+
+	match := ""
+	for i := range words {
+		for j := i + 1; j <= len(words); j++ {
+			if item := strings.Join(words[i:j], " "); len(item) > len(match) && known(item) {
+				match = item
+			}
+		}
+	}
+	return match
+}
@@ -120,14 +120,14 @@ function RecipeFilters({ onFilterChange, recipeType }) {
           <div style={styles.tagContainer}>
             {availableTags.map(tag => (
               <button
-                key={tag}
-                onClick={() => handleTagToggle(tag)}
+                key={tag.name}
+                onClick={() => handleTagToggle(tag.name)}
                 style={{
                   ...styles.tagButton,
-                  ...(selectedTags.includes(tag) ? styles.tagButtonActive : {})
+                  ...(selectedTags.includes(tag.name) ? styles.tagButtonActive : {})
                 }}
               >
-                {tag}
+                {tag.name} ({tag.count})
               </button>
             ))}
           </div>